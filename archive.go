@@ -0,0 +1,479 @@
+package pepeunit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ratioCheckThreshold is the extracted size below which the compression ratio is not enforced
+const ratioCheckThreshold = 1 << 20
+
+// ExtractLimits bounds the resources an archive may use when extracted, zero values disable a limit
+type ExtractLimits struct {
+	MaxTotalSize        int64
+	MaxFiles            int
+	MaxCompressionRatio float64
+}
+
+// DefaultExtractLimits returns the limits used by ExtractTarGz
+func DefaultExtractLimits() ExtractLimits {
+	return ExtractLimits{
+		MaxTotalSize:        DefaultExtractMaxTotalSize,
+		MaxFiles:            DefaultExtractMaxFiles,
+		MaxCompressionRatio: DefaultExtractMaxCompressionRatio,
+	}
+}
+
+// ExtractTarGz extracts an update archive to a destination directory with the default limits.
+// Despite the name every format supported by ExtractArchive is accepted.
+func (fm *FileManager) ExtractTarGz(archivePath, destDir string) error {
+	return fm.ExtractArchiveWithLimits(archivePath, destDir, DefaultExtractLimits())
+}
+
+// ExtractTarGzWithLimits extracts an update archive to a destination directory with custom limits
+func (fm *FileManager) ExtractTarGzWithLimits(archivePath, destDir string, limits ExtractLimits) error {
+	return fm.ExtractArchiveWithLimits(archivePath, destDir, limits)
+}
+
+// ExtractArchive extracts a zip, plain tar or compressed tar archive with the default limits
+func (fm *FileManager) ExtractArchive(archivePath, destDir string) error {
+	return fm.ExtractArchiveWithLimits(archivePath, destDir, DefaultExtractLimits())
+}
+
+// ExtractArchiveWithLimits detects the archive format by its magic bytes and extracts it to a destination directory.
+// Entries must stay inside destDir, symlink and hardlink targets included; modes and mtimes are preserved.
+func (fm *FileManager) ExtractArchiveWithLimits(archivePath, destDir string, limits ExtractLimits) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	compressed := &countingReader{reader: file}
+	bufReader := bufio.NewReaderSize(compressed, 4096)
+	header, err := bufReader.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if bytes.HasPrefix(header, zipMagic) || bytes.HasPrefix(header, zipEmptyMagic) {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		extractor, err := newArchiveExtractor(destDir, limits, &countingReader{count: info.Size()})
+		if err != nil {
+			return err
+		}
+		return extractor.extractZip(file, info.Size())
+	}
+
+	extractor, err := newArchiveExtractor(destDir, limits, compressed)
+	if err != nil {
+		return err
+	}
+
+	// The ustar magic is checked first: short magics like the zlib header also match plain tar entry names
+	if len(header) >= tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic) {
+		// Plain tar is not compressed, so the compression ratio does not apply
+		extractor.compressed = nil
+		return extractor.extractTar(bufReader)
+	}
+
+	if decompressor := findDecompressor(header); decompressor != nil {
+		decompressed, err := decompressor.newReader(bufReader)
+		if err != nil {
+			return fmt.Errorf("failed to open %s archive: %v", decompressor.name, err)
+		}
+		defer decompressed.Close()
+		return extractor.extractTar(decompressed)
+	}
+
+	return fmt.Errorf("unknown archive format of %s", archivePath)
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+// archiveExtractor writes archive entries below root while enforcing containment and limits
+type archiveExtractor struct {
+	root       string
+	limits     ExtractLimits
+	compressed *countingReader
+	totalSize  int64
+	files      int
+	dirTimes   map[string]time.Time
+	dirModes   map[string]os.FileMode
+	symlinks   []string
+}
+
+// newArchiveExtractor creates an extractor for root, compressed counts the archive bytes read so far
+func newArchiveExtractor(root string, limits ExtractLimits, compressed *countingReader) (*archiveExtractor, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(absRoot, 0755); err != nil {
+		return nil, err
+	}
+	return &archiveExtractor{
+		root:       absRoot,
+		limits:     limits,
+		compressed: compressed,
+		dirTimes:   make(map[string]time.Time),
+		dirModes:   make(map[string]os.FileMode),
+	}, nil
+}
+
+// extractTar extracts all entries of a tar stream
+func (e *archiveExtractor) extractTar(r io.Reader) error {
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = e.dir(header.Name, os.FileMode(header.Mode), header.ModTime)
+		case tar.TypeReg:
+			err = e.file(header.Name, os.FileMode(header.Mode), header.ModTime, tarReader)
+		case tar.TypeSymlink:
+			err = e.symlink(header.Name, header.Linkname)
+		case tar.TypeLink:
+			err = e.hardlink(header.Name, header.Linkname)
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			err = fmt.Errorf("unsupported file type %q for %s", header.Typeflag, header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return e.finish()
+}
+
+// extractZip extracts all entries of a zip archive
+func (e *archiveExtractor) extractZip(r io.ReaderAt, size int64) error {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, entry := range zipReader.File {
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			err = e.dir(entry.Name, mode, entry.Modified)
+		case mode&os.ModeSymlink != 0:
+			err = e.zipSymlink(entry)
+		case mode.IsRegular():
+			if mode.Perm() == 0 {
+				mode |= 0644
+			}
+			err = e.zipFile(entry, mode)
+		default:
+			err = fmt.Errorf("unsupported file mode %v for %s", mode, entry.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return e.finish()
+}
+
+func (e *archiveExtractor) zipFile(entry *zip.File, mode os.FileMode) error {
+	rc, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return e.file(entry.Name, mode, entry.Modified, rc)
+}
+
+func (e *archiveExtractor) zipSymlink(entry *zip.File) error {
+	rc, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return e.symlink(entry.Name, string(target))
+}
+
+// resolve returns the destination path of an archive entry, rejecting paths leaving the root
+func (e *archiveExtractor) resolve(name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || strings.HasPrefix(name, string(filepath.Separator)) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("archive entry %s has an absolute path", name)
+	}
+	destPath := filepath.Join(e.root, name)
+	if !e.contains(destPath) {
+		return "", fmt.Errorf("archive entry %s escapes the destination directory", name)
+	}
+	if err := e.checkParents(destPath); err != nil {
+		return "", err
+	}
+	return destPath, nil
+}
+
+// contains reports whether path is the root or below it
+func (e *archiveExtractor) contains(path string) bool {
+	rel, err := filepath.Rel(e.root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// checkParents rejects paths whose existing parent directories are symlinks, so nothing is written through a link
+func (e *archiveExtractor) checkParents(destPath string) error {
+	rel, _ := filepath.Rel(e.root, filepath.Dir(destPath))
+	if rel == "." {
+		return nil
+	}
+	current := e.root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("archive entry path %s passes through a symlink", destPath)
+		}
+	}
+	return nil
+}
+
+// countFile enforces the file count limit, directories count as entries too
+func (e *archiveExtractor) countFile() error {
+	e.files++
+	if e.limits.MaxFiles > 0 && e.files > e.limits.MaxFiles {
+		return fmt.Errorf("archive has more than %d entries", e.limits.MaxFiles)
+	}
+	return nil
+}
+
+// countBytes enforces the total size and compression ratio limits
+func (e *archiveExtractor) countBytes(n int64) error {
+	e.totalSize += n
+	if e.limits.MaxTotalSize > 0 && e.totalSize > e.limits.MaxTotalSize {
+		return fmt.Errorf("archive exceeds the maximum extracted size of %d bytes", e.limits.MaxTotalSize)
+	}
+	if e.limits.MaxCompressionRatio > 0 && e.compressed != nil && e.totalSize > ratioCheckThreshold {
+		compressed := e.compressed.count
+		if compressed < 1 {
+			compressed = 1
+		}
+		if float64(e.totalSize)/float64(compressed) > e.limits.MaxCompressionRatio {
+			return fmt.Errorf("archive exceeds the maximum compression ratio of %.0f", e.limits.MaxCompressionRatio)
+		}
+	}
+	return nil
+}
+
+// removeExisting removes a non-directory entry at path so that it is replaced instead of written through
+func removeExisting(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("archive entry %s conflicts with a directory", path)
+	}
+	return os.Remove(path)
+}
+
+func (e *archiveExtractor) dir(name string, mode os.FileMode, modTime time.Time) error {
+	if err := e.countFile(); err != nil {
+		return err
+	}
+	destPath, err := e.resolve(name)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(destPath); err == nil && !info.IsDir() {
+		return fmt.Errorf("archive entry %s conflicts with an existing file", name)
+	}
+	if err := os.MkdirAll(destPath, 0755); err != nil {
+		return err
+	}
+	// Permissions and times are applied in finish, after the directory contents are written
+	e.dirModes[destPath] = mode.Perm()
+	e.dirTimes[destPath] = modTime
+	return nil
+}
+
+func (e *archiveExtractor) file(name string, mode os.FileMode, modTime time.Time, r io.Reader) error {
+	if err := e.countFile(); err != nil {
+		return err
+	}
+	destPath, err := e.resolve(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	if err := removeExisting(destPath); err != nil {
+		return err
+	}
+
+	outFile, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			if err := e.countBytes(int64(n)); err != nil {
+				outFile.Close()
+				return err
+			}
+			if _, err := outFile.Write(buf[:n]); err != nil {
+				outFile.Close()
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			outFile.Close()
+			return readErr
+		}
+	}
+	if err := outFile.Chmod(mode.Perm()); err != nil {
+		outFile.Close()
+		return err
+	}
+	if err := outFile.Close(); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		return os.Chtimes(destPath, modTime, modTime)
+	}
+	return nil
+}
+
+func (e *archiveExtractor) symlink(name, target string) error {
+	if err := e.countFile(); err != nil {
+		return err
+	}
+	destPath, err := e.resolve(name)
+	if err != nil {
+		return err
+	}
+	if target == "" || filepath.IsAbs(target) || strings.HasPrefix(target, "/") {
+		return fmt.Errorf("symlink %s has an absolute or empty target %s", name, target)
+	}
+	if !e.contains(filepath.Join(filepath.Dir(destPath), filepath.FromSlash(target))) {
+		return fmt.Errorf("symlink %s points outside the destination directory", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	if err := removeExisting(destPath); err != nil {
+		return err
+	}
+	e.symlinks = append(e.symlinks, destPath)
+	return os.Symlink(filepath.FromSlash(target), destPath)
+}
+
+func (e *archiveExtractor) hardlink(name, target string) error {
+	if err := e.countFile(); err != nil {
+		return err
+	}
+	destPath, err := e.resolve(name)
+	if err != nil {
+		return err
+	}
+	targetPath, err := e.resolve(target)
+	if err != nil {
+		return fmt.Errorf("hardlink %s: %v", name, err)
+	}
+	info, err := os.Lstat(targetPath)
+	if err != nil {
+		return fmt.Errorf("hardlink %s target %s not extracted: %v", name, target, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("hardlink %s target %s is not a regular file", name, target)
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	if err := removeExisting(destPath); err != nil {
+		return err
+	}
+	return os.Link(targetPath, destPath)
+}
+
+// finish checks that symlink chains resolve inside the root and applies directory permissions
+// and times, deepest directories first
+func (e *archiveExtractor) finish() error {
+	realRoot, err := filepath.EvalSymlinks(e.root)
+	if err != nil {
+		return err
+	}
+	for _, link := range e.symlinks {
+		resolved, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(realRoot, resolved)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("symlink %s resolves outside the destination directory", link)
+		}
+	}
+
+	dirs := make([]string, 0, len(e.dirModes))
+	for dir := range e.dirModes {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, dir := range dirs {
+		// Keep directories writable by the owner so updates can replace their contents later
+		if err := os.Chmod(dir, e.dirModes[dir]|0700); err != nil {
+			return err
+		}
+		if modTime := e.dirTimes[dir]; !modTime.IsZero() {
+			if err := os.Chtimes(dir, modTime, modTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
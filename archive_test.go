@@ -0,0 +1,199 @@
+package pepeunit_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+// extractArchive writes archive to a temporary directory and extracts it next to it into "dest"
+func extractArchive(t *testing.T, archive []byte, limits pepeunit.ExtractLimits) (string, error) {
+	t.Helper()
+	base := t.TempDir()
+	archivePath := filepath.Join(base, "firmware")
+	if err := os.WriteFile(archivePath, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+	destDir := filepath.Join(base, "dest")
+	return destDir, pepeunit.NewFileManager().ExtractArchiveWithLimits(archivePath, destDir, limits)
+}
+
+func buildTar(t *testing.T, entries ...pepeunittest.TarEntry) []byte {
+	t.Helper()
+	data, err := pepeunittest.BuildTar(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestExtractArchiveFormats(t *testing.T) {
+	files := map[string]string{"app/main": "binary", "env.json": "{}"}
+	tarGz, err := pepeunittest.BuildTarGz(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipped, err := pepeunittest.BuildZip(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := buildTar(t,
+		pepeunittest.TarEntry{Name: "app", Dir: true},
+		pepeunittest.TarEntry{Name: "app/main", Body: "binary"},
+		pepeunittest.TarEntry{Name: "env.json", Body: "{}"},
+	)
+
+	for name, archive := range map[string][]byte{"tar.gz": tarGz, "zip": zipped, "tar": plain} {
+		t.Run(name, func(t *testing.T) {
+			destDir, err := extractArchive(t, archive, pepeunit.DefaultExtractLimits())
+			if err != nil {
+				t.Fatalf("ExtractArchiveWithLimits() = %v", err)
+			}
+			got, err := pepeunittest.ReadTree(destDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(files) || got["app/main"] != "binary" || got["env.json"] != "{}" {
+				t.Errorf("extracted %v, want %v", got, files)
+			}
+		})
+	}
+
+	// "x^" is a valid zlib header, the ustar magic of a plain tar must win over it
+	zlibLooking := buildTar(t, pepeunittest.TarEntry{Name: "x^", Body: "not zlib"})
+	if destDir, err := extractArchive(t, zlibLooking, pepeunit.DefaultExtractLimits()); err != nil {
+		t.Errorf("ExtractArchiveWithLimits() of a tar starting with x^ = %v", err)
+	} else if data, err := os.ReadFile(filepath.Join(destDir, "x^")); err != nil || string(data) != "not zlib" {
+		t.Errorf("x^ = %q, %v", data, err)
+	}
+
+	if _, err := extractArchive(t, []byte("definitely not an archive"), pepeunit.DefaultExtractLimits()); err == nil || !strings.Contains(err.Error(), "unknown archive format") {
+		t.Errorf("ExtractArchiveWithLimits() of garbage = %v", err)
+	}
+}
+
+func TestExtractArchiveLinksInside(t *testing.T) {
+	archive := buildTar(t,
+		pepeunittest.TarEntry{Name: "app/main", Body: "binary"},
+		pepeunittest.TarEntry{Name: "current", Linkname: "app/main"},
+		pepeunittest.TarEntry{Name: "copy", Linkname: "app/main", Hardlink: true},
+	)
+	destDir, err := extractArchive(t, archive, pepeunit.DefaultExtractLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"current", "copy"} {
+		if data, err := os.ReadFile(filepath.Join(destDir, name)); err != nil || string(data) != "binary" {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+	if info, err := os.Lstat(filepath.Join(destDir, "current")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("current is not a symlink: %v, %v", info, err)
+	}
+}
+
+func TestExtractArchiveRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []pepeunittest.TarEntry
+		wantErr string
+	}{
+		{
+			name:    "parent traversal",
+			entries: []pepeunittest.TarEntry{{Name: "../evil", Body: "x"}},
+			wantErr: "escapes the destination directory",
+		},
+		{
+			name:    "absolute path",
+			entries: []pepeunittest.TarEntry{{Name: "/tmp/evil", Body: "x"}},
+			wantErr: "absolute path",
+		},
+		{
+			name:    "symlink escape",
+			entries: []pepeunittest.TarEntry{{Name: "link", Linkname: "../../etc"}},
+			wantErr: "points outside the destination directory",
+		},
+		{
+			name:    "absolute symlink",
+			entries: []pepeunittest.TarEntry{{Name: "link", Linkname: "/etc/passwd"}},
+			wantErr: "absolute or empty target",
+		},
+		{
+			name: "symlink chain escape",
+			entries: []pepeunittest.TarEntry{
+				{Name: "sub", Dir: true},
+				{Name: "sub/up", Linkname: ".."},
+				{Name: "top", Linkname: "sub/up/.."},
+			},
+			wantErr: "resolves outside the destination directory",
+		},
+		{
+			name: "write through symlink",
+			entries: []pepeunittest.TarEntry{
+				{Name: "sub", Dir: true},
+				{Name: "link", Linkname: "sub"},
+				{Name: "link/file", Body: "x"},
+			},
+			wantErr: "passes through a symlink",
+		},
+		{
+			name:    "hardlink escape",
+			entries: []pepeunittest.TarEntry{{Name: "link", Linkname: "../outside", Hardlink: true}},
+			wantErr: "escapes the destination directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destDir, err := extractArchive(t, buildTar(t, tt.entries...), pepeunit.DefaultExtractLimits())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ExtractArchiveWithLimits() = %v, want %q", err, tt.wantErr)
+			}
+			for _, name := range []string{"evil", "outside"} {
+				if _, err := os.Lstat(filepath.Join(filepath.Dir(destDir), name)); err == nil {
+					t.Errorf("%s written outside the destination directory", name)
+				}
+			}
+		})
+	}
+
+	zipped, err := pepeunittest.BuildZip(map[string]string{"a/../../evil": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := extractArchive(t, zipped, pepeunit.DefaultExtractLimits()); err == nil || !strings.Contains(err.Error(), "escapes the destination directory") {
+		t.Errorf("zip traversal = %v", err)
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	dirs := buildTar(t,
+		pepeunittest.TarEntry{Name: "a", Dir: true},
+		pepeunittest.TarEntry{Name: "b", Dir: true},
+		pepeunittest.TarEntry{Name: "c", Body: "x"},
+	)
+	if _, err := extractArchive(t, dirs, pepeunit.ExtractLimits{MaxFiles: 2}); err == nil || !strings.Contains(err.Error(), "more than 2 entries") {
+		t.Errorf("directories are not counted as entries: %v", err)
+	}
+
+	big := buildTar(t, pepeunittest.TarEntry{Name: "big", Body: strings.Repeat("x", 100)})
+	if _, err := extractArchive(t, big, pepeunit.ExtractLimits{MaxTotalSize: 64}); err == nil || !strings.Contains(err.Error(), "maximum extracted size") {
+		t.Errorf("total size limit = %v", err)
+	}
+
+	zeros := buildTar(t, pepeunittest.TarEntry{Name: "zeros", Body: strings.Repeat("\x00", 4<<20)})
+	bomb, err := pepeunittest.Gzip(zeros)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := extractArchive(t, bomb, pepeunit.ExtractLimits{MaxCompressionRatio: 10}); err == nil || !strings.Contains(err.Error(), "maximum compression ratio") {
+		t.Errorf("compression ratio limit = %v", err)
+	}
+	// A plain tar is not compressed, so the ratio limit does not apply to it
+	if _, err := extractArchive(t, zeros, pepeunit.ExtractLimits{MaxCompressionRatio: 1}); err != nil {
+		t.Errorf("plain tar with a ratio limit = %v", err)
+	}
+}
@@ -3,13 +3,12 @@ package pepeunit
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
@@ -18,42 +17,101 @@ import (
 
 // PepeunitClient is the main client for PepeUnit integration
 type PepeunitClient struct {
-	envFilePath          string
-	schemaFilePath       string
-	logFilePath          string
-	enableMQTT           bool
-	enableREST           bool
-	cycleSpeed           time.Duration
-	restartMode          RestartMode
-	ffVersionCheckEnable bool
-	ffConsoleLogEnable   bool
-	settings             *Settings
-	schema               *SchemaManager
-	logger               *Logger
-	mqttClient           MQTTClient
-	restClient           RESTClient
-	inputHandler         MQTTInputHandler
-	outputHandler        func(*PepeunitClient)
-	customUpdateHandler  func(*PepeunitClient, string) error
-	running              bool
-	lastStateSend        time.Time
-	mutex                sync.RWMutex
-	subscribedTopics     map[string]struct{}
+	envFilePath            string
+	schemaFilePath         string
+	logFilePath            string
+	enableMQTT             bool
+	enableREST             bool
+	cycleSpeed             time.Duration
+	restartMode            RestartMode
+	ffVersionCheckEnable   bool
+	ffConsoleLogEnable     bool
+	ffUpdateRollbackEnable bool
+	updateConfirmCycles    int
+	updateConfirmOnConnect bool
+	updateMaxBootAttempts  int
+	updatePending          bool
+	updateHistoryFilePath  string
+	mqttStatePaths         []string
+	updateMinVersion       string
+	extractLimits          ExtractLimits
+	updateApplyMode        UpdateApplyMode
+	updatePreserve         []string
+	restarter              Restarter
+	preRestartHook         func(ctx context.Context) error
+	firmwarePlatform       string
+	updateHookTimeout      time.Duration
+	updateJitter           time.Duration
+	deferredUpdate         *deferredUpdate
+	deferredTimer          *time.Timer
+	transferTimeout        time.Duration
+	transferMaxSize        int64
+	transfers              map[string]*mqttTransfer
+	transfersMutex         sync.Mutex
+	updateStatus           UpdateStatus
+	updateProgressSent     time.Time
+	updateMutex            sync.RWMutex
+	updateRunMutex         sync.Mutex
+	updateHistoryMutex     sync.Mutex
+	settings               *Settings
+	schema                 *SchemaManager
+	logger                 *Logger
+	mqttClient             MQTTClient
+	restClient             RESTClient
+	inputHandler           MQTTInputHandler
+	router                 *inputRouter
+	dispatcher             *inputDispatcher
+	events                 *eventHub
+	outputHandler          func(*PepeunitClient)
+	customUpdateHandler    func(*PepeunitClient, string) error
+	running                bool
+	closed                 bool
+	stopCycle              chan struct{}
+	handlersWG             sync.WaitGroup
+	scheduler              *taskScheduler
+	mutex                  sync.RWMutex
+	subscribedTopics       map[string]struct{}
 }
 
 // PepeunitClientConfig holds configuration for creating a PepeunitClient
 type PepeunitClientConfig struct {
-	EnvFilePath          string
-	SchemaFilePath       string
-	LogFilePath          string
-	EnableMQTT           bool
-	EnableREST           bool
-	CycleSpeed           time.Duration
-	RestartMode          RestartMode
-	FFVersionCheckEnable bool
-	FFConsoleLogEnable   bool
-	MQTTClient           MQTTClient
-	RESTClient           RESTClient
+	EnvFilePath            string
+	SchemaFilePath         string
+	LogFilePath            string
+	EnableMQTT             bool
+	EnableREST             bool
+	CycleSpeed             time.Duration
+	RestartMode            RestartMode
+	FFVersionCheckEnable   bool
+	FFConsoleLogEnable     bool
+	MQTTClient             MQTTClient
+	RESTClient             RESTClient
+	InputWorkers           int
+	InputQueueSize         int
+	InputOverflowPolicy    OverflowPolicy
+	FFUpdateRollbackEnable bool
+	UpdateConfirmCycles    int
+	UpdateConfirmOnConnect bool
+	UpdateMaxBootAttempts  int
+	DownloadRetries        int
+	DownloadRetryBackoff   time.Duration
+	UpdateHistoryFilePath  string
+	UpdateMinVersion       string
+	ExtractLimits          *ExtractLimits
+	UpdateApplyMode        UpdateApplyMode
+	UpdatePreserve         []string
+	Restarter              Restarter
+	PreRestartHook         func(ctx context.Context) error
+	FirmwarePlatform       string
+	UpdateHookTimeout      time.Duration
+	UpdateJitter           time.Duration
+	TransferTimeout        time.Duration
+	TransferMaxSize        int64
+	MQTTTLS                *MQTTTLSConfig
+	MQTTTransport          *MQTTTransportConfig
+	PublishQueue           *PublishQueueConfig
+	MQTTAvailability       *MQTTAvailabilityConfig
+	MQTTSession            *MQTTSessionConfig
 }
 
 // NewPepeunitClient creates a new PepeUnit client
@@ -76,6 +134,54 @@ func NewPepeunitClient(config PepeunitClientConfig) (*PepeunitClient, error) {
 	if config.RestartMode == "" {
 		config.RestartMode = RestartModeRestartExec
 	}
+	if config.UpdateConfirmCycles == 0 && !config.UpdateConfirmOnConnect {
+		// Without a confirmation a healthy update would be rolled back after a few restarts
+		if config.EnableMQTT {
+			config.UpdateConfirmOnConnect = true
+		} else {
+			config.UpdateConfirmCycles = DefaultUpdateConfirmCycles
+		}
+	}
+	if config.UpdateMaxBootAttempts <= 0 {
+		config.UpdateMaxBootAttempts = DefaultUpdateMaxBootAttempts
+	}
+	if config.ExtractLimits == nil {
+		limits := DefaultExtractLimits()
+		config.ExtractLimits = &limits
+	}
+	if config.Restarter == nil {
+		config.Restarter = restarterForMode(config.RestartMode)
+	}
+	if config.UpdateApplyMode == "" {
+		config.UpdateApplyMode = UpdateApplyModeOverlay
+	}
+	if config.TransferTimeout <= 0 {
+		config.TransferTimeout = DefaultTransferTimeout
+	}
+	if config.TransferMaxSize == 0 {
+		config.TransferMaxSize = DefaultTransferMaxSize
+	}
+	if config.UpdateHookTimeout <= 0 {
+		config.UpdateHookTimeout = DefaultUpdateHookTimeout
+	}
+	if config.FirmwarePlatform == "" {
+		config.FirmwarePlatform = CurrentPlatform()
+	}
+	if config.UpdateHistoryFilePath == "" {
+		config.UpdateHistoryFilePath = filepath.Join(filepath.Dir(config.LogFilePath), "update_history.json")
+	}
+	if config.UpdateMinVersion != "" {
+		if _, err := ParseSemVer(config.UpdateMinVersion); err != nil {
+			return nil, fmt.Errorf("UpdateMinVersion must be a semantic version: %v", err)
+		}
+	}
+
+	// A sync update interrupted by a crash may have left the unit directory moved aside
+	var recovered bool
+	var recoverErr error
+	if config.UpdateApplyMode == UpdateApplyModeSync {
+		recovered, recoverErr = NewFileManager().RecoverSyncDirectory(filepath.Dir(config.EnvFilePath))
+	}
 
 	// Initialize components
 	settings := NewSettings(config.EnvFilePath)
@@ -85,22 +191,66 @@ func NewPepeunitClient(config PepeunitClientConfig) (*PepeunitClient, error) {
 	}
 
 	logger := NewLogger(config.LogFilePath, nil, schema, settings, config.FFConsoleLogEnable)
+	if recoverErr != nil {
+		logger.Error(fmt.Sprintf("Failed to recover interrupted update sync: %v", recoverErr))
+	} else if recovered {
+		logger.Warning("Restored the unit directory moved aside by an interrupted update sync")
+	}
 
 	client := &PepeunitClient{
-		envFilePath:          config.EnvFilePath,
-		schemaFilePath:       config.SchemaFilePath,
-		logFilePath:          config.LogFilePath,
-		enableMQTT:           config.EnableMQTT,
-		enableREST:           config.EnableREST,
-		cycleSpeed:           config.CycleSpeed,
-		restartMode:          config.RestartMode,
-		ffVersionCheckEnable: config.FFVersionCheckEnable,
-		ffConsoleLogEnable:   config.FFConsoleLogEnable,
-		settings:             settings,
-		schema:               schema,
-		logger:               logger,
-		running:              false,
-		subscribedTopics:     make(map[string]struct{}),
+		envFilePath:            config.EnvFilePath,
+		schemaFilePath:         config.SchemaFilePath,
+		logFilePath:            config.LogFilePath,
+		enableMQTT:             config.EnableMQTT,
+		enableREST:             config.EnableREST,
+		cycleSpeed:             config.CycleSpeed,
+		restartMode:            config.RestartMode,
+		ffVersionCheckEnable:   config.FFVersionCheckEnable,
+		ffConsoleLogEnable:     config.FFConsoleLogEnable,
+		ffUpdateRollbackEnable: config.FFUpdateRollbackEnable,
+		updateConfirmCycles:    config.UpdateConfirmCycles,
+		updateConfirmOnConnect: config.UpdateConfirmOnConnect,
+		updateMaxBootAttempts:  config.UpdateMaxBootAttempts,
+		updateHistoryFilePath:  config.UpdateHistoryFilePath,
+		updateMinVersion:       config.UpdateMinVersion,
+		extractLimits:          *config.ExtractLimits,
+		updateApplyMode:        config.UpdateApplyMode,
+		updatePreserve:         config.UpdatePreserve,
+		restarter:              config.Restarter,
+		preRestartHook:         config.PreRestartHook,
+		firmwarePlatform:       config.FirmwarePlatform,
+		updateHookTimeout:      config.UpdateHookTimeout,
+		updateJitter:           config.UpdateJitter,
+		transferTimeout:        config.TransferTimeout,
+		transferMaxSize:        config.TransferMaxSize,
+		transfers:              make(map[string]*mqttTransfer),
+		settings:               settings,
+		schema:                 schema,
+		logger:                 logger,
+		running:                false,
+		subscribedTopics:       make(map[string]struct{}),
+	}
+
+	if client.ffUpdateRollbackEnable {
+		client.checkPendingUpdate()
+	}
+
+	client.loadUpdateStatus()
+	client.loadDeferredUpdate()
+	client.router = newInputRouter(schema)
+	client.events = newEventHub(logger)
+	client.trackUpdateStatus()
+	if client.updateConfirmOnConnect {
+		client.OnConnected(client.confirmUpdateOnConnect)
+	}
+	schema.OnUpdate(client.router.rebuild)
+	schema.OnUpdate(client.events.emitSchemaReloaded)
+
+	client.scheduler = newTaskScheduler(logger)
+	stateSchedule := &settingsIntervalSchedule{settings: settings, key: "PU_STATE_SEND_INTERVAL"}
+	err = client.AddTask(string(BaseOutputTopicTypeStatePepeunit), stateSchedule, client.baseMQTTOutputHandler, TaskOptions{Immediate: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register state task: %v", err)
 	}
 
 	// Initialize MQTT client
@@ -110,7 +260,54 @@ func NewPepeunitClient(config PepeunitClientConfig) (*PepeunitClient, error) {
 		} else {
 			client.mqttClient = NewPepeunitMQTTClient(settings, schema, logger)
 		}
+		if mqttClient, ok := client.mqttClient.(*PepeunitMQTTClient); ok {
+			if config.MQTTTLS != nil {
+				mqttClient.SetTLSConfig(*config.MQTTTLS)
+			}
+			if config.MQTTTransport != nil {
+				mqttClient.SetTransportConfig(*config.MQTTTransport)
+			}
+			if config.MQTTAvailability != nil {
+				mqttClient.SetAvailability(*config.MQTTAvailability)
+			}
+			if config.MQTTSession != nil {
+				sessionConfig := *config.MQTTSession
+				stateDir := filepath.Dir(config.UpdateHistoryFilePath)
+				if sessionConfig.ClientIDFilePath == "" {
+					sessionConfig.ClientIDFilePath = filepath.Join(stateDir, "mqtt_client_id.json")
+				}
+				if sessionConfig.StoreDir == "" {
+					sessionConfig.StoreDir = filepath.Join(stateDir, "mqtt_store")
+				}
+				if err := mqttClient.SetPersistentSession(sessionConfig); err != nil {
+					return nil, fmt.Errorf("failed to enable persistent MQTT session: %v", err)
+				}
+				client.mqttStatePaths = append(client.mqttStatePaths, sessionConfig.ClientIDFilePath, sessionConfig.StoreDir)
+			}
+			if config.PublishQueue != nil {
+				queueConfig := *config.PublishQueue
+				if queueConfig.FilePath == "" {
+					queueConfig.FilePath = filepath.Join(filepath.Dir(config.UpdateHistoryFilePath), "publish_queue.jsonl")
+				}
+				if err := mqttClient.SetPublishQueue(queueConfig); err != nil {
+					return nil, fmt.Errorf("failed to create publish queue: %v", err)
+				}
+				client.mqttStatePaths = append(client.mqttStatePaths, queueConfig.FilePath)
+			}
+		}
 		logger.SetMQTTClient(client.mqttClient)
+		if config.InputWorkers > 0 {
+			dispatcher, err := newInputDispatcher(config.InputWorkers, config.InputQueueSize, config.InputOverflowPolicy, client.handleInputMessage, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create input dispatcher: %v", err)
+			}
+			client.dispatcher = dispatcher
+			client.dispatcher.start()
+		}
+		client.mqttClient.SetInputHandler(client.inputHandlerFunc())
+		if notifier, ok := client.mqttClient.(ConnectionNotifier); ok {
+			notifier.SetConnectionHandlers(client.events.emitConnected, client.events.emitConnectionLost)
+		}
 	}
 
 	// Initialize REST client
@@ -120,8 +317,19 @@ func NewPepeunitClient(config PepeunitClientConfig) (*PepeunitClient, error) {
 		} else {
 			client.restClient = NewPepeunitRESTClient(settings)
 		}
+		if restClient, ok := client.restClient.(*PepeunitRESTClient); ok && (config.DownloadRetries != 0 || config.DownloadRetryBackoff != 0) {
+			retries := config.DownloadRetries
+			if retries == 0 {
+				retries = DefaultDownloadRetries
+			}
+			restClient.SetDownloadRetries(retries, config.DownloadRetryBackoff)
+		}
+		if notifier, ok := client.restClient.(DownloadProgressNotifier); ok {
+			notifier.SetDownloadProgressHandler(client.events.emitDownloadProgress)
+		}
 	}
 
+	client.scheduleDeferredUpdate(deferredUpdateRestoreDelay)
 	return client, nil
 }
 
@@ -146,16 +354,59 @@ func (c *PepeunitClient) SetCycleSpeed(speed time.Duration) error {
 	return nil
 }
 
-// UpdateDeviceProgram updates the device program from a tar.gz archive
+// UpdateDeviceProgram updates the device program from an archive in any format supported by ExtractArchive
 func (c *PepeunitClient) UpdateDeviceProgram(ctx context.Context, archivePath string) error {
+	if err := c.checkUnverifiedUpdate("UpdateDeviceProgramVerified"); err != nil {
+		return err
+	}
+	return c.updateDeviceProgram(ctx, UpdateEvent{Type: UpdateTypeArchive, Source: archivePath})
+}
+
+// UpdateDeviceProgramVerified updates the device program from an archive after checking its digest and signature
+func (c *PepeunitClient) UpdateDeviceProgramVerified(ctx context.Context, archivePath string, verification UpdateVerification) error {
+	return c.updateDeviceProgram(ctx, UpdateEvent{Type: UpdateTypeArchive, Source: archivePath, Verification: verification})
+}
+
+// updateDeviceProgram applies the archive of the event and restarts according to the restart mode
+func (c *PepeunitClient) updateDeviceProgram(ctx context.Context, event UpdateEvent) error {
+	if err := c.applyDeviceProgram(ctx, event); err != nil {
+		c.events.emitUpdateFailed(event, err)
+		return err
+	}
+	c.events.emitUpdateApplied(event)
+
+	switch c.restartMode {
+	case RestartModeEnvSchemaOnly:
+		c.logger.Info("Updating env and schema only, without restart")
+		return c.updateEnvSchemaOnly(ctx)
+	case RestartModeNoRestart:
+		c.logger.Info("Archive extracted, no restart or updates performed")
+		return nil
+	}
+	if err := c.restart(ctx); err != nil {
+		c.logger.Error(fmt.Sprintf("Failed to restart: %v", err))
+		return err
+	}
+	return nil
+}
+
+// applyDeviceProgram verifies and extracts the archive, runs its update hooks and copies its contents into the unit directory
+func (c *PepeunitClient) applyDeviceProgram(ctx context.Context, event UpdateEvent) error {
+	archivePath := event.Source
+	c.setUpdatePhase(event, UpdatePhaseVerifying, nil)
+	if err := c.verifyUpdate(archivePath, event.Verification); err != nil {
+		return err
+	}
+
 	tempExtractDir, err := os.MkdirTemp("", "pepeunit_update_*")
 	if err != nil {
 		return fmt.Errorf("failed to create temp directory: %v", err)
 	}
 	defer os.RemoveAll(tempExtractDir)
 
+	c.setUpdatePhase(event, UpdatePhaseExtracting, nil)
 	fm := NewFileManager()
-	err = fm.ExtractTarGz(archivePath, tempExtractDir)
+	err = fm.ExtractArchiveWithLimits(archivePath, tempExtractDir, c.extractLimits)
 	if err != nil {
 		return fmt.Errorf("failed to extract archive: %v", err)
 	}
@@ -165,51 +416,80 @@ func (c *PepeunitClient) UpdateDeviceProgram(ctx context.Context, archivePath st
 	if unitDir == "" {
 		unitDir = "."
 	}
-	if err := fm.CopyDirectoryContents(tempExtractDir, unitDir); err != nil {
-		return fmt.Errorf("failed to copy extracted contents: %v", err)
+	c.setUpdatePhase(event, UpdatePhaseApplying, nil)
+	if err := c.runUpdateHook(ctx, PreUpdateHookPath, tempExtractDir, unitDir, event); err != nil {
+		return fmt.Errorf("update aborted by pre-update hook: %v", err)
+	}
+	if c.updateApplyMode == UpdateApplyModeSync {
+		manifest, err := fm.SyncDirectory(tempExtractDir, unitDir, c.preservedPaths(unitDir, tempExtractDir))
+		if err != nil {
+			return fmt.Errorf("failed to sync extracted contents: %v", err)
+		}
+		c.logger.Info(fmt.Sprintf("Synced %s: %d added, %d changed, %d removed", unitDir, len(manifest.Added), len(manifest.Changed), len(manifest.Removed)))
+	} else {
+		if err := fm.CopyDirectoryContents(tempExtractDir, unitDir); err != nil {
+			return fmt.Errorf("failed to copy extracted contents: %v", err)
+		}
+		c.logger.Info(fmt.Sprintf("Copied directory contents from %s to %s", tempExtractDir, unitDir))
+	}
+	if err := c.runUpdateHook(ctx, PostUpdateHookPath, tempExtractDir, unitDir, event); err != nil {
+		c.logger.Error(fmt.Sprintf("Post-update hook failed: %v", err))
 	}
-	c.logger.Info(fmt.Sprintf("Copied directory contents from %s to %s", tempExtractDir, unitDir))
 	if err := os.Remove(archivePath); err == nil {
 		c.logger.Info(fmt.Sprintf("Archive removed %s", archivePath))
 	}
 	c.logger.Info("Success extract archive", true)
-	switch c.restartMode {
-	case RestartModeRestartPopen:
-		c.StopMainCycle()
-		c.logger.Info("Run new main cycle in other process")
-		executable, err := os.Executable()
+	return nil
+}
+
+// preservedPaths returns the patterns kept by a directory sync: the client's own files inside unitDir,
+// the running executable unless the extracted tree ships a replacement, and the configured UpdatePreserve patterns
+func (c *PepeunitClient) preservedPaths(unitDir, extractDir string) []string {
+	absUnitDir, _ := filepath.Abs(unitDir)
+	paths := []string{c.envFilePath, c.schemaFilePath, c.logFilePath, c.updateHistoryFilePath, c.deferredUpdateFilePath()}
+	paths = append(paths, c.mqttStatePaths...)
+	if executable, err := os.Executable(); err == nil {
+		paths = append(paths, backupPath(executable), pendingMarkerPath(executable))
+		// os.Executable is resolved through symlinks, so it is compared with the resolved unit directory
+		realUnitDir, err := filepath.EvalSymlinks(absUnitDir)
 		if err != nil {
-			c.logger.Error(fmt.Sprintf("Failed to get executable path: %v", err))
-			return nil
-		}
-		cmd := exec.Command(executable, os.Args[1:]...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Stdin = os.Stdin
-		_ = cmd.Start()
-		c.logger.Info("I`ll Be Back - stop this process")
-		os.Exit(0)
-	case RestartModeRestartExec:
-		c.StopMainCycle()
-		executable, err := os.Executable()
+			realUnitDir = absUnitDir
+		}
+		if rel, err := filepath.Rel(realUnitDir, executable); err == nil && !strings.HasPrefix(rel, "..") {
+			if _, err := os.Lstat(filepath.Join(extractDir, rel)); os.IsNotExist(err) {
+				paths = append(paths, filepath.Join(absUnitDir, rel))
+			}
+		}
+	}
+
+	preserve := append([]string{}, c.updatePreserve...)
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
 		if err != nil {
-			c.logger.Error(fmt.Sprintf("Failed to get executable path: %v", err))
-			return nil
+			continue
 		}
-		_ = syscall.Exec(executable, os.Args, os.Environ())
-	case RestartModeEnvSchemaOnly:
-		c.logger.Info("Updating env and schema only, without restart")
-		return c.updateEnvSchemaOnly(ctx)
-	case RestartModeNoRestart:
-		c.logger.Info("Archive extracted, no restart or updates performed")
-		return nil
+		rel, err := filepath.Rel(absUnitDir, absPath)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		preserve = append(preserve, filepath.ToSlash(rel))
+	}
+	return preserve
+}
+
+// reloadSettings reloads settings from the env file and notifies env reload subscribers
+func (c *PepeunitClient) reloadSettings() error {
+	oldSettings := c.settings.snapshot()
+	if err := c.settings.LoadFromFile(); err != nil {
+		return err
 	}
+	c.events.emitEnvReloaded(oldSettings, c.settings.snapshot())
 	return nil
 }
 
 // updateEnvSchemaOnly updates only environment and schema files
 func (c *PepeunitClient) updateEnvSchemaOnly(ctx context.Context) error {
-	err := c.settings.LoadFromFile()
+	err := c.reloadSettings()
 	if err != nil {
 		return fmt.Errorf("failed to reload settings: %v", err)
 	}
@@ -238,6 +518,7 @@ func (c *PepeunitClient) GetSystemState() map[string]interface{} {
 		"mem_alloc":         0,
 		"freq":              0,
 		"pu_commit_version": c.settings.PU_COMMIT_VERSION,
+		"update":            c.GetUpdateStatus(),
 	}
 
 	// Get memory information
@@ -263,53 +544,121 @@ func (c *PepeunitClient) SetMQTTInputHandler(handler MQTTInputHandler) {
 
 	c.inputHandler = handler
 	if c.mqttClient != nil {
-		// Create a combined handler that includes base functionality
-		combinedHandler := func(msg MQTTMessage) {
-			c.baseMQTTInputFunc(msg)
-			if c.inputHandler != nil {
-				c.inputHandler(msg)
-			}
-		}
-		c.mqttClient.SetInputHandler(combinedHandler)
+		c.mqttClient.SetInputHandler(c.inputHandlerFunc())
+	}
+}
+
+// inputHandlerFunc returns the handler installed on the MQTT client
+func (c *PepeunitClient) inputHandlerFunc() MQTTInputHandler {
+	if c.dispatcher != nil {
+		return c.dispatcher.submit
 	}
+	return c.handleInputMessage
+}
+
+// handleInputMessage runs base command handling, the custom input handler and the topic key router
+func (c *PepeunitClient) handleInputMessage(msg MQTTMessage) {
+	if !c.beginHandler() {
+		return
+	}
+	defer c.handlersWG.Done()
+
+	if c.handleTransferMessage(msg) {
+		return
+	}
+	c.baseMQTTInputFunc(msg)
+
+	c.mutex.RLock()
+	handler := c.inputHandler
+	c.mutex.RUnlock()
+	if handler != nil {
+		handler(msg)
+	}
+
+	c.router.dispatch(msg)
 }
 
 // baseMQTTInputFunc handles base MQTT input functionality
 func (c *PepeunitClient) baseMQTTInputFunc(msg MQTTMessage) {
-	topic := msg.Topic
-	payload := string(msg.Payload)
-
-	inputBaseTopic := c.schema.GetInputBaseTopic()
+	topicKey, ok := c.router.resolve(msg.Topic)
+	if !ok || !isBaseInputTopicKey(topicKey) {
+		return
+	}
 
-	for topicKey, topics := range inputBaseTopic {
-		for _, topicURL := range topics {
-			if topic == topicURL {
-				ctx := context.Background()
-				c.logger.Info(fmt.Sprintf("Get base MQTT command: %s", topicKey))
-				switch topicKey {
-				case string(BaseInputTopicTypeUpdatePepeunit):
-					c.handleUpdate(ctx, payload)
-				case string(BaseInputTopicTypeEnvUpdatePepeunit):
-					c.handleEnvUpdate(ctx)
-				case string(BaseInputTopicTypeSchemaUpdatePepeunit):
-					c.handleSchemaUpdate(ctx)
-				case string(BaseInputTopicTypeLogSyncPepeunit):
-					c.handleLogSync(ctx)
-				}
-				return
-			}
-		}
+	ctx := context.Background()
+	payload := string(msg.Payload)
+	c.logger.Info(fmt.Sprintf("Get base MQTT command: %s", topicKey))
+	switch topicKey {
+	case string(BaseInputTopicTypeUpdatePepeunit):
+		c.handleUpdate(ctx, payload)
+	case string(BaseInputTopicTypeEnvUpdatePepeunit):
+		c.handleEnvUpdate(ctx)
+	case string(BaseInputTopicTypeSchemaUpdatePepeunit):
+		c.handleSchemaUpdate(ctx)
+	case string(BaseInputTopicTypeLogSyncPepeunit):
+		c.handleLogSync(ctx)
 	}
 }
 
-// handleUpdate handles update requests
+// handleUpdate handles update requests, deferring them by the update jitter and maintenance window.
+// Accepted updates run in the background, so the MQTT callback keeps delivering messages while they download.
 func (c *PepeunitClient) handleUpdate(ctx context.Context, payload string) {
-	if !c.enableREST || c.restClient == nil {
-		c.logger.Warning("REST client not available for update")
+	if !c.acceptUpdate(payload) || c.deferUpdate(payload) {
+		return
+	}
+	if !c.beginHandler() {
 		return
 	}
+	go func() {
+		defer c.handlersWG.Done()
+		c.applyUpdatePayload(ctx, payload)
+	}()
+}
 
+// acceptUpdate applies the version policy to an update/pepeunit payload, a custom update handler decides on its own
+func (c *PepeunitClient) acceptUpdate(payload string) bool {
 	if c.customUpdateHandler != nil {
+		return true
+	}
+	var meta map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &meta); err != nil {
+		c.logger.Error(fmt.Sprintf("Failed to parse payload: %v", err))
+		return false
+	}
+
+	targetVersion, _ := meta["PU_COMMIT_VERSION"].(string)
+	// The lowest accepted version is enforced with or without FFVersionCheckEnable
+	if c.updateMinVersion != "" {
+		if err := c.checkUpdateMinVersion(targetVersion); err != nil {
+			c.logger.Warning(fmt.Sprintf("Update refused: %v", err))
+			return false
+		}
+	}
+	if c.ffVersionCheckEnable && targetVersion != "" {
+		decision := c.checkUpdateVersion(targetVersion, meta)
+		if decision.refused {
+			c.logger.Warning(fmt.Sprintf("Update refused: %s", decision.reason))
+			return false
+		}
+		if !decision.apply {
+			c.logger.Info(fmt.Sprintf("No update needed: %s", decision.reason))
+			return false
+		}
+		c.logger.Info(fmt.Sprintf("Update accepted: %s", decision.reason))
+	}
+	return true
+}
+
+// applyUpdatePayload runs the update of an update/pepeunit payload accepted by acceptUpdate, one update at a time
+func (c *PepeunitClient) applyUpdatePayload(ctx context.Context, payload string) {
+	c.updateRunMutex.Lock()
+	defer c.updateRunMutex.Unlock()
+
+	if c.customUpdateHandler != nil {
+		if !c.enableREST || c.restClient == nil {
+			c.logger.Warning("REST client not available for update")
+			return
+		}
 		if err := c.customUpdateHandler(c, payload); err != nil {
 			c.logger.Error(fmt.Sprintf("Failed to perform custom update: %v", err))
 		}
@@ -322,74 +671,90 @@ func (c *PepeunitClient) handleUpdate(ctx context.Context, payload string) {
 		return
 	}
 
-	if c.ffVersionCheckEnable {
-		if newVer, ok := meta["PU_COMMIT_VERSION"].(string); ok && newVer != "" {
-			if c.settings.PU_COMMIT_VERSION == newVer {
-				c.logger.Info("No update needed: current version = target version")
-				return
-			}
+	targetVersion, _ := meta["PU_COMMIT_VERSION"].(string)
+	if _, ok := meta["COMPILED_FIRMWARE_LINK"]; ok {
+		artifact, err := c.selectFirmware(meta)
+		if err != nil {
+			c.logger.Error(fmt.Sprintf("Update refused: %v", err))
+			return
+		}
+		event := UpdateEvent{
+			Type:         UpdateTypeBinary,
+			Source:       artifact.link,
+			Version:      targetVersion,
+			Verification: artifact.verification,
+			Patch:        artifact.patch,
+		}
+		// Units reachable only over MQTT receive their firmware with the MQTT file transfer
+		if !IsMQTTTransferSource(event.Source) && (!c.enableREST || c.restClient == nil) {
+			c.logger.Warning("REST client not available for update")
+			return
 		}
+		c.runBinaryUpdate(ctx, event)
+	} else {
+		c.logger.Warning("COMPILED_FIRMWARE_LINK is missing in update payload")
 	}
+}
 
-	linkVal, ok := meta["COMPILED_FIRMWARE_LINK"]
-	if ok {
-		// Update env and schema without restart to avoid preempting the binary swap
-		if err := c.updateEnvSchemaOnly(ctx); err != nil {
-			c.logger.Error(fmt.Sprintf("Failed to update env/schema: %v", err))
+// runBinaryUpdate updates env and schema, replaces the binary of an update payload and restarts
+func (c *PepeunitClient) runBinaryUpdate(ctx context.Context, event UpdateEvent) {
+	if err := c.events.emitUpdateStarted(event); err != nil {
+		c.logger.Warning(fmt.Sprintf("Update skipped: %v", err))
+		c.events.emitUpdateFailed(event, err)
+		return
+	}
+	// Update env and schema without restart to avoid preempting the binary swap
+	if err := c.updateEnvSchemaOnly(ctx); err != nil {
+		c.logger.Error(fmt.Sprintf("Failed to update env/schema: %v", err))
+		c.events.emitUpdateFailed(event, err)
+		return
+	}
+	if event.Source != "" {
+		if err := c.updateBinary(ctx, event); err != nil {
+			c.logger.Error(fmt.Sprintf("Failed to update binary: %v", err))
 			return
 		}
-		linkStr, _ := linkVal.(string)
-		if linkStr != "" {
-			if err := c.UpdateBinaryFromURL(ctx, linkStr); err != nil {
-				c.logger.Error(fmt.Sprintf("Failed to update binary: %v", err))
-				return
-			}
 
-			switch c.restartMode {
-			case RestartModeRestartPopen:
-				c.StopMainCycle()
-				executable, err := os.Executable()
-				if err != nil {
-					c.logger.Error(fmt.Sprintf("Failed to get executable path: %v", err))
-					return
-				}
-				cmd := exec.Command(executable, os.Args[1:]...)
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				cmd.Stdin = os.Stdin
-				cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-				if err := cmd.Start(); err != nil {
-					c.logger.Error(fmt.Sprintf("Failed to start new process: %v", err))
-					return
-				}
-				os.Exit(0)
-			case RestartModeRestartExec:
-				c.logger.Info("I`ll Be Back - replacing current process")
-				c.StopMainCycle()
-				executable, err := os.Executable()
-				if err != nil {
-					c.logger.Error(fmt.Sprintf("Failed to get executable path: %v", err))
-					return
-				}
-				if err := syscall.Exec(executable, os.Args, os.Environ()); err != nil {
-					c.logger.Error(fmt.Sprintf("Failed to exec new process: %v", err))
-					return
-				}
-			case RestartModeEnvSchemaOnly:
-				return
-			case RestartModeNoRestart:
-				return
-			}
+		if !c.restartsProcess() {
+			return
+		}
+		if err := c.restart(ctx); err != nil {
+			c.logger.Error(fmt.Sprintf("Failed to restart: %v", err))
 		}
-	} else {
-		c.logger.Warning("COMPILED_FIRMWARE_LINK is missing in update payload")
 	}
 }
 
+// UpdateBinaryFromURL downloads a new binary and replaces the current executable
 func (c *PepeunitClient) UpdateBinaryFromURL(ctx context.Context, firmwareURL string) error {
-	if !c.enableREST || c.restClient == nil {
-		return fmt.Errorf("REST client is not enabled or available")
+	if err := c.checkUnverifiedUpdate("UpdateBinaryFromURLVerified"); err != nil {
+		return err
+	}
+	return c.updateBinary(ctx, UpdateEvent{Type: UpdateTypeBinary, Source: firmwareURL})
+}
+
+// UpdateBinaryFromURLVerified downloads a new binary, checks its digest and signature and replaces the current executable
+func (c *PepeunitClient) UpdateBinaryFromURLVerified(ctx context.Context, firmwareURL string, verification UpdateVerification) error {
+	return c.updateBinary(ctx, UpdateEvent{Type: UpdateTypeBinary, Source: firmwareURL, Verification: verification})
+}
+
+// UpdateBinaryFromPatch builds the new binary from a BSDIFF40 patch against the current executable and replaces it.
+// When the current executable is not the patch base or patching fails, the full binary is downloaded from firmwareURL.
+func (c *PepeunitClient) UpdateBinaryFromPatch(ctx context.Context, firmwareURL string, patch UpdatePatch, verification UpdateVerification) error {
+	return c.updateBinary(ctx, UpdateEvent{Type: UpdateTypeBinary, Source: firmwareURL, Verification: verification, Patch: patch})
+}
+
+// updateBinary replaces the current executable and notifies update subscribers
+func (c *PepeunitClient) updateBinary(ctx context.Context, event UpdateEvent) error {
+	if err := c.replaceBinary(ctx, event); err != nil {
+		c.events.emitUpdateFailed(event, err)
+		return err
 	}
+	c.events.emitUpdateApplied(event)
+	return nil
+}
+
+// replaceBinary downloads the binary from the event source and swaps it with the current executable
+func (c *PepeunitClient) replaceBinary(ctx context.Context, event UpdateEvent) error {
 	executable, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("failed to get executable path: %v", err)
@@ -397,19 +762,43 @@ func (c *PepeunitClient) UpdateBinaryFromURL(ctx context.Context, firmwareURL st
 	dir := filepath.Dir(executable)
 	tempPath := filepath.Join(dir, filepath.Base(executable)+".new")
 
-	if err := c.restClient.DownloadFileFromURL(ctx, firmwareURL, tempPath); err != nil {
-		return err
+	c.setUpdatePhase(event, UpdatePhaseDownloading, nil)
+	patched := false
+	if event.Patch.Link != "" {
+		if err := c.patchBinary(ctx, executable, tempPath, event); err != nil {
+			c.logger.Warning(fmt.Sprintf("Delta update not applied, downloading full binary: %v", err))
+			c.setUpdatePhase(event, UpdatePhaseDownloading, nil)
+		} else {
+			c.logger.Info("Binary built from delta patch")
+			patched = true
+		}
+	}
+
+	// A patched binary is verified while patching
+	if !patched {
+		if err := c.fetchFile(ctx, event.Source, tempPath); err != nil {
+			return err
+		}
+
+		c.setUpdatePhase(event, UpdatePhaseVerifying, nil)
+		if err := c.verifyUpdate(tempPath, event.Verification); err != nil {
+			_ = os.Remove(tempPath)
+			return err
+		}
 	}
 
 	if err := os.Chmod(tempPath, 0755); err != nil {
 		return fmt.Errorf("failed to set executable permissions: %v", err)
 	}
 
-	if err := os.Rename(tempPath, executable); err != nil {
-		_ = os.Remove(executable)
-		if err2 := os.Rename(tempPath, executable); err2 != nil {
-			return fmt.Errorf("failed to replace binary: %v", err2)
-		}
+	if err := c.events.emitUpdateDownloaded(event); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	c.setUpdatePhase(event, UpdatePhaseApplying, nil)
+	if err := c.swapBinary(tempPath, executable, event); err != nil {
+		return err
 	}
 
 	c.logger.Info("Binary file downloaded successfully")
@@ -423,7 +812,7 @@ func (c *PepeunitClient) handleEnvUpdate(ctx context.Context) {
 		if err != nil {
 			c.logger.Error(fmt.Sprintf("Failed to update env: %v", err))
 		} else {
-			c.settings.LoadFromFile()
+			c.reloadSettings()
 			c.logger.Info("Success update env")
 		}
 	} else {
@@ -499,7 +888,7 @@ func (c *PepeunitClient) DownloadEnv(ctx context.Context, filePath string) error
 		return err
 	}
 
-	c.settings.LoadFromFile()
+	c.reloadSettings()
 	c.logger.Info("Success update env")
 	return nil
 }
@@ -555,6 +944,19 @@ func (c *PepeunitClient) GetStateStorage(ctx context.Context) (string, error) {
 
 // PerformUpdate performs a complete update cycle
 func (c *PepeunitClient) PerformUpdate(ctx context.Context) error {
+	if err := c.checkUnverifiedUpdate("PerformUpdateVerified"); err != nil {
+		return err
+	}
+	return c.performUpdate(ctx, UpdateVerification{})
+}
+
+// PerformUpdateVerified performs a complete update cycle, checking the digest and signature of the downloaded archive
+func (c *PepeunitClient) PerformUpdateVerified(ctx context.Context, verification UpdateVerification) error {
+	return c.performUpdate(ctx, verification)
+}
+
+// performUpdate downloads the firmware archive of the unit and applies it
+func (c *PepeunitClient) performUpdate(ctx context.Context, verification UpdateVerification) error {
 	if !c.enableMQTT || !c.enableREST {
 		return fmt.Errorf("both MQTT and REST clients must be enabled for perform_update")
 	}
@@ -566,13 +968,28 @@ func (c *PepeunitClient) PerformUpdate(ctx context.Context) error {
 	}
 
 	archivePath := filepath.Join(tempDir, fmt.Sprintf("update_%s.tar.gz", unitUUID))
+	event := UpdateEvent{Type: UpdateTypeArchive, Source: archivePath, Verification: verification}
+
+	if err := c.events.emitUpdateStarted(event); err != nil {
+		c.events.emitUpdateFailed(event, err)
+		return err
+	}
 
+	c.setUpdatePhase(event, UpdatePhaseDownloading, nil)
 	err = c.DownloadUpdate(ctx, archivePath)
 	if err != nil {
-		return fmt.Errorf("failed to download update: %v", err)
+		err = fmt.Errorf("failed to download update: %v", err)
+		c.events.emitUpdateFailed(event, err)
+		return err
+	}
+
+	if err := c.events.emitUpdateDownloaded(event); err != nil {
+		_ = os.Remove(archivePath)
+		c.events.emitUpdateFailed(event, err)
+		return err
 	}
 
-	err = c.UpdateDeviceProgram(ctx, archivePath)
+	err = c.updateDeviceProgram(ctx, event)
 	if err != nil {
 		return fmt.Errorf("failed to update device program: %v", err)
 	}
@@ -675,75 +1092,99 @@ func (c *PepeunitClient) PublishToTopics(ctx context.Context, topicKey, message
 	return nil
 }
 
-// baseMQTTOutputHandler handles base MQTT output functionality
-func (c *PepeunitClient) baseMQTTOutputHandler(ctx context.Context) {
-	currentTime := time.Now()
+// baseMQTTOutputHandler handles base MQTT output functionality, runs as a scheduled task
+func (c *PepeunitClient) baseMQTTOutputHandler(ctx context.Context, client *PepeunitClient) error {
+	if !c.enableMQTT || c.mqttClient == nil {
+		return nil
+	}
+	return c.publishState()
+}
 
+// publishState publishes the current system state to the state base topic
+func (c *PepeunitClient) publishState() error {
 	outputBaseTopic := c.schema.GetOutputBaseTopic()
-	if topics, ok := outputBaseTopic[string(BaseOutputTopicTypeStatePepeunit)]; ok && len(topics) > 0 {
-		c.mutex.RLock()
-		shouldSend := currentTime.Sub(c.lastStateSend) >= time.Duration(c.settings.PU_STATE_SEND_INTERVAL)*time.Second
-		c.mutex.RUnlock()
-
-		if shouldSend {
-			stateData := c.GetSystemState()
-			stateJSON, err := json.Marshal(stateData)
-			if err != nil {
-				c.logger.Error(fmt.Sprintf("Failed to marshal state data: %v", err))
-				return
-			}
+	topics, ok := outputBaseTopic[string(BaseOutputTopicTypeStatePepeunit)]
+	if !ok || len(topics) == 0 || c.mqttClient == nil {
+		return nil
+	}
 
-			if c.mqttClient != nil {
-				err = c.mqttClient.Publish(topics[0], string(stateJSON))
-				if err != nil {
-					c.logger.Error(fmt.Sprintf("Failed to publish state: %v", err))
-				} else {
-					c.mutex.Lock()
-					c.lastStateSend = currentTime
-					c.mutex.Unlock()
-				}
-			}
-		}
+	stateJSON, err := json.Marshal(c.GetSystemState())
+	if err != nil {
+		return fmt.Errorf("failed to marshal state data: %v", err)
 	}
+	if err := c.mqttClient.Publish(topics[0], string(stateJSON)); err != nil {
+		return fmt.Errorf("failed to publish state: %v", err)
+	}
+	return nil
 }
 
 // RunMainCycle starts the main application loop
 func (c *PepeunitClient) RunMainCycle(ctx context.Context, outputHandler func(*PepeunitClient)) {
+	if !c.beginHandler() {
+		return
+	}
+	defer c.handlersWG.Done()
+
+	stopCycle := make(chan struct{})
 	c.mutex.Lock()
 	c.running = true
+	c.stopCycle = stopCycle
 	c.outputHandler = outputHandler
 	c.mutex.Unlock()
 
 	defer func() {
 		c.mutex.Lock()
 		c.running = false
+		if c.stopCycle == stopCycle {
+			c.stopCycle = nil
+		}
 		c.mutex.Unlock()
 	}()
 
 	ticker := time.NewTicker(c.cycleSpeed)
 	defer ticker.Stop()
 
+	cycles := 0
 	for {
 		select {
 		case <-ctx.Done():
 			c.logger.Info("Main cycle stopped")
 			return
+		case <-stopCycle:
+			return
 		case <-ticker.C:
 			if !c.isRunning() {
 				return
 			}
 
-			// Handle base MQTT output
-			c.baseMQTTOutputHandler(ctx)
+			cycles++
+			c.autoConfirmUpdate(cycles)
+
+			// Handle scheduled tasks, including base MQTT output
+			c.scheduler.runDue(ctx, c, time.Now())
 
 			// Handle custom output
-			if c.outputHandler != nil {
-				c.outputHandler(c)
+			c.mutex.RLock()
+			handler := c.outputHandler
+			c.mutex.RUnlock()
+			if handler != nil {
+				handler(c)
 			}
 		}
 	}
 }
 
+// beginHandler registers a running handler, returns false once the client is closed
+func (c *PepeunitClient) beginHandler() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return false
+	}
+	c.handlersWG.Add(1)
+	return true
+}
+
 // SetOutputHandler sets the custom output message handler
 func (c *PepeunitClient) SetOutputHandler(outputHandler func(*PepeunitClient)) {
 	c.mutex.Lock()
@@ -763,9 +1204,63 @@ func (c *PepeunitClient) StopMainCycle() {
 	c.logger.Info("Run Stop Main cycle")
 	c.mutex.Lock()
 	c.running = false
+	if c.stopCycle != nil {
+		close(c.stopCycle)
+		c.stopCycle = nil
+	}
 	c.mutex.Unlock()
 }
 
+// Close stops accepting input, waits for running handlers until the context deadline,
+// publishes the final state, flushes the log file and disconnects from the MQTT broker
+func (c *PepeunitClient) Close(ctx context.Context) error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mutex.Unlock()
+
+	c.StopMainCycle()
+	c.stopDeferredUpdate()
+	if c.dispatcher != nil {
+		c.dispatcher.stop()
+	}
+
+	var errs []error
+	drained := make(chan struct{})
+	go func() {
+		c.handlersWG.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("failed to wait for running handlers: %v", ctx.Err()))
+	}
+
+	if c.enableMQTT && c.mqttClient != nil {
+		if err := c.publishState(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	c.logger.Info("Client closed", true)
+	if err := c.logger.Flush(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to flush log: %v", err))
+	}
+
+	if c.enableMQTT && c.mqttClient != nil {
+		c.logger.SetMQTTClient(nil)
+		if err := c.mqttClient.Disconnect(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to disconnect from MQTT: %v", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
 // isRunning checks if the main cycle is running
 func (c *PepeunitClient) isRunning() bool {
 	c.mutex.RLock()
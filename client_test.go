@@ -0,0 +1,106 @@
+package pepeunit_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+// blockInput registers an input/pepeunit handler that blocks until release is closed
+// and returns a channel closed once the handler has started
+func blockInput(client *pepeunit.PepeunitClient, release <-chan struct{}) <-chan struct{} {
+	started := make(chan struct{})
+	client.HandleInput("input/pepeunit", func(msg pepeunit.MQTTMessage) {
+		close(started)
+		<-release
+	})
+	return started
+}
+
+func TestCloseDrainsRunningHandlers(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	started := blockInput(client, release)
+	go fixture.MQTT.Inject(fixture.InputTopic("input/pepeunit"), "1")
+	<-started
+
+	closed := make(chan error, 1)
+	go func() { closed <- client.Close(context.Background()) }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned %v while a handler is running", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-closed; err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	stateTopic := pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseOutputTopicTypeStatePepeunit))
+	if len(fixture.MQTT.PublishedTo(stateTopic)) == 0 {
+		t.Error("final state not published")
+	}
+	if fixture.MQTT.IsConnected() {
+		t.Error("MQTT client still connected after Close")
+	}
+}
+
+func TestCloseDeadline(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	started := blockInput(client, release)
+	go fixture.MQTT.Inject(fixture.InputTopic("input/pepeunit"), "1")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = client.Close(ctx)
+	if err == nil || !strings.Contains(err.Error(), "failed to wait for running handlers") {
+		t.Errorf("Close() = %v, want handler wait error", err)
+	}
+}
+
+func TestClosedClientRejectsWork(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handled := 0
+	client.HandleInput("input/pepeunit", func(msg pepeunit.MQTTMessage) { handled++ })
+	if err := client.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(context.Background()); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+
+	fixture.MQTT.Inject(fixture.InputTopic("input/pepeunit"), "1")
+	if handled != 0 {
+		t.Errorf("closed client handled %d messages", handled)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		client.RunMainCycle(context.Background(), nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunMainCycle of a closed client did not return")
+	}
+}
@@ -0,0 +1,83 @@
+package pepeunit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	zipMagic       = []byte("PK\x03\x04")
+	zipEmptyMagic  = []byte("PK\x05\x06")
+	tarMagic       = []byte("ustar")
+	tarMagicOffset = 257
+)
+
+// decompressor opens a compressed tar stream recognised by its leading bytes
+type decompressor struct {
+	name      string
+	match     func(header []byte) bool
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	decompressors   []decompressor
+	decompressorsMu sync.RWMutex
+)
+
+func init() {
+	registerDecompressor("gzip", magicMatcher([]byte{0x1f, 0x8b}), func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	})
+	registerDecompressor("zstd", magicMatcher([]byte{0x28, 0xb5, 0x2f, 0xfd}), func(r io.Reader) (io.ReadCloser, error) {
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	})
+	registerDecompressor("zlib", isZlibHeader, zlib.NewReader)
+}
+
+// RegisterDecompressor adds a decompressor for tar archives starting with magic.
+// Decompressors registered later take precedence, so a built-in format can be replaced.
+func RegisterDecompressor(name string, magic []byte, newReader func(r io.Reader) (io.ReadCloser, error)) {
+	registerDecompressor(name, magicMatcher(append([]byte{}, magic...)), newReader)
+}
+
+func registerDecompressor(name string, match func([]byte) bool, newReader func(io.Reader) (io.ReadCloser, error)) {
+	decompressorsMu.Lock()
+	defer decompressorsMu.Unlock()
+	decompressors = append([]decompressor{{name: name, match: match, newReader: newReader}}, decompressors...)
+}
+
+// findDecompressor returns the decompressor matching the archive header, nil when none does
+func findDecompressor(header []byte) *decompressor {
+	decompressorsMu.RLock()
+	defer decompressorsMu.RUnlock()
+	for i := range decompressors {
+		if decompressors[i].match(header) {
+			found := decompressors[i]
+			return &found
+		}
+	}
+	return nil
+}
+
+func magicMatcher(magic []byte) func([]byte) bool {
+	return func(header []byte) bool {
+		return len(magic) > 0 && bytes.HasPrefix(header, magic)
+	}
+}
+
+// isZlibHeader checks the RFC 1950 header: deflate method and a valid check value
+func isZlibHeader(header []byte) bool {
+	if len(header) < 2 {
+		return false
+	}
+	return header[0]&0x0f == 8 && header[0]>>4 <= 7 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
@@ -0,0 +1,203 @@
+package pepeunit
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// bsdiffMagic starts a BSDIFF40 patch
+const bsdiffMagic = "BSDIFF40"
+
+// bsdiffHeaderSize is the size of the BSDIFF40 header: magic, control and diff block lengths and the new size
+const bsdiffHeaderSize = 32
+
+// bspatchBufferSize is the chunk size used when adding diff bytes to the old file
+const bspatchBufferSize = 32 * 1024
+
+// UpdatePatch describes a binary diff from the running executable to the new one
+type UpdatePatch struct {
+	Link       string
+	BaseSHA256 string
+}
+
+// updatePatchFromPayload reads the top-level patch fields of an update payload
+func updatePatchFromPayload(meta map[string]interface{}) UpdatePatch {
+	link, _ := meta["COMPILED_FIRMWARE_PATCH_LINK"].(string)
+	base, _ := meta["COMPILED_FIRMWARE_PATCH_BASE_SHA256"].(string)
+	return UpdatePatch{Link: link, BaseSHA256: base}
+}
+
+// ApplyBSDiffPatch writes newPath by applying a BSDIFF40 patch (bzip2 compressed blocks, as produced by bsdiff) to oldPath
+func (fm *FileManager) ApplyBSDiffPatch(oldPath, patchPath, newPath string) error {
+	oldFile, err := os.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer oldFile.Close()
+	oldInfo, err := oldFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	patchFile, err := os.Open(patchPath)
+	if err != nil {
+		return err
+	}
+	defer patchFile.Close()
+	patchInfo, err := patchFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	newFile, err := os.OpenFile(newPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(newFile)
+	err = bspatch(oldFile, oldInfo.Size(), patchFile, patchInfo.Size(), writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = newFile.Sync()
+	}
+	if closeErr := newFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(newPath)
+		return err
+	}
+	return nil
+}
+
+// bspatch streams the file produced by a BSDIFF40 patch to w
+func bspatch(old io.ReaderAt, oldSize int64, patch io.ReaderAt, patchSize int64, w io.Writer) error {
+	header := make([]byte, bsdiffHeaderSize)
+	if _, err := patch.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("failed to read patch header: %v", err)
+	}
+	if string(header[:8]) != bsdiffMagic {
+		return fmt.Errorf("not a BSDIFF40 patch")
+	}
+	ctrlLen := offtin(header[8:16])
+	diffLen := offtin(header[16:24])
+	newSize := offtin(header[24:32])
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || bsdiffHeaderSize+ctrlLen+diffLen > patchSize {
+		return fmt.Errorf("corrupt patch header")
+	}
+
+	ctrl := bzip2.NewReader(io.NewSectionReader(patch, bsdiffHeaderSize, ctrlLen))
+	diff := bzip2.NewReader(io.NewSectionReader(patch, bsdiffHeaderSize+ctrlLen, diffLen))
+	extraOffset := bsdiffHeaderSize + ctrlLen + diffLen
+	extra := bzip2.NewReader(io.NewSectionReader(patch, extraOffset, patchSize-extraOffset))
+
+	buf := make([]byte, bspatchBufferSize)
+	oldBuf := make([]byte, bspatchBufferSize)
+	triple := make([]byte, 24)
+	var oldPos, newPos int64
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, triple); err != nil {
+			return fmt.Errorf("corrupt patch control block: %v", err)
+		}
+		diffCount, extraCount, seek := offtin(triple[0:8]), offtin(triple[8:16]), offtin(triple[16:24])
+		if diffCount < 0 || extraCount < 0 || newPos+diffCount+extraCount > newSize {
+			return fmt.Errorf("corrupt patch control block")
+		}
+
+		// New bytes are diff bytes added to the old bytes at the same offset, bytes outside the old file add nothing
+		for done := int64(0); done < diffCount; {
+			n := diffCount - done
+			if n > bspatchBufferSize {
+				n = bspatchBufferSize
+			}
+			if _, err := io.ReadFull(diff, buf[:n]); err != nil {
+				return fmt.Errorf("corrupt patch diff block: %v", err)
+			}
+			start, end := oldPos+done, oldPos+done+n
+			if start < 0 {
+				start = 0
+			}
+			if end > oldSize {
+				end = oldSize
+			}
+			if start < end {
+				if _, err := old.ReadAt(oldBuf[:end-start], start); err != nil {
+					return fmt.Errorf("failed to read old file: %v", err)
+				}
+				for i := start; i < end; i++ {
+					buf[i-oldPos-done] += oldBuf[i-start]
+				}
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			done += n
+		}
+		newPos += diffCount
+		oldPos += diffCount
+
+		if _, err := io.CopyN(w, extra, extraCount); err != nil {
+			return fmt.Errorf("corrupt patch extra block: %v", err)
+		}
+		newPos += extraCount
+		oldPos += seek
+	}
+	return nil
+}
+
+// offtin decodes the sign-magnitude little-endian integers of a BSDIFF40 patch
+func offtin(b []byte) int64 {
+	value := int64(binary.LittleEndian.Uint64(b) &^ (1 << 63))
+	if b[7]&0x80 != 0 {
+		return -value
+	}
+	return value
+}
+
+// patchBinary produces the new executable at newPath from the running one and the patch of the event.
+// A patch is only used when its result can be checked with a target sha256 or the pinned update key.
+// It fails when the running executable is not the patch base or the result does not pass verification.
+func (c *PepeunitClient) patchBinary(ctx context.Context, executable, newPath string, event UpdateEvent) error {
+	if event.Patch.BaseSHA256 == "" {
+		return fmt.Errorf("patch base sha256 is missing")
+	}
+	if event.Verification.SHA256 == "" {
+		key, err := c.updatePublicKey()
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return fmt.Errorf("patch result can not be verified without a target sha256 or a pinned update key")
+		}
+	}
+	digest, err := fileSHA256(executable)
+	if err != nil {
+		return fmt.Errorf("failed to hash current executable: %v", err)
+	}
+	if actual := hex.EncodeToString(digest); !strings.EqualFold(actual, event.Patch.BaseSHA256) {
+		return fmt.Errorf("current executable %s is not the patch base %s", actual, event.Patch.BaseSHA256)
+	}
+
+	patchPath := executable + ".patch"
+	defer os.Remove(patchPath)
+	if err := c.fetchFile(ctx, event.Patch.Link, patchPath); err != nil {
+		return fmt.Errorf("failed to download patch: %v", err)
+	}
+	if err := NewFileManager().ApplyBSDiffPatch(executable, patchPath, newPath); err != nil {
+		return fmt.Errorf("failed to apply patch: %v", err)
+	}
+	c.setUpdatePhase(event, UpdatePhaseVerifying, nil)
+	if err := c.verifyUpdate(newPath, event.Verification); err != nil {
+		_ = os.Remove(newPath)
+		return fmt.Errorf("patched binary failed verification: %v", err)
+	}
+	return nil
+}
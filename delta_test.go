@@ -0,0 +1,100 @@
+package pepeunit_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+)
+
+// testdata/hello.bsdiff turns helloOld into helloNew: it copies and adds to two ranges of the old file,
+// skips "old" with a seek and takes "new" and "!\n" from the extra block
+const (
+	helloOld = "hello old world\n"
+	helloNew = "hello new World!\n"
+)
+
+func TestApplyBSDiffPatch(t *testing.T) {
+	patch, err := os.ReadFile(filepath.Join("testdata", "hello.bsdiff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withHeader := func(offset int, value uint64) []byte {
+		mutated := append([]byte{}, patch...)
+		binary.LittleEndian.PutUint64(mutated[offset:], value)
+		return mutated
+	}
+	extraOffset := 32 + binary.LittleEndian.Uint64(patch[8:]) + binary.LittleEndian.Uint64(patch[16:])
+
+	tests := []struct {
+		name    string
+		old     string
+		patch   []byte
+		want    string
+		wantErr string
+	}{
+		{name: "valid", old: helloOld, patch: patch, want: helloNew},
+		{name: "bad magic", old: helloOld, patch: append([]byte("BSDIFF41"), patch[8:]...), wantErr: "not a BSDIFF40 patch"},
+		{name: "short header", old: helloOld, patch: patch[:16], wantErr: "failed to read patch header"},
+		{name: "control block past the end", old: helloOld, patch: withHeader(8, 1<<40), wantErr: "corrupt patch header"},
+		{name: "negative new size", old: helloOld, patch: withHeader(24, 1<<63|5), wantErr: "corrupt patch header"},
+		{name: "new size too large", old: helloOld, patch: withHeader(24, uint64(len(helloNew)+8)), wantErr: "corrupt patch control block"},
+		{name: "new size too small", old: helloOld, patch: withHeader(24, 4), wantErr: "corrupt patch control block"},
+		{name: "truncated extra block", old: helloOld, patch: patch[:extraOffset+14], wantErr: "corrupt patch extra block"},
+		{name: "old file shorter than diff", old: "hello", patch: patch, want: patchedShortOld()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			oldPath := filepath.Join(dir, "old")
+			patchPath := filepath.Join(dir, "patch")
+			newPath := filepath.Join(dir, "new")
+			if err := os.WriteFile(oldPath, []byte(tt.old), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(patchPath, tt.patch, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := pepeunit.NewFileManager().ApplyBSDiffPatch(oldPath, patchPath, newPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ApplyBSDiffPatch() error = %v, want %q", err, tt.wantErr)
+				}
+				if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+					t.Errorf("partial output left after a failed patch: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyBSDiffPatch() error = %v", err)
+			}
+			data, err := os.ReadFile(newPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("patched = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+// patchedShortOld is the result of the hello patch on "hello": diff bytes past the end of the old file are taken as is
+func patchedShortOld() string {
+	diff := []byte(helloNew)
+	for i := 0; i < 6; i++ {
+		diff[i] -= helloOld[i]
+	}
+	for i := 0; i < 6; i++ {
+		diff[9+i] -= helloOld[9+i]
+	}
+	for i := 0; i < len("hello"); i++ {
+		diff[i] += "hello"[i]
+	}
+	return string(diff)
+}
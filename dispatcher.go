@@ -0,0 +1,179 @@
+package pepeunit
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// InputDispatcherStats holds counters of the MQTT input dispatcher
+type InputDispatcherStats struct {
+	Workers   int
+	Depth     int
+	Capacity  int
+	Processed uint64
+	Dropped   uint64
+}
+
+// inputDispatcher processes MQTT input messages on a bounded pool of workers.
+// Messages of the same topic always go to the same worker, which keeps their order.
+type inputDispatcher struct {
+	handler   MQTTInputHandler
+	logger    *Logger
+	queues    []chan MQTTMessage
+	overflow  OverflowPolicy
+	processed atomic.Uint64
+	dropped   atomic.Uint64
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// newInputDispatcher creates a dispatcher with a queue of queueSize messages per worker
+func newInputDispatcher(workers, queueSize int, overflow OverflowPolicy, handler MQTTInputHandler, logger *Logger) (*inputDispatcher, error) {
+	if queueSize <= 0 {
+		queueSize = DefaultInputQueueSize
+	}
+	if overflow == "" {
+		overflow = OverflowPolicyBlock
+	}
+	switch overflow {
+	case OverflowPolicyBlock, OverflowPolicyDrop, OverflowPolicyDropOldest:
+	default:
+		return nil, fmt.Errorf("unsupported input overflow policy %q, use %q, %q or %q", overflow, OverflowPolicyBlock, OverflowPolicyDrop, OverflowPolicyDropOldest)
+	}
+	d := &inputDispatcher{
+		handler:  handler,
+		logger:   logger,
+		queues:   make([]chan MQTTMessage, workers),
+		overflow: overflow,
+		done:     make(chan struct{}),
+	}
+	for i := range d.queues {
+		d.queues[i] = make(chan MQTTMessage, queueSize)
+	}
+	return d, nil
+}
+
+// start launches the workers
+func (d *inputDispatcher) start() {
+	for _, queue := range d.queues {
+		go d.work(queue)
+	}
+}
+
+// stop stops accepting messages and lets the workers exit after their current message,
+// queued messages are discarded and counted as dropped
+func (d *inputDispatcher) stop() {
+	d.stopOnce.Do(func() {
+		close(d.done)
+		for _, queue := range d.queues {
+			d.discard(queue)
+		}
+	})
+}
+
+// discard empties a queue counting its messages as dropped
+func (d *inputDispatcher) discard(queue chan MQTTMessage) {
+	for {
+		select {
+		case <-queue:
+			d.dropped.Add(1)
+		default:
+			return
+		}
+	}
+}
+
+// work processes the messages of a single queue
+func (d *inputDispatcher) work(queue chan MQTTMessage) {
+	for {
+		select {
+		case <-d.done:
+			d.discard(queue)
+			return
+		case msg := <-queue:
+			d.process(msg)
+		}
+	}
+}
+
+// process runs the handler for a message and recovers from panics
+func (d *inputDispatcher) process(msg MQTTMessage) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.logger.Error(fmt.Sprintf("Error processing MQTT message: %v", r))
+		}
+	}()
+	d.handler(msg)
+	d.processed.Add(1)
+}
+
+// submit enqueues a message applying the overflow policy when the queue is full
+func (d *inputDispatcher) submit(msg MQTTMessage) {
+	select {
+	case <-d.done:
+		d.dropped.Add(1)
+		return
+	default:
+	}
+
+	queue := d.queues[d.shard(msg.Topic)]
+	switch d.overflow {
+	case OverflowPolicyDrop:
+		select {
+		case queue <- msg:
+		default:
+			d.dropped.Add(1)
+		}
+	case OverflowPolicyDropOldest:
+		for {
+			select {
+			case queue <- msg:
+				return
+			default:
+			}
+			select {
+			case <-queue:
+				d.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case queue <- msg:
+		case <-d.done:
+			d.dropped.Add(1)
+		}
+	}
+}
+
+// shard returns the worker index for a topic
+func (d *inputDispatcher) shard(topic string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(topic))
+	return int(h.Sum32() % uint32(len(d.queues)))
+}
+
+// stats returns the current dispatcher counters
+func (d *inputDispatcher) stats() InputDispatcherStats {
+	stats := InputDispatcherStats{
+		Workers:   len(d.queues),
+		Processed: d.processed.Load(),
+		Dropped:   d.dropped.Load(),
+	}
+	for _, queue := range d.queues {
+		stats.Depth += len(queue)
+		stats.Capacity += cap(queue)
+	}
+	return stats
+}
+
+// GetInputDispatcherStats returns queue depth and drop counters of the MQTT input dispatcher.
+// The second value is false when input is handled inline without a dispatcher.
+func (c *PepeunitClient) GetInputDispatcherStats() (InputDispatcherStats, bool) {
+	if c.dispatcher == nil {
+		return InputDispatcherStats{}, false
+	}
+	return c.dispatcher.stats(), true
+}
@@ -0,0 +1,170 @@
+package pepeunit_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+// payloadRecorder collects input/pepeunit payloads handled by dispatcher workers
+type payloadRecorder struct {
+	payloads []string
+	mutex    sync.Mutex
+}
+
+func (r *payloadRecorder) record(msg pepeunit.MQTTMessage) {
+	r.mutex.Lock()
+	r.payloads = append(r.payloads, string(msg.Payload))
+	r.mutex.Unlock()
+}
+
+func (r *payloadRecorder) joined() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return strings.Join(r.payloads, ",")
+}
+
+func newDispatcherClient(t *testing.T, workers, queueSize int, overflow pepeunit.OverflowPolicy) (*pepeunittest.Fixture, *pepeunit.PepeunitClient) {
+	t.Helper()
+	fixture := pepeunittest.NewTestFixture(t)
+	config := fixture.Config()
+	config.InputWorkers = workers
+	config.InputQueueSize = queueSize
+	config.InputOverflowPolicy = overflow
+	client, err := fixture.NewClientWith(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fixture, client
+}
+
+func TestInputDispatcherKeepsTopicOrder(t *testing.T) {
+	fixture, client := newDispatcherClient(t, 4, 0, "")
+	recorder := &payloadRecorder{}
+	client.HandleInput("input/pepeunit", recorder.record)
+
+	var want []string
+	for i := 0; i < 50; i++ {
+		fixture.MQTT.Inject(fixture.InputTopic("input/pepeunit"), strconv.Itoa(i))
+		want = append(want, strconv.Itoa(i))
+	}
+	waitFor(t, "processed messages", func() bool {
+		stats, _ := client.GetInputDispatcherStats()
+		return stats.Processed == 50
+	})
+	if got := recorder.joined(); got != strings.Join(want, ",") {
+		t.Errorf("handled out of order: %s", got)
+	}
+}
+
+func TestInputDispatcherOverflow(t *testing.T) {
+	tests := []struct {
+		overflow pepeunit.OverflowPolicy
+		want     string
+	}{
+		{overflow: pepeunit.OverflowPolicyDrop, want: "0,1,2"},
+		{overflow: pepeunit.OverflowPolicyDropOldest, want: "0,3,4"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.overflow), func(t *testing.T) {
+			fixture, client := newDispatcherClient(t, 1, 2, tt.overflow)
+			recorder := &payloadRecorder{}
+			release := make(chan struct{})
+			started := make(chan struct{}, 1)
+			client.HandleInput("input/pepeunit", func(msg pepeunit.MQTTMessage) {
+				recorder.record(msg)
+				select {
+				case started <- struct{}{}:
+					<-release
+				default:
+				}
+			})
+
+			// The worker blocks on the first message while the rest overflow the queue of two
+			fixture.MQTT.Inject(fixture.InputTopic("input/pepeunit"), "0")
+			<-started
+			for i := 1; i <= 4; i++ {
+				fixture.MQTT.Inject(fixture.InputTopic("input/pepeunit"), strconv.Itoa(i))
+			}
+			close(release)
+
+			waitFor(t, "processed messages", func() bool {
+				stats, _ := client.GetInputDispatcherStats()
+				return stats.Processed == 3
+			})
+			if got := recorder.joined(); got != tt.want {
+				t.Errorf("handled %s, want %s", got, tt.want)
+			}
+			if stats, _ := client.GetInputDispatcherStats(); stats.Dropped != 2 {
+				t.Errorf("dropped = %d, want 2", stats.Dropped)
+			}
+		})
+	}
+}
+
+func TestInputDispatcherCloseCountsQueued(t *testing.T) {
+	fixture, client := newDispatcherClient(t, 1, 4, pepeunit.OverflowPolicyBlock)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var once sync.Once
+	client.HandleInput("input/pepeunit", func(msg pepeunit.MQTTMessage) {
+		once.Do(func() { close(started) })
+		<-release
+	})
+
+	fixture.MQTT.Inject(fixture.InputTopic("input/pepeunit"), "0")
+	<-started
+	for i := 1; i <= 3; i++ {
+		fixture.MQTT.Inject(fixture.InputTopic("input/pepeunit"), strconv.Itoa(i))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_ = client.Close(ctx)
+	close(release)
+
+	stats, _ := client.GetInputDispatcherStats()
+	if stats.Dropped != 3 || stats.Depth != 0 {
+		t.Errorf("stats after Close = %+v, want 3 dropped and an empty queue", stats)
+	}
+}
+
+func TestInputDispatcherRejectsUnknownOverflow(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	config := fixture.Config()
+	config.InputWorkers = 1
+	config.InputOverflowPolicy = "drop_newest"
+	if _, err := fixture.NewClientWith(config); err == nil || !strings.Contains(err.Error(), "drop_newest") {
+		t.Errorf("NewPepeunitClient() error = %v, want unsupported policy", err)
+	}
+}
+
+func TestUpdateRunsOffInputCallback(t *testing.T) {
+	for _, workers := range []int{0, 1} {
+		t.Run("workers="+strconv.Itoa(workers), func(t *testing.T) {
+			fixture, client := newDispatcherClient(t, workers, 0, "")
+			release := make(chan struct{})
+			defer close(release)
+			updating := make(chan struct{})
+			client.SetCustomUpdateHandler(func(client *pepeunit.PepeunitClient, payload string) error {
+				close(updating)
+				<-release
+				return nil
+			})
+			recorder := &payloadRecorder{}
+			client.HandleInput("input/pepeunit", recorder.record)
+
+			fixture.MQTT.Inject(pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeUpdatePepeunit)), "{}")
+			<-updating
+			// Input keeps flowing on the same worker while the update is blocked
+			fixture.MQTT.Inject(fixture.InputTopic("input/pepeunit"), "during update")
+			waitFor(t, "input during the update", func() bool { return recorder.joined() == "during update" })
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package pepeunit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DownloadProgress describes the state of a running file download
+type DownloadProgress struct {
+	URL        string
+	FilePath   string
+	Downloaded int64
+	// Total is the full size of the file, -1 when the server does not report it
+	Total int64
+}
+
+// maxDownloadRetryBackoff caps the exponential backoff between download attempts
+const maxDownloadRetryBackoff = time.Minute
+
+// permanentDownloadError marks a download failure that retrying cannot fix
+type permanentDownloadError struct {
+	err error
+}
+
+func (e *permanentDownloadError) Error() string {
+	return e.err.Error()
+}
+
+// SetDownloadRetries sets how many times an interrupted download is resumed and the initial backoff between attempts
+// A negative retries value disables retries.
+func (c *PepeunitRESTClient) SetDownloadRetries(retries int, backoff time.Duration) {
+	if retries < 0 {
+		retries = 0
+	}
+	if backoff <= 0 {
+		backoff = DefaultDownloadRetryBackoff
+	}
+	c.downloadRetries = retries
+	c.downloadBackoff = backoff
+}
+
+// SetDownloadIdleTimeout sets how long a download may go without receiving data before the attempt is retried
+func (c *PepeunitRESTClient) SetDownloadIdleTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultDownloadIdleTimeout
+	}
+	c.downloadIdleTimeout = timeout
+}
+
+// SetDownloadProgressHandler sets the callback invoked while downloads are written to disk
+func (c *PepeunitRESTClient) SetDownloadProgressHandler(handler func(progress DownloadProgress)) {
+	c.downloadProgress = handler
+}
+
+// partialDownload is stored next to a temp file, so a download interrupted by a restart resumes
+// only from a file of the same URL and validator
+type partialDownload struct {
+	URL       string `json:"url"`
+	Validator string `json:"validator"`
+}
+
+// partialStatePath returns the path of the partialDownload of a temp file
+func partialStatePath(tempPath string) string {
+	return tempPath + ".json"
+}
+
+// discardPartial removes a temp file and its partialDownload
+func discardPartial(tempPath string) {
+	_ = os.Remove(tempPath)
+	_ = os.Remove(partialStatePath(tempPath))
+}
+
+// loadPartialDownload returns the validator of a temp file left by an earlier download of url.
+// A temp file of another URL or without a validator can not be checked by If-Range and is removed.
+func loadPartialDownload(url, tempPath string) string {
+	if _, err := os.Stat(tempPath); err != nil {
+		_ = os.Remove(partialStatePath(tempPath))
+		return ""
+	}
+	var state partialDownload
+	data, err := os.ReadFile(partialStatePath(tempPath))
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil || state.URL != url || state.Validator == "" {
+		discardPartial(tempPath)
+		return ""
+	}
+	return state.Validator
+}
+
+// downloadResumable downloads url into a temp file next to filePath, resuming with Range requests
+// after interruptions, and renames it into place only once the file is complete.
+// The temp file outlives failed attempts and restarts, the next call for the same URL continues it.
+func (c *PepeunitRESTClient) downloadResumable(ctx context.Context, url, filePath string, headers map[string]string) error {
+	tempPath := filePath + ".part"
+	validator := loadPartialDownload(url, tempPath)
+
+	var lastErr error
+	backoff := c.downloadBackoff
+	for attempt := 0; attempt <= c.downloadRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > maxDownloadRetryBackoff {
+				backoff = maxDownloadRetryBackoff
+			}
+		}
+
+		lastErr = c.downloadAttempt(ctx, url, filePath, headers, &validator)
+		if lastErr == nil {
+			if err := os.Rename(tempPath, filePath); err != nil {
+				discardPartial(tempPath)
+				return fmt.Errorf("failed to move file %s: %v", filePath, err)
+			}
+			_ = os.Remove(partialStatePath(tempPath))
+			return nil
+		}
+
+		var permanent *permanentDownloadError
+		if errors.As(lastErr, &permanent) {
+			discardPartial(tempPath)
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return lastErr
+}
+
+// downloadAttempt continues the download from the current size of the temp file.
+// validator keeps the ETag or Last-Modified of the full response so a changed file is never resumed.
+func (c *PepeunitRESTClient) downloadAttempt(ctx context.Context, url, filePath string, headers map[string]string, validator *string) error {
+	tempPath := filePath + ".part"
+	var offset int64
+	if info, err := os.Stat(tempPath); err == nil {
+		offset = info.Size()
+	}
+
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(attemptCtx, "GET", url, nil)
+	if err != nil {
+		return &permanentDownloadError{fmt.Errorf("failed to create request: %v", err)}
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if *validator != "" {
+			req.Header.Set("If-Range", *validator)
+		}
+	}
+
+	resp, err := c.downloadClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	total := int64(-1)
+	flags := os.O_WRONLY | os.O_CREATE
+	switch resp.StatusCode {
+	case http.StatusOK:
+		offset = 0
+		total = resp.ContentLength
+		flags |= os.O_TRUNC
+	case http.StatusPartialContent:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			discardPartial(tempPath)
+			return fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), offset)
+		}
+		total = size
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		_, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if ok && size == offset {
+			return nil
+		}
+		discardPartial(tempPath)
+		return fmt.Errorf("download range %d- not satisfiable", offset)
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("download failed with status %d: %s", resp.StatusCode, string(body))
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return &permanentDownloadError{err}
+		}
+		return err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			*validator = etag
+		} else {
+			*validator = resp.Header.Get("Last-Modified")
+		}
+		if err := savePartialDownload(url, tempPath, *validator); err != nil {
+			return &permanentDownloadError{err}
+		}
+	}
+
+	file, err := os.OpenFile(tempPath, flags, 0644)
+	if err != nil {
+		return &permanentDownloadError{fmt.Errorf("failed to create file %s: %v", tempPath, err)}
+	}
+	defer file.Close()
+
+	writer := &progressWriter{
+		file:     file,
+		progress: c.downloadProgress,
+		state:    DownloadProgress{URL: url, FilePath: filePath, Downloaded: offset, Total: total},
+	}
+	// The attempt is cancelled once no data arrived for the idle timeout, a total timeout would cut off large files
+	var stalled atomic.Bool
+	timer := time.AfterFunc(c.downloadIdleTimeout, func() {
+		stalled.Store(true)
+		cancel()
+	})
+	defer timer.Stop()
+	written, err := io.Copy(writer, &idleTimeoutReader{reader: resp.Body, timer: timer, timeout: c.downloadIdleTimeout})
+	if err != nil {
+		if stalled.Load() {
+			err = fmt.Errorf("no data received for %v", c.downloadIdleTimeout)
+		}
+		return fmt.Errorf("download interrupted at %d bytes: %v", offset+written, err)
+	}
+	if total >= 0 && offset+written != total {
+		return fmt.Errorf("incomplete download: got %d of %d bytes", offset+written, total)
+	}
+	if err := file.Sync(); err != nil {
+		return &permanentDownloadError{fmt.Errorf("failed to write file %s: %v", tempPath, err)}
+	}
+	return nil
+}
+
+// savePartialDownload stores the validator of a temp file, a file without a validator is not resumed after a restart
+func savePartialDownload(url, tempPath, validator string) error {
+	if validator == "" {
+		_ = os.Remove(partialStatePath(tempPath))
+		return nil
+	}
+	if err := NewFileManager().WriteJSON(partialStatePath(tempPath), partialDownload{URL: url, Validator: validator}); err != nil {
+		return fmt.Errorf("failed to write download state: %v", err)
+	}
+	return nil
+}
+
+// idleTimeoutReader restarts the idle timer of a download whenever data arrives
+type idleTimeoutReader struct {
+	reader  io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// parseContentRange parses "bytes start-end/size" and "bytes */size" headers, size is -1 when unknown
+func parseContentRange(value string) (start, size int64, ok bool) {
+	value = strings.TrimPrefix(value, "bytes ")
+	rangePart, sizePart, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, false
+	}
+	size = -1
+	if sizePart != "*" {
+		parsed, err := strconv.ParseInt(sizePart, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		size = parsed
+	}
+	if rangePart == "*" {
+		return 0, size, true
+	}
+	startPart, _, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(startPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
+
+// progressWriter writes to a file and reports the number of bytes written so far
+type progressWriter struct {
+	file     *os.File
+	progress func(DownloadProgress)
+	state    DownloadProgress
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.state.Downloaded += int64(n)
+	if w.progress != nil && n > 0 {
+		w.progress(w.state)
+	}
+	return n, err
+}
@@ -0,0 +1,170 @@
+package pepeunit_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+// newDownloadServer returns a fake server with a 64 KiB file "fw.bin" and the file contents
+func newDownloadServer(t *testing.T) (*pepeunittest.FakeServer, []byte) {
+	t.Helper()
+	unitUUID := pepeunittest.NewUUID()
+	server := pepeunittest.NewFakeServer(pepeunittest.NewJWT(unitUUID), unitUUID)
+	t.Cleanup(server.Close)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	server.SetFile("fw.bin", data)
+	return server, data
+}
+
+func newDownloadClient(server *pepeunittest.FakeServer, retries int) *pepeunit.PepeunitRESTClient {
+	client := pepeunit.NewPepeunitRESTClient(pepeunit.NewSettingsWith("", server.SettingsValues()))
+	client.SetDownloadRetries(retries, time.Millisecond)
+	return client
+}
+
+// fileRequests returns the requests of fw.bin
+func fileRequests(server *pepeunittest.FakeServer) []pepeunittest.RecordedRequest {
+	var requests []pepeunittest.RecordedRequest
+	for _, request := range server.Requests() {
+		if strings.HasSuffix(request.Path, "/fw.bin") {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+func checkFile(t *testing.T, path string, want []byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(data, want) {
+		t.Fatalf("downloaded %d bytes, %v, want %d bytes", len(data), err, len(want))
+	}
+	for _, leftover := range []string{path + ".part", path + ".part.json"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left after the download: %v", filepath.Base(leftover), err)
+		}
+	}
+}
+
+func TestDownloadResumesWithRange(t *testing.T) {
+	server, data := newDownloadServer(t)
+	server.SetFileFault("fw.bin", pepeunittest.FileFault{After: 10000, Times: 2})
+	path := filepath.Join(t.TempDir(), "fw.bin")
+
+	var progress []pepeunit.DownloadProgress
+	client := newDownloadClient(server, 2)
+	client.SetDownloadProgressHandler(func(p pepeunit.DownloadProgress) { progress = append(progress, p) })
+	if err := client.DownloadFileFromURL(context.Background(), server.FileURL("fw.bin"), path); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path, data)
+
+	requests := fileRequests(server)
+	wantRanges := []string{"", "bytes=10000-", "bytes=20000-"}
+	if len(requests) != len(wantRanges) {
+		t.Fatalf("%d requests, want %d", len(requests), len(wantRanges))
+	}
+	for i, request := range requests {
+		if got := request.Header.Get("Range"); got != wantRanges[i] {
+			t.Errorf("request %d Range = %q, want %q", i, got, wantRanges[i])
+		}
+		if i > 0 && request.Header.Get("If-Range") == "" {
+			t.Errorf("request %d without If-Range", i)
+		}
+	}
+	if last := progress[len(progress)-1]; last.Downloaded != int64(len(data)) || last.Total != int64(len(data)) {
+		t.Errorf("last progress = %+v", last)
+	}
+}
+
+func TestDownloadResumesAfterRestart(t *testing.T) {
+	server, data := newDownloadServer(t)
+	server.SetFileFault("fw.bin", pepeunittest.FileFault{After: 30000, Times: 1})
+	path := filepath.Join(t.TempDir(), "fw.bin")
+
+	// The first process gives up after the dropped connection and leaves the part file
+	if err := newDownloadClient(server, 0).DownloadFileFromURL(context.Background(), server.FileURL("fw.bin"), path); err == nil {
+		t.Fatal("interrupted download succeeded")
+	}
+	if info, err := os.Stat(path + ".part"); err != nil || info.Size() != 30000 {
+		t.Fatalf("part file after the interruption: %v, %v", info, err)
+	}
+
+	if err := newDownloadClient(server, 0).DownloadFileFromURL(context.Background(), server.FileURL("fw.bin"), path); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path, data)
+	requests := fileRequests(server)
+	if got := requests[len(requests)-1].Header.Get("Range"); got != "bytes=30000-" {
+		t.Errorf("resumed request Range = %q", got)
+	}
+}
+
+func TestDownloadRestartsChangedFile(t *testing.T) {
+	server, _ := newDownloadServer(t)
+	server.SetFileFault("fw.bin", pepeunittest.FileFault{After: 30000, Times: 1})
+	path := filepath.Join(t.TempDir(), "fw.bin")
+	_ = newDownloadClient(server, 0).DownloadFileFromURL(context.Background(), server.FileURL("fw.bin"), path)
+
+	// If-Range with the old ETag makes the server send the new file in full
+	changed := bytes.Repeat([]byte("fedcba9876543210"), 4096)
+	server.SetFile("fw.bin", changed)
+	if err := newDownloadClient(server, 0).DownloadFileFromURL(context.Background(), server.FileURL("fw.bin"), path); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path, changed)
+}
+
+func TestDownloadIdleTimeout(t *testing.T) {
+	server, data := newDownloadServer(t)
+	server.SetFileFault("fw.bin", pepeunittest.FileFault{After: 1000, Stall: 10 * time.Second, Times: 1})
+	path := filepath.Join(t.TempDir(), "fw.bin")
+
+	client := newDownloadClient(server, 1)
+	client.SetDownloadIdleTimeout(50 * time.Millisecond)
+	start := time.Now()
+	if err := client.DownloadFileFromURL(context.Background(), server.FileURL("fw.bin"), path); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stalled download took %v", elapsed)
+	}
+	checkFile(t, path, data)
+}
+
+func TestDownloadIdleTimeoutError(t *testing.T) {
+	server, _ := newDownloadServer(t)
+	server.SetFileFault("fw.bin", pepeunittest.FileFault{After: 1000, Stall: 10 * time.Second, Times: 1})
+	client := newDownloadClient(server, 0)
+	client.SetDownloadIdleTimeout(50 * time.Millisecond)
+
+	err := client.DownloadFileFromURL(context.Background(), server.FileURL("fw.bin"), filepath.Join(t.TempDir(), "fw.bin"))
+	if err == nil || !strings.Contains(err.Error(), "no data received") {
+		t.Errorf("DownloadFileFromURL() = %v, want idle timeout", err)
+	}
+}
+
+func TestDownloadPermanentErrorRemovesPart(t *testing.T) {
+	server, _ := newDownloadServer(t)
+	server.SetFileFault("fw.bin", pepeunittest.FileFault{After: 30000, Times: 1})
+	path := filepath.Join(t.TempDir(), "fw.bin")
+	_ = newDownloadClient(server, 0).DownloadFileFromURL(context.Background(), server.FileURL("fw.bin"), path)
+
+	// The file is gone on the server, so the part file can never be completed
+	server.SetFile("fw.bin", nil)
+	err := newDownloadClient(server, 0).DownloadFileFromURL(context.Background(), server.FileURL("fw.bin"), path)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("DownloadFileFromURL() = %v, want 404", err)
+	}
+	if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Errorf("part file kept after a permanent error: %v", err)
+	}
+}
@@ -58,22 +58,95 @@ const (
 	BaseInputTopicTypeEnvUpdatePepeunit    BaseInputTopicType = "env_update/pepeunit"
 	BaseInputTopicTypeSchemaUpdatePepeunit BaseInputTopicType = "schema_update/pepeunit"
 	BaseInputTopicTypeLogSyncPepeunit      BaseInputTopicType = "log_sync/pepeunit"
+	BaseInputTopicTypeTransferPepeunit     BaseInputTopicType = "transfer/pepeunit"
 )
 
 // BaseOutputTopicType represents base output topic types
 type BaseOutputTopicType string
 
 const (
-	BaseOutputTopicTypeLogPepeunit   BaseOutputTopicType = "log/pepeunit"
-	BaseOutputTopicTypeStatePepeunit BaseOutputTopicType = "state/pepeunit"
+	BaseOutputTopicTypeLogPepeunit          BaseOutputTopicType = "log/pepeunit"
+	BaseOutputTopicTypeStatePepeunit        BaseOutputTopicType = "state/pepeunit"
+	BaseOutputTopicTypeTransferAckPepeunit  BaseOutputTopicType = "transfer_ack/pepeunit"
+	BaseOutputTopicTypeAvailabilityPepeunit BaseOutputTopicType = "availability/pepeunit"
 )
 
 // RestartMode represents the restart mode for device updates
 type RestartMode string
 
 const (
-	RestartModeRestartPopen  RestartMode = "restart_popen"
-	RestartModeRestartExec   RestartMode = "restart_exec"
-	RestartModeEnvSchemaOnly RestartMode = "env_schema_only"
-	RestartModeNoRestart     RestartMode = "no_restart"
+	RestartModeRestartPopen    RestartMode = "restart_popen"
+	RestartModeRestartExec     RestartMode = "restart_exec"
+	RestartModeRestartExitCode RestartMode = "restart_exit_code"
+	RestartModeEnvSchemaOnly   RestartMode = "env_schema_only"
+	RestartModeNoRestart       RestartMode = "no_restart"
+)
+
+// UpdateApplyMode represents how an extracted update archive is applied to the unit directory
+type UpdateApplyMode string
+
+const (
+	UpdateApplyModeOverlay UpdateApplyMode = "overlay"
+	UpdateApplyModeSync    UpdateApplyMode = "sync"
+)
+
+// UpdateType represents the kind of update applied to the unit
+type UpdateType string
+
+const (
+	UpdateTypeBinary  UpdateType = "binary"
+	UpdateTypeArchive UpdateType = "archive"
+)
+
+// OverrunPolicy represents what happens when a task is due while its previous run is in progress
+type OverrunPolicy string
+
+const (
+	OverrunPolicySkip  OverrunPolicy = "skip"
+	OverrunPolicyQueue OverrunPolicy = "queue"
+)
+
+// OverflowPolicy represents what happens when the MQTT input queue is full
+type OverflowPolicy string
+
+const (
+	OverflowPolicyBlock      OverflowPolicy = "block"
+	OverflowPolicyDrop       OverflowPolicy = "drop"
+	OverflowPolicyDropOldest OverflowPolicy = "drop_oldest"
+)
+
+// UpdatePhase represents the current step of an update reported in the unit state
+type UpdatePhase string
+
+const (
+	UpdatePhaseIdle        UpdatePhase = "idle"
+	UpdatePhaseDeferred    UpdatePhase = "deferred"
+	UpdatePhaseStarted     UpdatePhase = "started"
+	UpdatePhaseDownloading UpdatePhase = "downloading"
+	UpdatePhaseVerifying   UpdatePhase = "verifying"
+	UpdatePhaseExtracting  UpdatePhase = "extracting"
+	UpdatePhaseApplying    UpdatePhase = "applying"
+	UpdatePhaseRestarting  UpdatePhase = "restarting"
+	UpdatePhaseDone        UpdatePhase = "done"
+	UpdatePhaseFailed      UpdatePhase = "failed"
+)
+
+// TransferMessageType represents the type of a message of the MQTT file transfer protocol
+type TransferMessageType string
+
+const (
+	TransferMessageTypeManifest TransferMessageType = "manifest"
+	TransferMessageTypeChunk    TransferMessageType = "chunk"
+	TransferMessageTypeRequest  TransferMessageType = "request"
+	TransferMessageTypeAck      TransferMessageType = "ack"
+	TransferMessageTypeComplete TransferMessageType = "complete"
+	TransferMessageTypeError    TransferMessageType = "error"
+)
+
+// MQTTTransport represents the network transport of the broker connection
+type MQTTTransport string
+
+const (
+	MQTTTransportTCP       MQTTTransport = "tcp"
+	MQTTTransportWebsocket MQTTTransport = "websocket"
 )
@@ -0,0 +1,215 @@
+package pepeunit
+
+import (
+	"fmt"
+	"sync"
+)
+
+// UpdateEvent describes an update passing through the update flow
+type UpdateEvent struct {
+	Type         UpdateType
+	Source       string
+	Version      string
+	Verification UpdateVerification
+	Patch        UpdatePatch
+}
+
+// eventHub stores lifecycle event subscribers of a PepeunitClient
+type eventHub struct {
+	logger           *Logger
+	connected        []func()
+	connectionLost   []func(error)
+	envReloaded      []func(oldSettings, newSettings Settings)
+	schemaReloaded   []func()
+	updateStarted    []func(UpdateEvent) error
+	updateDownloaded []func(UpdateEvent) error
+	updateApplied    []func(UpdateEvent)
+	updateFailed     []func(UpdateEvent, error)
+	beforeRestart    []func(RestartMode)
+	downloadProgress []func(DownloadProgress)
+	mutex            sync.RWMutex
+}
+
+// newEventHub creates an empty event hub
+func newEventHub(logger *Logger) *eventHub {
+	return &eventHub{logger: logger}
+}
+
+// safeCall runs a subscriber and logs a panic instead of propagating it
+func (h *eventHub) safeCall(event string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.logger.Error(fmt.Sprintf("Error in %s event handler: %v", event, r))
+		}
+	}()
+	fn()
+}
+
+func (h *eventHub) emitConnected() {
+	h.mutex.RLock()
+	handlers := append([]func(){}, h.connected...)
+	h.mutex.RUnlock()
+	for _, handler := range handlers {
+		h.safeCall("connected", handler)
+	}
+}
+
+func (h *eventHub) emitConnectionLost(err error) {
+	h.mutex.RLock()
+	handlers := append([]func(error){}, h.connectionLost...)
+	h.mutex.RUnlock()
+	for _, handler := range handlers {
+		h.safeCall("connection lost", func() { handler(err) })
+	}
+}
+
+func (h *eventHub) emitEnvReloaded(oldSettings, newSettings Settings) {
+	h.mutex.RLock()
+	handlers := append([]func(Settings, Settings){}, h.envReloaded...)
+	h.mutex.RUnlock()
+	for _, handler := range handlers {
+		h.safeCall("env reloaded", func() { handler(oldSettings, newSettings) })
+	}
+}
+
+func (h *eventHub) emitSchemaReloaded() {
+	h.mutex.RLock()
+	handlers := append([]func(){}, h.schemaReloaded...)
+	h.mutex.RUnlock()
+	for _, handler := range handlers {
+		h.safeCall("schema reloaded", handler)
+	}
+}
+
+// emitUpdateStarted returns the first error of a subscriber refusing the update
+func (h *eventHub) emitUpdateStarted(event UpdateEvent) error {
+	h.mutex.RLock()
+	handlers := append([]func(UpdateEvent) error{}, h.updateStarted...)
+	h.mutex.RUnlock()
+	return h.emitRefusable("update started", handlers, event)
+}
+
+// emitUpdateDownloaded returns the first error of a subscriber refusing the update
+func (h *eventHub) emitUpdateDownloaded(event UpdateEvent) error {
+	h.mutex.RLock()
+	handlers := append([]func(UpdateEvent) error{}, h.updateDownloaded...)
+	h.mutex.RUnlock()
+	return h.emitRefusable("update downloaded", handlers, event)
+}
+
+func (h *eventHub) emitRefusable(name string, handlers []func(UpdateEvent) error, event UpdateEvent) error {
+	for _, handler := range handlers {
+		var err error
+		h.safeCall(name, func() { err = handler(event) })
+		if err != nil {
+			return fmt.Errorf("update refused: %v", err)
+		}
+	}
+	return nil
+}
+
+func (h *eventHub) emitUpdateApplied(event UpdateEvent) {
+	h.mutex.RLock()
+	handlers := append([]func(UpdateEvent){}, h.updateApplied...)
+	h.mutex.RUnlock()
+	for _, handler := range handlers {
+		h.safeCall("update applied", func() { handler(event) })
+	}
+}
+
+func (h *eventHub) emitUpdateFailed(event UpdateEvent, err error) {
+	h.mutex.RLock()
+	handlers := append([]func(UpdateEvent, error){}, h.updateFailed...)
+	h.mutex.RUnlock()
+	for _, handler := range handlers {
+		h.safeCall("update failed", func() { handler(event, err) })
+	}
+}
+
+func (h *eventHub) emitBeforeRestart(mode RestartMode) {
+	h.mutex.RLock()
+	handlers := append([]func(RestartMode){}, h.beforeRestart...)
+	h.mutex.RUnlock()
+	for _, handler := range handlers {
+		h.safeCall("before restart", func() { handler(mode) })
+	}
+}
+
+func (h *eventHub) emitDownloadProgress(progress DownloadProgress) {
+	h.mutex.RLock()
+	handlers := append([]func(DownloadProgress){}, h.downloadProgress...)
+	h.mutex.RUnlock()
+	for _, handler := range handlers {
+		h.safeCall("download progress", func() { handler(progress) })
+	}
+}
+
+// OnConnected subscribes to successful MQTT broker connections, including reconnects
+func (c *PepeunitClient) OnConnected(handler func()) {
+	c.events.mutex.Lock()
+	c.events.connected = append(c.events.connected, handler)
+	c.events.mutex.Unlock()
+}
+
+// OnConnectionLost subscribes to MQTT broker connection losses
+func (c *PepeunitClient) OnConnectionLost(handler func(err error)) {
+	c.events.mutex.Lock()
+	c.events.connectionLost = append(c.events.connectionLost, handler)
+	c.events.mutex.Unlock()
+}
+
+// OnEnvReloaded subscribes to env reloads with snapshots of the settings before and after the reload
+func (c *PepeunitClient) OnEnvReloaded(handler func(oldSettings, newSettings Settings)) {
+	c.events.mutex.Lock()
+	c.events.envReloaded = append(c.events.envReloaded, handler)
+	c.events.mutex.Unlock()
+}
+
+// OnSchemaReloaded subscribes to schema reloads
+func (c *PepeunitClient) OnSchemaReloaded(handler func()) {
+	c.events.mutex.Lock()
+	c.events.schemaReloaded = append(c.events.schemaReloaded, handler)
+	c.events.mutex.Unlock()
+}
+
+// OnUpdateStarted subscribes to the start of an update, returning an error refuses the update
+func (c *PepeunitClient) OnUpdateStarted(handler func(event UpdateEvent) error) {
+	c.events.mutex.Lock()
+	c.events.updateStarted = append(c.events.updateStarted, handler)
+	c.events.mutex.Unlock()
+}
+
+// OnUpdateDownloaded subscribes to completed update downloads, returning an error refuses the update
+func (c *PepeunitClient) OnUpdateDownloaded(handler func(event UpdateEvent) error) {
+	c.events.mutex.Lock()
+	c.events.updateDownloaded = append(c.events.updateDownloaded, handler)
+	c.events.mutex.Unlock()
+}
+
+// OnUpdateApplied subscribes to updates applied to the unit
+func (c *PepeunitClient) OnUpdateApplied(handler func(event UpdateEvent)) {
+	c.events.mutex.Lock()
+	c.events.updateApplied = append(c.events.updateApplied, handler)
+	c.events.mutex.Unlock()
+}
+
+// OnUpdateFailed subscribes to failed or refused updates
+func (c *PepeunitClient) OnUpdateFailed(handler func(event UpdateEvent, err error)) {
+	c.events.mutex.Lock()
+	c.events.updateFailed = append(c.events.updateFailed, handler)
+	c.events.mutex.Unlock()
+}
+
+// OnBeforeRestart subscribes to the moment right before the process is restarted after an update
+func (c *PepeunitClient) OnBeforeRestart(handler func(mode RestartMode)) {
+	c.events.mutex.Lock()
+	c.events.beforeRestart = append(c.events.beforeRestart, handler)
+	c.events.mutex.Unlock()
+}
+
+// OnDownloadProgress subscribes to the progress of firmware and file downloads
+func (c *PepeunitClient) OnDownloadProgress(handler func(progress DownloadProgress)) {
+	c.events.mutex.Lock()
+	c.events.downloadProgress = append(c.events.downloadProgress, handler)
+	c.events.mutex.Unlock()
+}
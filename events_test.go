@@ -0,0 +1,92 @@
+package pepeunit_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+func TestConnectionEvents(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	client.OnConnected(func() { got = append(got, "connected") })
+	client.OnConnectionLost(func(err error) { got = append(got, "lost: "+err.Error()) })
+	client.OnConnected(func() { panic("subscriber panic") })
+
+	fixture.MQTT.DropConnection(errors.New("broker gone"))
+	if err := fixture.MQTT.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := "lost: broker gone,connected"; strings.Join(got, ",") != want {
+		t.Errorf("events = %v, want %s", got, want)
+	}
+}
+
+func TestReloadEvents(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var oldInterval, newInterval int
+	client.OnEnvReloaded(func(oldSettings, newSettings pepeunit.Settings) {
+		oldInterval, newInterval = oldSettings.PU_STATE_SEND_INTERVAL, newSettings.PU_STATE_SEND_INTERVAL
+	})
+	schemaReloads := 0
+	client.OnSchemaReloaded(func() { schemaReloads++ })
+
+	env := pepeunittest.NewEnv(fixture.UnitUUID, fixture.Server.SettingsValues())
+	env["PU_STATE_SEND_INTERVAL"] = 42
+	fixture.Server.SetEnv(env)
+	fixture.MQTT.Inject(pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeEnvUpdatePepeunit)), "")
+	if oldInterval != 300 || newInterval != 42 {
+		t.Errorf("env reloaded %d -> %d, want 300 -> 42", oldInterval, newInterval)
+	}
+
+	fixture.MQTT.Inject(pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeSchemaUpdatePepeunit)), "")
+	if schemaReloads != 1 {
+		t.Errorf("schema reloaded %d times, want 1", schemaReloads)
+	}
+}
+
+func TestUpdateStartedRefusesUpdate(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan pepeunit.UpdateEvent, 1)
+	client.OnUpdateStarted(func(event pepeunit.UpdateEvent) error {
+		started <- event
+		return errors.New("battery low")
+	})
+	failed := make(chan error, 1)
+	client.OnUpdateFailed(func(event pepeunit.UpdateEvent, err error) { failed <- err })
+	client.OnUpdateApplied(func(event pepeunit.UpdateEvent) { t.Error("refused update applied") })
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"PU_COMMIT_VERSION":      "1111111111111111111111111111111111111111",
+		"COMPILED_FIRMWARE_LINK": fixture.Server.FileURL("fw.tar.gz"),
+	})
+	requests := len(fixture.Server.Requests())
+	fixture.MQTT.Inject(pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeUpdatePepeunit)), string(payload))
+
+	if event := <-started; event.Type != pepeunit.UpdateTypeBinary || event.Source != fixture.Server.FileURL("fw.tar.gz") {
+		t.Fatalf("update started event = %+v", event)
+	}
+	if err := <-failed; err == nil || !strings.Contains(err.Error(), "battery low") {
+		t.Errorf("update failed error = %v", err)
+	}
+	if got := fixture.Server.Requests()[requests:]; len(got) != 0 {
+		t.Errorf("refused update made requests %+v", got)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
 	"time"
 
@@ -30,8 +29,7 @@ import (
 // - Units Nodes api
 // - Cipher api
 
-// Global variable to track last message send time
-var lastOutputSendTime time.Time
+// Global counter published to output/pepeunit
 var inc int
 
 func testSetGetStorage(client *pepeunit.PepeunitClient) {
@@ -137,56 +135,28 @@ func testCipher(client *pepeunit.PepeunitClient) {
 }
 
 func handleInputMessages(client *pepeunit.PepeunitClient, msg pepeunit.MQTTMessage) {
-	topicParts := strings.Split(msg.Topic, "/")
-
-	// topic with format domain.com/+/pepeunit
-	if len(topicParts) == 3 {
-		// find topic name in schema, by topic with struct domain.com/+/pepeunit or domain.com/+
-		topicName, err := client.GetSchema().FindTopicByUnitNode(
-			msg.Topic, pepeunit.SearchTopicTypeFullName, pepeunit.SearchScopeInput,
-		)
-
-		if err != nil {
-			client.GetLogger().Error(fmt.Sprintf("Error finding topic: %v", err))
-			return
-		}
-
-		if topicName == "input/pepeunit" {
-			value := string(msg.Payload)
-			intValue, err := strconv.Atoi(value)
-			if err != nil {
-				client.GetLogger().Error(fmt.Sprintf("Value is not a number: %s", value))
-				return
-			}
-
-			client.GetLogger().Debug(fmt.Sprintf("Get from input/pepeunit: %d", intValue), true)
-		}
+	value := string(msg.Payload)
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		client.GetLogger().Error(fmt.Sprintf("Value is not a number: %s", value))
+		return
 	}
+
+	client.GetLogger().Debug(fmt.Sprintf("Get from input/pepeunit: %d", intValue), true)
 }
 
-func handleOutputMessages(client *pepeunit.PepeunitClient) {
-	currentTime := time.Now()
+func handleOutputMessages(ctx context.Context, client *pepeunit.PepeunitClient) error {
+	message := inc
+	client.GetLogger().Debug(fmt.Sprintf("Send to output/pepeunit: %d", message), true)
 
-	// Send data every DELAY_PUB_MSG from extras (fallback to PU_STATE_SEND_INTERVAL)
-	delay, ok := client.GetSettings().GetInt("DELAY_PUB_MSG")
-	if !ok || delay <= 0 {
-		delay = client.GetSettings().PU_STATE_SEND_INTERVAL
+	// Try to publish to sensor output topics
+	err := client.PublishToTopics(ctx, "output/pepeunit", strconv.Itoa(message))
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %v", err)
 	}
-	if currentTime.Sub(lastOutputSendTime) >= time.Duration(delay)*time.Second {
-		message := inc
-		client.GetLogger().Debug(fmt.Sprintf("Send to output/pepeunit: %d", message), true)
-
-		// Try to publish to sensor output topics
-		ctx := context.Background()
-		err := client.PublishToTopics(ctx, "output/pepeunit", strconv.Itoa(message))
-		if err != nil {
-			client.GetLogger().Error(fmt.Sprintf("Failed to publish message: %v", err))
-		}
 
-		// Update the last message send time
-		lastOutputSendTime = currentTime
-		inc++
-	}
+	inc++
+	return nil
 }
 
 func main() {
@@ -216,8 +186,8 @@ func main() {
 	// Test AES-GCM cipher
 	testCipher(client)
 
-	// Set up message handlers
-	client.SetMQTTInputHandler(func(msg pepeunit.MQTTMessage) {
+	// Set up message handlers by schema topic key
+	client.HandleInput("input/pepeunit", func(msg pepeunit.MQTTMessage) {
 		handleInputMessages(client, msg)
 	})
 
@@ -234,6 +204,20 @@ func main() {
 		log.Fatalf("Failed to subscribe to topics: %v", err)
 	}
 
+	// Send data every DELAY_PUB_MSG from extras (fallback to PU_STATE_SEND_INTERVAL)
+	delay, ok := client.GetSettings().GetInt("DELAY_PUB_MSG")
+	if !ok || delay <= 0 {
+		delay = client.GetSettings().PU_STATE_SEND_INTERVAL
+	}
+	err = client.AddTask("output/pepeunit", pepeunit.Every(time.Duration(delay)*time.Second), handleOutputMessages, pepeunit.TaskOptions{
+		Timeout:   10 * time.Second,
+		Overrun:   pepeunit.OverrunPolicySkip,
+		Immediate: true,
+	})
+	if err != nil {
+		log.Fatalf("Failed to add output task: %v", err)
+	}
+
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -241,9 +225,9 @@ func main() {
 	// Create a cancellable context for the main cycle
 	cycleCtx, cycleCancel := context.WithCancel(context.Background())
 
-	// Run the main cycle with set output handler in a goroutine
+	// Run the main cycle with scheduled tasks in a goroutine
 	go func() {
-		client.RunMainCycle(cycleCtx, handleOutputMessages)
+		client.RunMainCycle(cycleCtx, nil)
 	}()
 
 	// Wait for shutdown signal
@@ -253,13 +237,12 @@ func main() {
 	// Stop the main cycle by canceling the context
 	cycleCancel()
 
-	// Disconnect from MQTT
-	if client.GetMQTTClient() != nil {
-		err = client.GetMQTTClient().Disconnect(ctx)
-		if err != nil {
-			client.GetLogger().Error(fmt.Sprintf("Failed to disconnect from MQTT: %v", err))
-		}
+	// Wait for running handlers, flush logs and disconnect from MQTT
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer closeCancel()
+	if err := client.Close(closeCtx); err != nil {
+		log.Printf("Failed to close client: %v", err)
 	}
 
-	client.GetLogger().Info("Shutdown complete")
+	log.Println("Shutdown complete")
 }
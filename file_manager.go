@@ -4,7 +4,6 @@ import (
 	"archive/tar"
 	"bufio"
 	"compress/gzip"
-	"compress/zlib"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -67,7 +66,7 @@ func (fm *FileManager) WriteJSON(filePath string, data interface{}) error {
 	return writeFileAtomic(filePath, jsonData, perm)
 }
 
-// CopyFile copies a file from source to destination
+// CopyFile copies a file from source to destination, preserving its mode and modification time
 func (fm *FileManager) CopyFile(srcPath, destPath string) error {
 	srcFile, err := os.Open(srcPath)
 	if err != nil {
@@ -75,14 +74,24 @@ func (fm *FileManager) CopyFile(srcPath, destPath string) error {
 	}
 	defer srcFile.Close()
 
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
 	destFile, err := os.Create(destPath)
 	if err != nil {
 		return err
 	}
 	defer destFile.Close()
 
-	_, err = io.Copy(destFile, srcFile)
-	return err
+	if _, err = io.Copy(destFile, srcFile); err != nil {
+		return err
+	}
+	if err := destFile.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(destPath, info.ModTime(), info.ModTime())
 }
 
 // CopyDirectoryContents copies all contents from source directory to destination directory
@@ -100,92 +109,27 @@ func (fm *FileManager) CopyDirectoryContents(srcDir, destDir string) error {
 		destPath := filepath.Join(destDir, relPath)
 
 		if info.IsDir() {
-			return os.MkdirAll(destPath, info.Mode())
-		}
-
-		return fm.CopyFile(path, destPath)
-	})
-}
-
-// ExtractTarGz extracts a tar.gz archive to a destination directory
-func (fm *FileManager) ExtractTarGz(archivePath, destDir string) error {
-	file, err := os.Open(archivePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	bufReader := bufio.NewReader(file)
-
-	// Detect header: gzip (1f 8b) vs zlib (RFC1950)
-	header, err := bufReader.Peek(2)
-	if err != nil {
-		return err
-	}
-
-	var decompressed io.ReadCloser
-	switch {
-	case len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b:
-		gz, err := gzip.NewReader(bufReader)
-		if err != nil {
-			return err
-		}
-		decompressed = gz
-	default:
-		zr, err := zlib.NewReader(bufReader)
-		if err != nil {
-			return err
-		}
-		decompressed = zr
-	}
-	defer decompressed.Close()
-
-	tarReader := tar.NewReader(decompressed)
-
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-
-		// Skip if the path contains ".." for security
-		if strings.Contains(header.Name, "..") {
-			continue
+			return os.MkdirAll(destPath, info.Mode().Perm())
 		}
 
-		destPath := filepath.Join(destDir, header.Name)
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			err = os.MkdirAll(destPath, os.FileMode(header.Mode))
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
 			if err != nil {
 				return err
 			}
-		case tar.TypeReg:
-			err = os.MkdirAll(filepath.Dir(destPath), 0755)
-			if err != nil {
+			if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
 				return err
 			}
+			return os.Symlink(target, destPath)
+		}
 
-			outFile, err := os.Create(destPath)
-			if err != nil {
+		if destInfo, err := os.Lstat(destPath); err == nil && destInfo.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(destPath); err != nil {
 				return err
 			}
-
-			_, err = io.Copy(outFile, tarReader)
-			outFile.Close()
-			if err != nil {
-				return err
-			}
-		default:
-			return fmt.Errorf("unsupported file type: %v", header.Typeflag)
 		}
-	}
-
-	return nil
+		return fm.CopyFile(path, destPath)
+	})
 }
 
 // AppendToJSONList appends an item to a JSON array file
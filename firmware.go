@@ -0,0 +1,138 @@
+package pepeunit
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// CurrentPlatform returns the platform of the running binary as "GOOS/GOARCH" with the
+// GOARM or GOAMD64 variant it was built for, e.g. "linux/arm/v7" or "linux/amd64/v1"
+func CurrentPlatform() string {
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	if variant := buildVariant(); variant != "" {
+		platform += "/" + variant
+	}
+	return platform
+}
+
+// buildVariant returns the architecture variant recorded in the build info
+func buildVariant() string {
+	key := ""
+	switch runtime.GOARCH {
+	case "arm":
+		key = "GOARM"
+	case "amd64":
+		key = "GOAMD64"
+	default:
+		return ""
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == key {
+			value, _, _ := strings.Cut(setting.Value, ",")
+			if key == "GOARM" && value != "" && !strings.HasPrefix(value, "v") {
+				value = "v" + value
+			}
+			return value
+		}
+	}
+	return ""
+}
+
+// firmwareCandidates returns the link map keys tried for platform, most specific first. The base
+// "GOOS/GOARCH" key is only tried for a platform with a variant when the links name no variant of it,
+// so a linux/arm/v6 unit never installs a linux/arm build meant for the variants the payload lists.
+func firmwareCandidates(platform string, links map[string]interface{}) []string {
+	parts := strings.Split(strings.ToLower(strings.Trim(platform, "/")), "/")
+	if len(parts) < 2 {
+		return []string{strings.Join(parts, "/")}
+	}
+	base := parts[0] + "/" + parts[1]
+	if len(parts) < 3 || parts[2] == "" {
+		return []string{base}
+	}
+	candidates := []string{base + "/" + parts[2], base + parts[2]}
+	for key := range links {
+		if isFirmwareVariant(base, key) {
+			return candidates
+		}
+	}
+	return append(candidates, base)
+}
+
+// isFirmwareVariant reports whether a link map key names a variant of base, as "linux/arm/v7" or "linux/armv7"
+func isFirmwareVariant(base, key string) bool {
+	if !strings.HasPrefix(key, base) {
+		return false
+	}
+	rest := key[len(base):]
+	return strings.HasPrefix(rest, "/") || (len(rest) > 1 && rest[0] == 'v' && strings.Trim(rest[1:], "0123456789") == "")
+}
+
+// firmwareArtifact is the firmware of an update payload selected for the running platform
+type firmwareArtifact struct {
+	link         string
+	verification UpdateVerification
+	patch        UpdatePatch
+}
+
+// selectFirmware returns the firmware of the update payload for the running platform.
+// COMPILED_FIRMWARE_LINK is either a single link or a map keyed by "GOOS/GOARCH[/variant]", whose values are
+// links or objects with link, sha256, signature, patch_link and patch_base_sha256 fields. A map entry without
+// its own sha256 and signature is checked against COMPILED_FIRMWARE_SHA256 and COMPILED_FIRMWARE_SIGNATURE,
+// an entry left without any sha256 is refused.
+func (c *PepeunitClient) selectFirmware(meta map[string]interface{}) (firmwareArtifact, error) {
+	switch value := meta["COMPILED_FIRMWARE_LINK"].(type) {
+	case string:
+		return firmwareArtifact{
+			link:         value,
+			verification: updateVerificationFromPayload(meta),
+			patch:        updatePatchFromPayload(meta),
+		}, nil
+	case map[string]interface{}:
+		links := make(map[string]interface{}, len(value))
+		for key, link := range value {
+			links[strings.ToLower(strings.Trim(key, "/"))] = link
+		}
+		for _, candidate := range firmwareCandidates(c.firmwarePlatform, links) {
+			link, ok := links[candidate]
+			if !ok {
+				continue
+			}
+			artifact := firmwareArtifact{}
+			switch entry := link.(type) {
+			case string:
+				artifact.link = entry
+			case map[string]interface{}:
+				artifact.link, _ = entry["link"].(string)
+				artifact.verification.SHA256, _ = entry["sha256"].(string)
+				artifact.verification.Signature, _ = entry["signature"].(string)
+				artifact.patch.Link, _ = entry["patch_link"].(string)
+				artifact.patch.BaseSHA256, _ = entry["patch_base_sha256"].(string)
+			default:
+				return firmwareArtifact{}, fmt.Errorf("invalid firmware entry for platform %s", candidate)
+			}
+			if artifact.verification.SHA256 == "" && artifact.verification.Signature == "" {
+				artifact.verification = updateVerificationFromPayload(meta)
+			}
+			if artifact.verification.SHA256 == "" {
+				return firmwareArtifact{}, fmt.Errorf("firmware for platform %s has no sha256", candidate)
+			}
+			return artifact, nil
+		}
+		available := make([]string, 0, len(value))
+		for key := range value {
+			available = append(available, key)
+		}
+		sort.Strings(available)
+		return firmwareArtifact{}, fmt.Errorf("no firmware for platform %s, available: %s", c.firmwarePlatform, strings.Join(available, ", "))
+	default:
+		return firmwareArtifact{}, fmt.Errorf("invalid COMPILED_FIRMWARE_LINK format")
+	}
+}
@@ -0,0 +1,70 @@
+package pepeunit
+
+import (
+	"strings"
+	"testing"
+)
+
+func selectFirmwareFor(platform string, meta map[string]interface{}) (firmwareArtifact, error) {
+	client := &PepeunitClient{firmwarePlatform: platform}
+	return client.selectFirmware(meta)
+}
+
+func TestSelectFirmwareVariantFallback(t *testing.T) {
+	entry := func(name string) map[string]interface{} {
+		return map[string]interface{}{"link": name, "sha256": "digest-" + name}
+	}
+	tests := []struct {
+		platform string
+		links    map[string]interface{}
+		want     string
+	}{
+		{platform: "linux/arm/v7", links: map[string]interface{}{"linux/arm/v7": entry("v7"), "linux/arm": entry("arm")}, want: "v7"},
+		{platform: "linux/arm/v7", links: map[string]interface{}{"linux/armv7": entry("armv7"), "linux/arm": entry("arm")}, want: "armv7"},
+		// Without any variant in the payload the base build is meant for every variant
+		{platform: "linux/arm/v7", links: map[string]interface{}{"linux/arm": entry("arm"), "linux/arm64": entry("arm64")}, want: "arm"},
+		{platform: "LINUX/AMD64", links: map[string]interface{}{"/linux/amd64/": entry("amd64")}, want: "amd64"},
+	}
+	for _, tt := range tests {
+		artifact, err := selectFirmwareFor(tt.platform, map[string]interface{}{"COMPILED_FIRMWARE_LINK": tt.links})
+		if err != nil || artifact.link != tt.want {
+			t.Errorf("%s picked %q, %v, want %q", tt.platform, artifact.link, err, tt.want)
+		}
+	}
+
+	// linux/arm is the build for the listed variants, not for v6
+	links := map[string]interface{}{"linux/arm/v7": entry("v7"), "linux/arm": entry("arm")}
+	if artifact, err := selectFirmwareFor("linux/arm/v6", map[string]interface{}{"COMPILED_FIRMWARE_LINK": links}); err == nil {
+		t.Errorf("linux/arm/v6 picked %q from %v", artifact.link, links)
+	} else if !strings.Contains(err.Error(), "linux/arm/v7") {
+		t.Errorf("error %v does not list the available platforms", err)
+	}
+}
+
+func TestSelectFirmwareVerification(t *testing.T) {
+	meta := map[string]interface{}{
+		"COMPILED_FIRMWARE_SHA256":    "top",
+		"COMPILED_FIRMWARE_SIGNATURE": "top-signature",
+		"COMPILED_FIRMWARE_LINK": map[string]interface{}{
+			"linux/amd64": "https://example.com/amd64",
+			"linux/arm64": map[string]interface{}{"link": "https://example.com/arm64", "sha256": "own"},
+			"linux/386":   map[string]interface{}{"link": "https://example.com/386"},
+		},
+	}
+	want := map[string]UpdateVerification{
+		"linux/amd64": {SHA256: "top", Signature: "top-signature"},
+		"linux/arm64": {SHA256: "own"},
+		"linux/386":   {SHA256: "top", Signature: "top-signature"},
+	}
+	for platform, verification := range want {
+		artifact, err := selectFirmwareFor(platform, meta)
+		if err != nil || artifact.verification != verification {
+			t.Errorf("%s verification = %+v, %v, want %+v", platform, artifact.verification, err, verification)
+		}
+	}
+
+	unchecked := map[string]interface{}{"COMPILED_FIRMWARE_LINK": map[string]interface{}{"linux/amd64": "https://example.com/amd64"}}
+	if _, err := selectFirmwareFor("linux/amd64", unchecked); err == nil || !strings.Contains(err.Error(), "no sha256") {
+		t.Errorf("entry without any sha256 = %v, want refused", err)
+	}
+}
@@ -4,6 +4,7 @@ go 1.21
 
 require (
 	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/klauspost/compress v1.17.11
 	github.com/shirou/gopsutil/v3 v3.23.12
 )
 
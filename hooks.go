@@ -0,0 +1,93 @@
+package pepeunit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PreUpdateHookPath is the hook run from an extracted update archive before its files are applied
+const PreUpdateHookPath = ".pepeunit/pre_update"
+
+// PostUpdateHookPath is the hook run from an extracted update archive after its files are applied
+const PostUpdateHookPath = ".pepeunit/post_update"
+
+// updateHookOutputLimit bounds the hook output reported to the log topic
+const updateHookOutputLimit = 4096
+
+// runUpdateHook runs the hook at hookPath below extractDir, if the archive contains one.
+// The hook runs in the unit directory with PU_UPDATE_DIR, PU_UNIT_DIR, PU_UPDATE_VERSION and
+// PU_CURRENT_VERSION set, a hook file without execute permission is run with /bin/sh.
+func (c *PepeunitClient) runUpdateHook(ctx context.Context, hookPath, extractDir, unitDir string, event UpdateEvent) error {
+	hook := filepath.Join(extractDir, filepath.FromSlash(hookPath))
+	info, err := os.Lstat(hook)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat hook %s: %v", hookPath, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("hook %s is not a regular file", hookPath)
+	}
+
+	absUnitDir, err := filepath.Abs(unitDir)
+	if err != nil {
+		return err
+	}
+	hookCtx, cancel := context.WithTimeout(ctx, c.updateHookTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if info.Mode().Perm()&0111 != 0 {
+		cmd = exec.CommandContext(hookCtx, hook)
+	} else {
+		cmd = exec.CommandContext(hookCtx, "/bin/sh", hook)
+	}
+	cmd.Dir = absUnitDir
+	cmd.Env = append(os.Environ(),
+		"PU_UPDATE_DIR="+extractDir,
+		"PU_UNIT_DIR="+absUnitDir,
+		"PU_UPDATE_VERSION="+event.Version,
+		"PU_CURRENT_VERSION="+c.settings.PU_COMMIT_VERSION,
+	)
+	// Children keeping the output open must not block the hook past its timeout
+	cmd.WaitDelay = time.Second
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	c.logger.Info(fmt.Sprintf("Running update hook %s", hookPath))
+	started := time.Now()
+	err = cmd.Run()
+	if text := hookOutput(output.Bytes()); text != "" {
+		if err != nil {
+			c.logger.Error(fmt.Sprintf("Update hook %s output: %s", hookPath, text))
+		} else {
+			c.logger.Info(fmt.Sprintf("Update hook %s output: %s", hookPath, text))
+		}
+	}
+	if hookCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("hook %s timed out after %v", hookPath, c.updateHookTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("hook %s failed: %v", hookPath, err)
+	}
+	c.logger.Info(fmt.Sprintf("Update hook %s finished in %v", hookPath, time.Since(started).Round(time.Millisecond)))
+	return nil
+}
+
+// hookOutput returns the trimmed hook output, keeping its tail when it exceeds the limit
+func hookOutput(output []byte) string {
+	text := strings.TrimSpace(string(output))
+	if len(text) > updateHookOutputLimit {
+		text = "..." + text[len(text)-updateHookOutputLimit:]
+	}
+	return text
+}
@@ -0,0 +1,108 @@
+package pepeunit_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+// applyHookArchive applies an archive with data/config.txt and the given hook entries to the fixture unit directory
+func applyHookArchive(t *testing.T, client *pepeunit.PepeunitClient, hooks ...pepeunittest.TarEntry) error {
+	t.Helper()
+	entries := append([]pepeunittest.TarEntry{{Name: "data/config.txt", Body: "v2"}}, hooks...)
+	data, err := pepeunittest.BuildTar(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(t.TempDir(), "update.tar.gz")
+	if data, err = pepeunittest.Gzip(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return client.UpdateDeviceProgram(context.Background(), archive)
+}
+
+func TestUpdateHooksRunAroundApply(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The executable pre hook sees the extracted tree but not yet the applied files, the plain post hook runs with /bin/sh
+	pre := pepeunittest.TarEntry{
+		Name: pepeunit.PreUpdateHookPath,
+		Mode: 0755,
+		Body: "#!/bin/sh\ntest -f \"$PU_UPDATE_DIR/data/config.txt\" && test ! -f data/config.txt && echo \"$PU_UNIT_DIR\" > pre.txt\n",
+	}
+	post := pepeunittest.TarEntry{Name: pepeunit.PostUpdateHookPath, Body: "cat data/config.txt > post.txt\n"}
+	if err := applyHookArchive(t, client, pre, post); err != nil {
+		t.Fatalf("UpdateDeviceProgram() = %v", err)
+	}
+
+	files := readTree(t, fixture.Dir)
+	if strings.TrimSpace(files["pre.txt"]) != fixture.Dir {
+		t.Errorf("pre hook wrote %q, want the unit directory %q", files["pre.txt"], fixture.Dir)
+	}
+	if files["post.txt"] != "v2" {
+		t.Errorf("post hook wrote %q, want the applied config", files["post.txt"])
+	}
+}
+
+func TestPreUpdateHookFailureAborts(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = applyHookArchive(t, client, pepeunittest.TarEntry{Name: pepeunit.PreUpdateHookPath, Body: "echo migration failed\nexit 3\n"})
+	if err == nil || !strings.Contains(err.Error(), "pre-update hook") {
+		t.Fatalf("UpdateDeviceProgram() = %v, want aborted by the pre hook", err)
+	}
+	if _, err := os.Stat(filepath.Join(fixture.Dir, "data", "config.txt")); !os.IsNotExist(err) {
+		t.Errorf("files applied after the pre hook failed: %v", err)
+	}
+}
+
+func TestPostUpdateHookFailureKeepsUpdate(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyHookArchive(t, client, pepeunittest.TarEntry{Name: pepeunit.PostUpdateHookPath, Body: "exit 1\n"}); err != nil {
+		t.Fatalf("UpdateDeviceProgram() = %v, want the update kept", err)
+	}
+	if got := readTree(t, fixture.Dir)["data/config.txt"]; got != "v2" {
+		t.Errorf("config.txt = %q", got)
+	}
+}
+
+func TestUpdateHookTimeout(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	config := fixture.Config()
+	config.UpdateHookTimeout = 100 * time.Millisecond
+	client, err := fixture.NewClientWith(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = applyHookArchive(t, client, pepeunittest.TarEntry{Name: pepeunit.PreUpdateHookPath, Body: "sleep 10\n"})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("UpdateDeviceProgram() = %v, want a hook timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hook killed after %v", elapsed)
+	}
+}
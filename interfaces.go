@@ -31,6 +31,18 @@ type MQTTClient interface {
 	SetInputHandler(handler MQTTInputHandler)
 }
 
+// ConnectionNotifier is implemented by MQTT clients able to report broker connection changes
+type ConnectionNotifier interface {
+	// SetConnectionHandlers sets callbacks for broker connections and connection losses
+	SetConnectionHandlers(onConnect func(), onLost func(error))
+}
+
+// DownloadProgressNotifier is implemented by REST clients able to report download progress
+type DownloadProgressNotifier interface {
+	// SetDownloadProgressHandler sets the callback invoked while downloads are written to disk
+	SetDownloadProgressHandler(handler func(progress DownloadProgress))
+}
+
 // RESTClient interface for REST API operations
 type RESTClient interface {
 	// DownloadUpdate downloads firmware update archive
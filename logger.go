@@ -1,9 +1,11 @@
 package pepeunit
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,6 +27,7 @@ type Logger struct {
 	mutex              sync.RWMutex
 	isPublishing       bool // Flag to prevent recursive MQTT publishing
 	fileManager        *FileManager
+	inFlight           atomic.Int64
 }
 
 // NewLogger creates a new logger instance
@@ -120,6 +123,9 @@ func (l *Logger) log(level LogLevel, message string, fileOnly bool) {
 		return
 	}
 
+	l.inFlight.Add(1)
+	defer l.inFlight.Add(-1)
+
 	timestamp := time.Now().UTC().Format(time.RFC3339)
 
 	logEntry := map[string]interface{}{
@@ -147,10 +153,11 @@ func (l *Logger) log(level LogLevel, message string, fileOnly bool) {
 
 	l.mutex.Lock()
 	l.logEntries = append(l.logEntries, entry)
+	mqttClient := l.mqttClient
 	l.mutex.Unlock()
 
-	if !fileOnly && l.mqttClient != nil && l.schema != nil {
-		l.publishToMQTT(logEntry)
+	if !fileOnly && mqttClient != nil && l.schema != nil {
+		l.publishToMQTT(mqttClient, logEntry)
 	}
 }
 
@@ -161,7 +168,7 @@ func (l *Logger) shouldPublishToMQTT(level LogLevel) bool {
 }
 
 // publishToMQTT publishes log entry to MQTT
-func (l *Logger) publishToMQTT(logEntry map[string]interface{}) {
+func (l *Logger) publishToMQTT(mqttClient MQTTClient, logEntry map[string]interface{}) {
 	if l.schema == nil {
 		return
 	}
@@ -191,7 +198,7 @@ func (l *Logger) publishToMQTT(logEntry map[string]interface{}) {
 		}
 
 		// Publish to MQTT topic
-		err = l.mqttClient.Publish(topics[0], string(logJSON))
+		err = mqttClient.Publish(topics[0], string(logJSON))
 		if err != nil {
 			// Log error but don't fail the log operation
 			// We can't use logger here as it would cause recursion
@@ -256,7 +263,32 @@ func (l *Logger) ResetLog() {
 	}
 }
 
-// SetMQTTClient sets the MQTT client for log publishing
+// SetMQTTClient sets the MQTT client for log publishing, nil disables publishing
 func (l *Logger) SetMQTTClient(mqttClient MQTTClient) {
+	l.mutex.Lock()
 	l.mqttClient = mqttClient
+	l.mutex.Unlock()
+}
+
+// Flush waits for in-flight log writes and publishes, then syncs the log file to disk
+func (l *Logger) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for l.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	if l.logFilePath == "" || !l.fileManager.FileExists(l.logFilePath) {
+		return nil
+	}
+	f, err := os.OpenFile(l.logFilePath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
 }
@@ -0,0 +1,75 @@
+package pepeunit
+
+import (
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DefaultAvailabilityOnline is the retained payload of the availability topic while the unit is connected
+const DefaultAvailabilityOnline = "online"
+
+// DefaultAvailabilityOffline is the retained payload of the availability topic after a disconnect or as the Last Will
+const DefaultAvailabilityOffline = "offline"
+
+// MQTTAvailabilityConfig configures the availability topic, TopicKey is an output base topic key of the schema
+type MQTTAvailabilityConfig struct {
+	Disabled bool
+	TopicKey string
+	Online   string
+	Offline  string
+}
+
+// withDefaults fills the empty fields with availability/pepeunit and the default payloads
+func (cfg MQTTAvailabilityConfig) withDefaults() MQTTAvailabilityConfig {
+	if cfg.TopicKey == "" {
+		cfg.TopicKey = string(BaseOutputTopicTypeAvailabilityPepeunit)
+	}
+	if cfg.Online == "" {
+		cfg.Online = DefaultAvailabilityOnline
+	}
+	if cfg.Offline == "" {
+		cfg.Offline = DefaultAvailabilityOffline
+	}
+	return cfg
+}
+
+// SetAvailability sets the availability topic options used from the next connect
+func (c *PepeunitMQTTClient) SetAvailability(cfg MQTTAvailabilityConfig) {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	c.availability = cfg.withDefaults()
+}
+
+// availabilityTopic returns the first schema topic of the availability key, empty when availability is off
+func (c *PepeunitMQTTClient) availabilityTopic() string {
+	if c.availability.Disabled || c.SchemaManager == nil {
+		return ""
+	}
+	if topics := c.SchemaManager.GetOutputBaseTopic()[c.availability.TopicKey]; len(topics) > 0 {
+		return topics[0]
+	}
+	return ""
+}
+
+// applyAvailability registers the offline payload as the Last Will of the next connection
+func (c *PepeunitMQTTClient) applyAvailability(opts *mqtt.ClientOptions) string {
+	topic := c.availabilityTopic()
+	if topic != "" {
+		opts.SetWill(topic, c.availability.Offline, 1, true)
+	}
+	return topic
+}
+
+// publishAvailability publishes a retained availability payload
+func (c *PepeunitMQTTClient) publishAvailability(client mqtt.Client, topic, payload string) {
+	token := client.Publish(topic, 1, true, payload)
+	if !token.WaitTimeout(5 * time.Second) {
+		c.Logger.Warning(fmt.Sprintf("Timeout publishing %s to availability topic %s", payload, topic), true)
+		return
+	}
+	if token.Error() != nil {
+		c.Logger.Warning(fmt.Sprintf("Failed to publish %s to availability topic %s: %v", payload, topic, token.Error()), true)
+	}
+}
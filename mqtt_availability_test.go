@@ -0,0 +1,126 @@
+package pepeunit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+// newBrokerClient returns a paho based MQTT client of the fixture unit pointed at the broker, disconnected when the test ends
+func newBrokerClient(t *testing.T, fixture *pepeunittest.Fixture, broker *pepeunittest.FakeBroker) *pepeunit.PepeunitMQTTClient {
+	t.Helper()
+	if err := fixture.SetBroker(broker); err != nil {
+		t.Fatal(err)
+	}
+	settings := pepeunit.NewSettings(fixture.EnvPath)
+	schema, err := pepeunit.NewSchemaManager(fixture.SchemaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := pepeunit.NewPepeunitMQTTClient(settings, schema, pepeunit.NewLogger("", nil, nil, settings, false))
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client
+}
+
+// newAvailabilityFixture returns a fixture whose schema has the availability/pepeunit topic, and that topic
+func newAvailabilityFixture(t *testing.T) (*pepeunittest.Fixture, string) {
+	t.Helper()
+	fixture := pepeunittest.NewTestFixture(t)
+	pepeunittest.AddAvailabilityTopic(fixture.Schema, fixture.UnitUUID)
+	if err := fixture.SetSchema(fixture.Schema); err != nil {
+		t.Fatal(err)
+	}
+	return fixture, pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseOutputTopicTypeAvailabilityPepeunit))
+}
+
+func TestAvailabilityLastWill(t *testing.T) {
+	fixture, topic := newAvailabilityFixture(t)
+	broker := pepeunittest.NewTestBroker(t)
+	client := newBrokerClient(t, fixture, broker)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	retained := func(want string) func() bool {
+		return func() bool {
+			payload, _ := broker.Retained(topic)
+			return payload == want
+		}
+	}
+	waitFor(t, "online published", retained(pepeunit.DefaultAvailabilityOnline))
+	connect := broker.Connects()[0]
+	if connect.WillTopic != topic || connect.WillPayload != pepeunit.DefaultAvailabilityOffline || !connect.WillRetain {
+		t.Fatalf("connect = %+v, want a retained offline will on %s", connect, topic)
+	}
+
+	// A dropped connection leaves the will behind until the client is back
+	broker.DropConnection(connect.ClientID)
+	waitFor(t, "will published", func() bool { return len(broker.Wills()) == 1 })
+	if will := broker.Wills()[0]; will.Topic != topic || will.Payload != pepeunit.DefaultAvailabilityOffline {
+		t.Errorf("will = %+v", will)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(broker.Connects()) < 2 || !retained(pepeunit.DefaultAvailabilityOnline)() {
+		if time.Now().After(deadline) {
+			t.Fatal("client never came back online")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A graceful disconnect discards the will, so the client publishes offline itself
+	if err := client.Disconnect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "offline published", retained(pepeunit.DefaultAvailabilityOffline))
+	published := broker.PublishedTo(topic)
+	if published[len(published)-1] != pepeunit.DefaultAvailabilityOffline || len(broker.Wills()) != 1 {
+		t.Errorf("client publishes = %v and wills %v, want offline last from the client", published, broker.Wills())
+	}
+}
+
+func TestAvailabilityConfig(t *testing.T) {
+	fixture, topic := newAvailabilityFixture(t)
+	broker := pepeunittest.NewTestBroker(t)
+
+	custom := newBrokerClient(t, fixture, broker)
+	custom.SetAvailability(pepeunit.MQTTAvailabilityConfig{Online: "up", Offline: "down"})
+	if err := custom.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "custom online payload", func() bool {
+		payload, _ := broker.Retained(topic)
+		return payload == "up"
+	})
+	if connect := broker.Connects()[0]; connect.WillPayload != "down" {
+		t.Errorf("will payload = %q, want down", connect.WillPayload)
+	}
+
+	disabled := newBrokerClient(t, fixture, broker)
+	disabled.SetAvailability(pepeunit.MQTTAvailabilityConfig{Disabled: true})
+	if err := disabled.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := disabled.Disconnect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if connect := broker.Connects()[1]; connect.WillTopic != "" {
+		t.Errorf("disabled availability registered a will on %s", connect.WillTopic)
+	}
+
+	// Without the topic in the schema there is nothing to announce
+	if err := fixture.SetSchema(pepeunittest.NewSchema(fixture.UnitUUID, nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	missing := newBrokerClient(t, fixture, broker)
+	if err := missing.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if connect := broker.Connects()[2]; connect.WillTopic != "" {
+		t.Errorf("schema without availability topic registered a will on %s", connect.WillTopic)
+	}
+	if got := broker.PublishedTo(topic); len(got) != 1 {
+		t.Errorf("availability publishes = %v, want only the custom client online", got)
+	}
+}
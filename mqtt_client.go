@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -15,18 +16,26 @@ type PepeunitMQTTClient struct {
 	*AbstractMQTTClient
 	client          mqtt.Client
 	handler         MQTTInputHandler
-	connected       bool
+	connected       atomic.Bool
 	subscriptionsMu sync.RWMutex
 	subscriptions   map[string]byte
 	connectMu       sync.Mutex
+	onConnect       func()
+	onLost          func(error)
+	tlsConfig       *MQTTTLSConfig
+	transportConfig *MQTTTransportConfig
+	publishQueue    *publishQueue
+	availability    MQTTAvailabilityConfig
+	willTopic       string
+	session         *MQTTSessionConfig
 }
 
 // NewPepeunitMQTTClient creates a new MQTT client
 func NewPepeunitMQTTClient(settings *Settings, schemaManager *SchemaManager, logger *Logger) *PepeunitMQTTClient {
 	return &PepeunitMQTTClient{
 		AbstractMQTTClient: NewAbstractMQTTClient(settings, schemaManager, logger),
-		connected:          false,
 		subscriptions:      make(map[string]byte),
+		availability:       MQTTAvailabilityConfig{}.withDefaults(),
 	}
 }
 
@@ -38,15 +47,15 @@ func (c *PepeunitMQTTClient) Connect(ctx context.Context) error {
 }
 
 func (c *PepeunitMQTTClient) connectLocked() error {
-	// Generate unique client ID like Python client
-	clientID := c.generateUniqueClientID()
-
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", c.Settings.PU_MQTT_HOST, c.Settings.PU_MQTT_PORT))
-	opts.SetClientID(clientID)
+	if err := c.applyTransport(opts); err != nil {
+		return err
+	}
+	if err := c.applySession(opts); err != nil {
+		return err
+	}
 	opts.SetUsername(c.Settings.PU_AUTH_TOKEN) // Use PU_AUTH_TOKEN as username like Python client
 	opts.SetPassword("")                       // Empty password like Python client
-	opts.SetCleanSession(true)
 	opts.SetAutoReconnect(true)
 	opts.SetConnectRetry(true)
 	opts.SetConnectTimeout(10 * time.Second)
@@ -54,18 +63,30 @@ func (c *PepeunitMQTTClient) connectLocked() error {
 	opts.SetPingTimeout(time.Duration(c.Settings.PU_MQTT_PING_INTERVAL) * time.Second)
 	opts.SetKeepAlive(time.Duration(c.Settings.PU_MQTT_KEEPALIVE) * time.Second)
 	opts.SetWriteTimeout(10 * time.Second)
+	willTopic, online := c.applyAvailability(opts), c.availability.Online
+	c.willTopic = willTopic
 
 	// Set connection lost handler
 	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
-		c.connected = false
+		c.connected.Store(false)
 		c.Logger.Error(fmt.Sprintf("MQTT connection lost: %v", err))
+		if c.onLost != nil {
+			c.onLost(err)
+		}
 	})
 
 	// Set on connect handler
 	opts.SetOnConnectHandler(func(client mqtt.Client) {
-		c.connected = true
+		c.connected.Store(true)
 		c.Logger.Info("Connected to MQTT Broker")
 		go c.resubscribeAll()
+		if willTopic != "" {
+			go c.publishAvailability(client, willTopic, online)
+		}
+		go c.replayPublishQueue()
+		if c.onConnect != nil {
+			c.onConnect()
+		}
 	})
 
 	// Set reconnect handler
@@ -83,7 +104,7 @@ func (c *PepeunitMQTTClient) connectLocked() error {
 		return fmt.Errorf("failed to connect to MQTT broker: %v", token.Error())
 	}
 
-	c.connected = true
+	c.connected.Store(true)
 	c.Logger.Info("MQTT client connected successfully")
 	return nil
 }
@@ -94,8 +115,12 @@ func (c *PepeunitMQTTClient) Disconnect(ctx context.Context) error {
 	defer c.connectMu.Unlock()
 
 	if c.client != nil && c.client.IsConnected() {
+		// A graceful disconnect discards the Last Will, so the offline state is published here
+		if c.willTopic != "" {
+			c.publishAvailability(c.client, c.willTopic, c.availability.Offline)
+		}
 		c.client.Disconnect(250) // Wait 250ms for disconnect
-		c.connected = false
+		c.connected.Store(false)
 		c.Logger.Info("Disconnected from MQTT Broker", true)
 	}
 	return nil
@@ -184,8 +209,12 @@ func (c *PepeunitMQTTClient) UnsubscribeTopics(topics []string) error {
 	return nil
 }
 
-// Publish publishes a message to a specific topic
+// Publish publishes a message to a specific topic, with the publish queue enabled a message
+// that cannot be delivered is queued instead of reconnecting
 func (c *PepeunitMQTTClient) Publish(topic, message string) error {
+	if queue := c.currentPublishQueue(); queue != nil {
+		return c.publishQueued(queue, topic, message)
+	}
 	if c.client == nil || !c.client.IsConnected() {
 		if err := c.forceReconnect(); err != nil {
 			return err
@@ -220,6 +249,12 @@ func (c *PepeunitMQTTClient) SetInputHandler(handler MQTTInputHandler) {
 	c.handler = handler
 }
 
+// SetConnectionHandlers sets callbacks for broker connections and connection losses
+func (c *PepeunitMQTTClient) SetConnectionHandlers(onConnect func(), onLost func(error)) {
+	c.onConnect = onConnect
+	c.onLost = onLost
+}
+
 // messageHandler handles incoming MQTT messages
 func (c *PepeunitMQTTClient) messageHandler(client mqtt.Client, msg mqtt.Message) {
 	defer func() {
@@ -238,7 +273,7 @@ func (c *PepeunitMQTTClient) messageHandler(client mqtt.Client, msg mqtt.Message
 
 // IsConnected returns whether the client is connected
 func (c *PepeunitMQTTClient) IsConnected() bool {
-	return c.connected && c.client != nil && c.client.IsConnected()
+	return c.connected.Load() && c.client != nil && c.client.IsConnected()
 }
 
 // GetClient returns the underlying MQTT client
@@ -253,7 +288,7 @@ func (c *PepeunitMQTTClient) forceReconnect() error {
 	if c.client != nil {
 		c.client.Disconnect(250)
 	}
-	c.connected = false
+	c.connected.Store(false)
 	return c.connectLocked()
 }
 
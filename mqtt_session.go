@@ -0,0 +1,85 @@
+package pepeunit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTTSessionConfig enables a persistent broker session: a stable client ID kept in ClientIDFilePath,
+// clean session off and in-flight QoS 1 messages stored in StoreDir
+type MQTTSessionConfig struct {
+	ClientIDFilePath string
+	StoreDir         string
+}
+
+// mqttSessionIdentity is the persisted client ID of a unit
+type mqttSessionIdentity struct {
+	UnitUUID string `json:"unit_uuid"`
+	ClientID string `json:"client_id"`
+}
+
+// SetPersistentSession enables the persistent session from the next connect
+func (c *PepeunitMQTTClient) SetPersistentSession(cfg MQTTSessionConfig) error {
+	if cfg.ClientIDFilePath == "" || cfg.StoreDir == "" {
+		return fmt.Errorf("client ID file path and store directory are required for a persistent session")
+	}
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	c.session = &cfg
+	return nil
+}
+
+// sessionClientID returns the persisted client ID of the unit. A new one, the unit UUID with a random
+// suffix telling installations of the same unit apart, is created when the file is missing or belongs to another unit.
+func (c *PepeunitMQTTClient) sessionClientID() (string, error) {
+	unitUUID, err := c.Settings.UnitUUID()
+	if err != nil {
+		return "", err
+	}
+	var identity mqttSessionIdentity
+	if data, err := os.ReadFile(c.session.ClientIDFilePath); err == nil {
+		if json.Unmarshal(data, &identity) == nil && identity.UnitUUID == unitUUID && identity.ClientID != "" {
+			return identity.ClientID, nil
+		}
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	identity = mqttSessionIdentity{UnitUUID: unitUUID, ClientID: unitUUID + "-" + hex.EncodeToString(suffix)}
+	if err := NewFileManager().WriteJSON(c.session.ClientIDFilePath, identity); err != nil {
+		return "", fmt.Errorf("failed to persist client ID: %v", err)
+	}
+	c.Logger.Info(fmt.Sprintf("Created MQTT session client ID %s", identity.ClientID), true)
+	return identity.ClientID, nil
+}
+
+// applySession sets the client ID and session options of the next connection
+func (c *PepeunitMQTTClient) applySession(opts *mqtt.ClientOptions) error {
+	if c.session == nil {
+		// Generate unique client ID like Python client
+		opts.SetClientID(c.generateUniqueClientID())
+		opts.SetCleanSession(true)
+		return nil
+	}
+
+	clientID, err := c.sessionClientID()
+	if err != nil {
+		return fmt.Errorf("failed to get MQTT session client ID: %v", err)
+	}
+	if err := os.MkdirAll(c.session.StoreDir, 0755); err != nil {
+		return fmt.Errorf("failed to create MQTT store: %v", err)
+	}
+	opts.SetClientID(clientID)
+	opts.SetCleanSession(false)
+	opts.SetStore(mqtt.NewFileStore(c.session.StoreDir))
+	// Messages the broker kept for the session arrive before the subscriptions of a new process are restored
+	opts.SetDefaultPublishHandler(c.messageHandler)
+	return nil
+}
@@ -0,0 +1,114 @@
+package pepeunit_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+// sessionConfig returns a persistent session kept in the fixture directory
+func sessionConfig(fixture *pepeunittest.Fixture) pepeunit.MQTTSessionConfig {
+	return pepeunit.MQTTSessionConfig{
+		ClientIDFilePath: filepath.Join(fixture.Dir, "mqtt_client_id.json"),
+		StoreDir:         filepath.Join(fixture.Dir, "mqtt_store"),
+	}
+}
+
+// connectSession connects a new client of the fixture unit like a fresh process and returns its CONNECT packet.
+// The client is disconnected again unless the test keeps using it.
+func connectSession(t *testing.T, fixture *pepeunittest.Fixture, broker *pepeunittest.FakeBroker, session *pepeunit.MQTTSessionConfig, keep bool) (*pepeunit.PepeunitMQTTClient, pepeunittest.BrokerConnect) {
+	t.Helper()
+	client := newBrokerClient(t, fixture, broker)
+	if session != nil {
+		if err := client.SetPersistentSession(*session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	connects := broker.Connects()
+	connect := connects[len(connects)-1]
+	if !keep {
+		if err := client.Disconnect(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "broker to see the disconnect", func() bool { return !broker.Connected(connect.ClientID) })
+	}
+	return client, connect
+}
+
+func TestPersistentSessionClientID(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	broker := pepeunittest.NewTestBroker(t)
+	session := sessionConfig(fixture)
+
+	client, first := connectSession(t, fixture, broker, &session, false)
+	if first.CleanSession || !strings.HasPrefix(first.ClientID, fixture.UnitUUID+"-") {
+		t.Fatalf("connect = %+v, want a unit client ID with clean session off", first)
+	}
+	if info, err := os.Stat(session.StoreDir); err != nil || !info.IsDir() {
+		t.Errorf("message store not created: %v", err)
+	}
+
+	_, again := connectSession(t, fixture, broker, &session, false)
+	if again.ClientID != first.ClientID {
+		t.Errorf("client ID after a restart = %s, want %s", again.ClientID, first.ClientID)
+	}
+
+	// A file copied from another unit is not reused
+	other := pepeunittest.NewUUID()
+	if err := pepeunittest.WriteJSONFile(session.ClientIDFilePath, map[string]string{"unit_uuid": other, "client_id": other + "-00000000"}); err != nil {
+		t.Fatal(err)
+	}
+	_, copied := connectSession(t, fixture, broker, &session, false)
+	if !strings.HasPrefix(copied.ClientID, fixture.UnitUUID+"-") || copied.ClientID == first.ClientID {
+		t.Errorf("client ID with another unit's file = %s", copied.ClientID)
+	}
+
+	_, plain := connectSession(t, fixture, broker, nil, false)
+	if !plain.CleanSession || strings.HasPrefix(plain.ClientID, fixture.UnitUUID) {
+		t.Errorf("connect without a session = %+v, want a random client ID with clean session", plain)
+	}
+
+	if err := client.SetPersistentSession(pepeunit.MQTTSessionConfig{StoreDir: session.StoreDir}); err == nil {
+		t.Error("SetPersistentSession() without a client ID file accepted")
+	}
+}
+
+func TestPersistentSessionReceivesMissedMessages(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	broker := pepeunittest.NewTestBroker(t)
+	session := sessionConfig(fixture)
+	topic := fixture.InputTopic("input/pepeunit")
+
+	client, connect := connectSession(t, fixture, broker, &session, true)
+	if err := client.SubscribeTopics([]string{topic}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Disconnect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "broker to see the disconnect", func() bool { return !broker.Connected(connect.ClientID) })
+	broker.Publish(topic, "42")
+
+	// The restarted process gets the command before it subscribes again
+	restarted := newBrokerClient(t, fixture, broker)
+	received := make(chan pepeunit.MQTTMessage, 1)
+	restarted.SetInputHandler(func(msg pepeunit.MQTTMessage) { received <- msg })
+	if err := restarted.SetPersistentSession(session); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "missed message", func() bool { return len(received) == 1 })
+	if msg := <-received; msg.Topic != topic || string(msg.Payload) != "42" {
+		t.Errorf("received %s %s", msg.Topic, msg.Payload)
+	}
+}
@@ -0,0 +1,121 @@
+package pepeunit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DefaultMQTTTLSPort is the MQTT over TLS port, a broker on it is reached with TLS unless PU_MQTT_TLS is false
+const DefaultMQTTTLSPort = 8883
+
+// DefaultMQTTWebsocketTLSPort is the HTTPS port, a WebSocket broker on it is reached with TLS unless PU_MQTT_TLS is false
+const DefaultMQTTWebsocketTLSPort = 443
+
+// MQTTTLSConfig configures TLS for the broker connection, nil flags are left to the settings
+type MQTTTLSConfig struct {
+	Enabled            *bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify *bool
+}
+
+// mqttTLSConfigFromSettings reads the PU_MQTT_TLS* settings, relative file paths are resolved from the env file directory
+func mqttTLSConfigFromSettings(settings *Settings, transport MQTTTransport) MQTTTLSConfig {
+	tlsPort := DefaultMQTTTLSPort
+	if transport == MQTTTransportWebsocket {
+		tlsPort = DefaultMQTTWebsocketTLSPort
+	}
+	enabled := settings.PU_MQTT_PORT == tlsPort
+	if value, ok := settings.Get("PU_MQTT_TLS"); ok {
+		enabled = toBool(value)
+	}
+	cfg := MQTTTLSConfig{Enabled: &enabled}
+	resolve := func(key string) string {
+		path, _ := settings.GetString(key)
+		if path == "" || filepath.IsAbs(path) || settings.EnvFilePath == "" {
+			return path
+		}
+		return filepath.Join(filepath.Dir(settings.EnvFilePath), path)
+	}
+	cfg.CAFile = resolve("PU_MQTT_TLS_CA_FILE")
+	cfg.CertFile = resolve("PU_MQTT_TLS_CERT_FILE")
+	cfg.KeyFile = resolve("PU_MQTT_TLS_KEY_FILE")
+	cfg.ServerName, _ = settings.GetString("PU_MQTT_TLS_SERVER_NAME")
+	if value, ok := settings.Get("PU_MQTT_TLS_INSECURE"); ok {
+		insecure := toBool(value)
+		cfg.InsecureSkipVerify = &insecure
+	}
+	return cfg
+}
+
+// merge returns cfg with the fields set in override replacing its own
+func (cfg MQTTTLSConfig) merge(override *MQTTTLSConfig) MQTTTLSConfig {
+	if override == nil {
+		return cfg
+	}
+	if override.Enabled != nil {
+		cfg.Enabled = override.Enabled
+	}
+	if override.CAFile != "" {
+		cfg.CAFile = override.CAFile
+	}
+	if override.CertFile != "" {
+		cfg.CertFile = override.CertFile
+	}
+	if override.KeyFile != "" {
+		cfg.KeyFile = override.KeyFile
+	}
+	if override.ServerName != "" {
+		cfg.ServerName = override.ServerName
+	}
+	if override.InsecureSkipVerify != nil {
+		cfg.InsecureSkipVerify = override.InsecureSkipVerify
+	}
+	return cfg
+}
+
+// build returns the crypto/tls configuration, nil when TLS is disabled
+func (cfg MQTTTLSConfig) build() (*tls.Config, error) {
+	if cfg.Enabled == nil || !*cfg.Enabled {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify != nil && *cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MQTT CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in MQTT CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("MQTT client certificate and key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MQTT client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// SetTLSConfig sets TLS options taking precedence over the PU_MQTT_TLS* settings
+func (c *PepeunitMQTTClient) SetTLSConfig(cfg MQTTTLSConfig) {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	c.tlsConfig = &cfg
+}
@@ -0,0 +1,216 @@
+package pepeunit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// writeTestCertificate writes a self-signed CA certificate for broker.test and its key as name.pem and name.key in dir
+func writeTestCertificate(t *testing.T, dir, name string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{"broker.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// brokerOptions writes env as dir/env.json and returns the broker options the MQTT client would connect with
+func brokerOptions(t *testing.T, dir string, env map[string]interface{}, override *MQTTTLSConfig) (*mqtt.ClientOptions, error) {
+	t.Helper()
+	envPath := filepath.Join(dir, "env.json")
+	data, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(envPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	settings := NewSettings(envPath)
+	client := NewPepeunitMQTTClient(settings, nil, NewLogger("", nil, nil, settings, false))
+	if override != nil {
+		client.SetTLSConfig(*override)
+	}
+	opts := mqtt.NewClientOptions()
+	return opts, client.applyTransport(opts)
+}
+
+func TestMQTTTLSSelection(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name     string
+		env      map[string]interface{}
+		override *MQTTTLSConfig
+		want     string
+	}{
+		{name: "plain port", env: map[string]interface{}{"PU_MQTT_PORT": 1883}, want: "tcp"},
+		{name: "tls port", env: map[string]interface{}{"PU_MQTT_PORT": 8883}, want: "ssl"},
+		{name: "tls port turned off", env: map[string]interface{}{"PU_MQTT_PORT": 8883, "PU_MQTT_TLS": false}, want: "tcp"},
+		{name: "tls on a custom port", env: map[string]interface{}{"PU_MQTT_PORT": 1883, "PU_MQTT_TLS": true}, want: "ssl"},
+		{name: "websocket https port", env: map[string]interface{}{"PU_MQTT_PORT": 443, "PU_MQTT_TRANSPORT": "websocket"}, want: "wss"},
+		{name: "websocket plain port", env: map[string]interface{}{"PU_MQTT_PORT": 8883, "PU_MQTT_TRANSPORT": "websocket"}, want: "ws"},
+		{name: "override wins", env: map[string]interface{}{"PU_MQTT_PORT": 8883, "PU_MQTT_TLS": true}, override: &MQTTTLSConfig{Enabled: &disabled}, want: "tcp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.env["PU_MQTT_HOST"] = "broker.test"
+			opts, err := brokerOptions(t, t.TempDir(), tt.env, tt.override)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(opts.Servers) != 1 || opts.Servers[0].Scheme != tt.want {
+				t.Fatalf("brokers = %v, want scheme %s", opts.Servers, tt.want)
+			}
+			if enabled := opts.TLSConfig != nil; enabled != (tt.want == "ssl" || tt.want == "wss") {
+				t.Errorf("TLS config set = %v with a %s broker", enabled, tt.want)
+			}
+		})
+	}
+}
+
+func TestMQTTTLSHandshake(t *testing.T) {
+	dir := t.TempDir()
+	serverCert := writeTestCertificate(t, dir, "ca")
+	clientCert := writeTestCertificate(t, dir, "client")
+	clientLeaf, err := x509.ParseCertificate(clientCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientLeaf)
+
+	// The broker presents a certificate for broker.test and only accepts the client certificate
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	handshakes := make(chan error)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			handshakes <- conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	tests := []struct {
+		name string
+		env  map[string]interface{}
+		want bool
+	}{
+		// Relative certificate paths are resolved next to env.json
+		{name: "trusted broker", env: map[string]interface{}{"PU_MQTT_TLS_CA_FILE": "ca.pem", "PU_MQTT_TLS_SERVER_NAME": "broker.test"}, want: true},
+		{name: "name mismatch", env: map[string]interface{}{"PU_MQTT_TLS_CA_FILE": "ca.pem"}},
+		{name: "unknown authority", env: map[string]interface{}{"PU_MQTT_TLS_SERVER_NAME": "broker.test"}},
+		{name: "insecure", env: map[string]interface{}{"PU_MQTT_TLS_INSECURE": true}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.env["PU_MQTT_HOST"] = host
+			tt.env["PU_MQTT_PORT"] = port
+			tt.env["PU_MQTT_TLS"] = true
+			tt.env["PU_MQTT_TLS_CERT_FILE"] = "client.pem"
+			tt.env["PU_MQTT_TLS_KEY_FILE"] = filepath.Join(dir, "client.key")
+			opts, err := brokerOptions(t, dir, tt.env, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conn, err := tls.Dial("tcp", opts.Servers[0].Host, opts.TLSConfig)
+			if err == nil {
+				conn.Close()
+			}
+			serverErr := <-handshakes
+			if accepted := err == nil && serverErr == nil; accepted != tt.want {
+				t.Errorf("handshake accepted = %v (client %v, broker %v), want %v", accepted, err, serverErr, tt.want)
+			}
+		})
+	}
+}
+
+func TestMQTTTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCertificate(t, dir, "client")
+	if err := os.WriteFile(filepath.Join(dir, "empty.pem"), []byte("not a certificate\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		env  map[string]interface{}
+		want string
+	}{
+		{env: map[string]interface{}{"PU_MQTT_TLS_CA_FILE": "missing.pem"}, want: "failed to read MQTT CA file"},
+		{env: map[string]interface{}{"PU_MQTT_TLS_CA_FILE": "empty.pem"}, want: "no certificates found"},
+		{env: map[string]interface{}{"PU_MQTT_TLS_CERT_FILE": "client.pem"}, want: "must be set together"},
+		{env: map[string]interface{}{"PU_MQTT_TLS_CERT_FILE": "client.pem", "PU_MQTT_TLS_KEY_FILE": "empty.pem"}, want: "failed to load MQTT client certificate"},
+	}
+	for _, tt := range tests {
+		tt.env["PU_MQTT_HOST"] = "broker.test"
+		tt.env["PU_MQTT_PORT"] = 8883
+		if _, err := brokerOptions(t, dir, tt.env, nil); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%v: error %v, want %q", tt.env, err, tt.want)
+		}
+	}
+
+	// The same files are not read at all with TLS turned off
+	env := map[string]interface{}{"PU_MQTT_HOST": "broker.test", "PU_MQTT_PORT": 8883, "PU_MQTT_TLS": false, "PU_MQTT_TLS_CA_FILE": "missing.pem"}
+	if _, err := brokerOptions(t, dir, env, nil); err != nil {
+		t.Errorf("disabled TLS read the CA file: %v", err)
+	}
+}
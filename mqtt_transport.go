@@ -0,0 +1,128 @@
+package pepeunit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DefaultMQTTWebsocketPath is the default HTTP path of a WebSocket broker endpoint
+const DefaultMQTTWebsocketPath = "/mqtt"
+
+// MQTTTransportConfig selects the network transport of the broker connection
+type MQTTTransportConfig struct {
+	Transport     MQTTTransport
+	WebsocketPath string
+	Headers       http.Header
+	ProxyURL      string
+}
+
+// mqttTransportConfigFromSettings reads PU_MQTT_TRANSPORT, PU_MQTT_WS_PATH, PU_MQTT_WS_HEADERS and PU_MQTT_PROXY
+func mqttTransportConfigFromSettings(settings *Settings) MQTTTransportConfig {
+	cfg := MQTTTransportConfig{Transport: MQTTTransportTCP, WebsocketPath: DefaultMQTTWebsocketPath}
+	if transport, _ := settings.GetString("PU_MQTT_TRANSPORT"); transport != "" {
+		cfg.Transport = MQTTTransport(strings.ToLower(transport))
+	}
+	if path, _ := settings.GetString("PU_MQTT_WS_PATH"); path != "" {
+		cfg.WebsocketPath = path
+	}
+	cfg.ProxyURL, _ = settings.GetString("PU_MQTT_PROXY")
+
+	// Headers are an object in env.json, or the same object encoded as a string
+	value, _ := settings.Get("PU_MQTT_WS_HEADERS")
+	if encoded, ok := value.(string); ok && encoded != "" {
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(encoded), &decoded); err == nil {
+			value = decoded
+		}
+	}
+	if headers, ok := value.(map[string]interface{}); ok {
+		cfg.Headers = http.Header{}
+		for key, header := range headers {
+			cfg.Headers.Set(key, toString(header))
+		}
+	}
+	return cfg
+}
+
+// merge returns cfg with the fields set in override replacing its own, override headers are added
+func (cfg MQTTTransportConfig) merge(override *MQTTTransportConfig) MQTTTransportConfig {
+	if override == nil {
+		return cfg
+	}
+	if override.Transport != "" {
+		cfg.Transport = override.Transport
+	}
+	if override.WebsocketPath != "" {
+		cfg.WebsocketPath = override.WebsocketPath
+	}
+	if override.ProxyURL != "" {
+		cfg.ProxyURL = override.ProxyURL
+	}
+	if len(override.Headers) > 0 {
+		headers := cfg.Headers.Clone()
+		if headers == nil {
+			headers = http.Header{}
+		}
+		for key, values := range override.Headers {
+			headers[key] = values
+		}
+		cfg.Headers = headers
+	}
+	return cfg
+}
+
+// SetTransportConfig sets transport options taking precedence over the PU_MQTT_TRANSPORT settings
+func (c *PepeunitMQTTClient) SetTransportConfig(cfg MQTTTransportConfig) {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	c.transportConfig = &cfg
+}
+
+// applyTransport sets the broker URL, TLS and WebSocket options of the next connection
+func (c *PepeunitMQTTClient) applyTransport(opts *mqtt.ClientOptions) error {
+	transport := mqttTransportConfigFromSettings(c.Settings).merge(c.transportConfig)
+	tlsConfig, err := mqttTLSConfigFromSettings(c.Settings, transport.Transport).merge(c.tlsConfig).build()
+	if err != nil {
+		return err
+	}
+
+	host := fmt.Sprintf("%s:%d", c.Settings.PU_MQTT_HOST, c.Settings.PU_MQTT_PORT)
+	switch transport.Transport {
+	case MQTTTransportTCP:
+		scheme := "tcp"
+		if tlsConfig != nil {
+			scheme = "ssl"
+		}
+		opts.AddBroker(scheme + "://" + host)
+	case MQTTTransportWebsocket:
+		scheme := "ws"
+		if tlsConfig != nil {
+			scheme = "wss"
+		}
+		path := "/" + strings.TrimPrefix(transport.WebsocketPath, "/")
+		opts.AddBroker(scheme + "://" + host + path)
+		if transport.Headers != nil {
+			opts.SetHTTPHeaders(transport.Headers)
+		}
+		websocketOptions := &mqtt.WebsocketOptions{}
+		if transport.ProxyURL != "" {
+			proxyURL, err := url.Parse(transport.ProxyURL)
+			if err != nil {
+				return fmt.Errorf("invalid MQTT proxy URL: %v", err)
+			}
+			websocketOptions.Proxy = http.ProxyURL(proxyURL)
+		}
+		opts.SetWebsocketOptions(websocketOptions)
+	default:
+		return fmt.Errorf("unknown MQTT transport: %s", transport.Transport)
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	return nil
+}
@@ -10,3 +10,54 @@ const DefaultCycleSpeed = 100 * time.Millisecond
 
 // DefaultRestartMode is the default restart mode
 const DefaultRestartMode = RestartModeRestartExec
+
+// DefaultRestartExitCode is the exit code of RestartModeRestartExitCode, EX_TEMPFAIL from sysexits.h
+const DefaultRestartExitCode = 75
+
+// DefaultInputQueueSize is the default queue capacity of every MQTT input worker
+const DefaultInputQueueSize = 100
+
+// DefaultUpdateConfirmCycles is the default number of main cycles confirming an update when MQTT is disabled
+const DefaultUpdateConfirmCycles = 50
+
+// DefaultUpdateMaxBootAttempts is the default number of boots of an unconfirmed binary before rollback
+const DefaultUpdateMaxBootAttempts = 3
+
+// DefaultDownloadRetries is the default number of times an interrupted download is resumed
+const DefaultDownloadRetries = 5
+
+// DefaultDownloadRetryBackoff is the default delay before the first download retry, doubled on every next one
+const DefaultDownloadRetryBackoff = time.Second
+
+// DefaultDownloadIdleTimeout is the default time a download waits for response headers or for the next data
+const DefaultDownloadIdleTimeout = 30 * time.Second
+
+// DefaultUpdateHistoryLimit is the default number of finished updates kept in the update history
+const DefaultUpdateHistoryLimit = 20
+
+// DefaultExtractMaxTotalSize is the default maximum extracted size of an update archive
+const DefaultExtractMaxTotalSize = 1 << 30
+
+// DefaultExtractMaxFiles is the default maximum number of entries in an update archive
+const DefaultExtractMaxFiles = 10000
+
+// DefaultExtractMaxCompressionRatio is the default maximum ratio of extracted to compressed size of an update archive
+const DefaultExtractMaxCompressionRatio = 200
+
+// DefaultUpdateHookTimeout is the default time an update hook may run before it is killed
+const DefaultUpdateHookTimeout = time.Minute
+
+// DefaultTransferChunkSize is the default chunk size of the MQTT file transfer
+const DefaultTransferChunkSize = 32 * 1024
+
+// DefaultTransferMaxSize is the default maximum size of a file received with the MQTT file transfer
+const DefaultTransferMaxSize = 256 << 20
+
+// DefaultTransferTimeout is the default time an MQTT file transfer may go without data before it fails
+const DefaultTransferTimeout = 2 * time.Minute
+
+// DefaultPublishQueueMaxBytes is the default size limit of the publish queue
+const DefaultPublishQueueMaxBytes = 1 << 20
+
+// DefaultPublishQueueMaxAge is the default time a queued message is kept before it is discarded
+const DefaultPublishQueueMaxAge = 24 * time.Hour
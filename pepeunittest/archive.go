@@ -0,0 +1,118 @@
+package pepeunittest
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"sort"
+	"time"
+)
+
+// TarEntry is an entry of an archive built by BuildTar. A Linkname makes it a symlink, or a hardlink with Hardlink set.
+type TarEntry struct {
+	Name     string
+	Body     string
+	Mode     int64
+	Dir      bool
+	Linkname string
+	Hardlink bool
+}
+
+// BuildTar returns a plain tar archive with the entries in the given order
+func BuildTar(entries []TarEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.Name,
+			Mode:     entry.Mode,
+			Size:     int64(len(entry.Body)),
+			ModTime:  time.Now(),
+			Typeflag: tar.TypeReg,
+		}
+		switch {
+		case entry.Dir:
+			header.Typeflag, header.Size = tar.TypeDir, 0
+		case entry.Hardlink:
+			header.Typeflag, header.Linkname, header.Size = tar.TypeLink, entry.Linkname, 0
+		case entry.Linkname != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, entry.Linkname, 0
+		}
+		if header.Mode == 0 {
+			header.Mode = 0644
+			if entry.Dir {
+				header.Mode = 0755
+			}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if header.Size > 0 {
+			if _, err := tw.Write([]byte(entry.Body)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// BuildTarGz returns a tar.gz archive with the given files, keys are slash separated paths
+func BuildTarGz(files map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]TarEntry, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, TarEntry{Name: name, Body: files[name]})
+	}
+	data, err := BuildTar(entries)
+	if err != nil {
+		return nil, err
+	}
+	return Gzip(data)
+}
+
+// BuildZip returns a zip archive with the given files, keys are slash separated paths
+func BuildZip(files map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(files[name])); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Gzip returns data compressed with gzip
+func Gzip(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
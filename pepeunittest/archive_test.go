@@ -0,0 +1,110 @@
+package pepeunittest
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildTarGz(t *testing.T) {
+	files := map[string]string{"b/main": "binary", "a.json": "{}", "empty": ""}
+	archive, err := BuildTarGz(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := tar.NewReader(gz)
+	var names []string
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(reader)
+		if string(data) != files[header.Name] {
+			t.Errorf("%s = %q, want %q", header.Name, data, files[header.Name])
+		}
+		names = append(names, header.Name)
+	}
+	if got := strings.Join(names, ","); got != "a.json,b/main,empty" {
+		t.Errorf("entries = %s, want sorted names", got)
+	}
+}
+
+func TestBuildTarEntryTypes(t *testing.T) {
+	archive, err := BuildTar([]TarEntry{
+		{Name: "bin", Dir: true},
+		{Name: "bin/app", Body: "binary", Mode: 0755},
+		{Name: "current", Linkname: "bin/app"},
+		{Name: "copy", Linkname: "bin/app", Hardlink: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		typeflag byte
+		mode     int64
+		linkname string
+	}{
+		{tar.TypeDir, 0755, ""},
+		{tar.TypeReg, 0755, ""},
+		{tar.TypeSymlink, 0644, "bin/app"},
+		{tar.TypeLink, 0644, "bin/app"},
+	}
+	reader := tar.NewReader(bytes.NewReader(archive))
+	for i, w := range want {
+		header, err := reader.Next()
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		if header.Typeflag != w.typeflag || header.Mode != w.mode || header.Linkname != w.linkname {
+			t.Errorf("entry %s = type %c mode %o link %q, want type %c mode %o link %q",
+				header.Name, header.Typeflag, header.Mode, header.Linkname, w.typeflag, w.mode, w.linkname)
+		}
+	}
+}
+
+func TestBuildZip(t *testing.T) {
+	archive, err := BuildZip(map[string]string{"app/main": "zipped"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.File) != 1 || reader.File[0].Name != "app/main" {
+		t.Fatalf("entries = %v", reader.File)
+	}
+}
+
+func TestWriteReadTree(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "unit")
+	if files, err := ReadTree(root); err != nil || len(files) != 0 {
+		t.Fatalf("ReadTree() of a missing root = %v, %v", files, err)
+	}
+	files := map[string]string{"main": "binary", "data/db/rows": "1"}
+	if err := WriteTree(root, files); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadTree(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(files) || got["data/db/rows"] != "1" || got["main"] != "binary" {
+		t.Errorf("ReadTree() = %v, want %v", got, files)
+	}
+}
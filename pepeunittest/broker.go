@@ -0,0 +1,539 @@
+package pepeunittest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// MQTT 3.1.1 control packet types handled by FakeBroker
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// BrokerConnect is a CONNECT packet received by FakeBroker
+type BrokerConnect struct {
+	ClientID     string
+	Username     string
+	CleanSession bool
+	WillTopic    string
+	WillPayload  string
+	WillRetain   bool
+}
+
+// FakeBroker is a minimal MQTT 3.1.1 broker on a local port for tests of the paho based PepeunitMQTTClient.
+// It acknowledges QoS 0 and 1 publishes, keeps retained messages and publishes the Last Will of a dropped connection.
+// A client connecting with clean session off keeps its subscriptions, and messages for it are queued while it is away.
+type FakeBroker struct {
+	listener  net.Listener
+	mutex     sync.Mutex
+	conns     map[*brokerConn]struct{}
+	sessions  map[string]*brokerSession
+	connects  []BrokerConnect
+	published []PublishedMessage
+	wills     []PublishedMessage
+	retained  map[string]string
+	wg        sync.WaitGroup
+}
+
+// brokerConn is a client connection of FakeBroker
+type brokerConn struct {
+	conn       net.Conn
+	clientID   string
+	writeMutex sync.Mutex
+	packetID   uint16
+	will       *PublishedMessage
+	willRetain bool
+	session    *brokerSession
+}
+
+// brokerSession holds the subscriptions of a client, a persistent one also the messages queued while it is away
+type brokerSession struct {
+	conn          *brokerConn
+	subscriptions map[string]struct{}
+	pending       []PublishedMessage
+}
+
+// NewFakeBroker starts a broker listening on a free local port
+func NewFakeBroker() (*FakeBroker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &FakeBroker{
+		listener: listener,
+		conns:    make(map[*brokerConn]struct{}),
+		sessions: make(map[string]*brokerSession),
+		retained: make(map[string]string),
+	}
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// NewTestBroker starts a broker that is closed when the test ends
+func NewTestBroker(t testing.TB) *FakeBroker {
+	t.Helper()
+	b, err := NewFakeBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	return b
+}
+
+// Host returns the address the broker listens on
+func (b *FakeBroker) Host() string {
+	host, _, _ := net.SplitHostPort(b.listener.Addr().String())
+	return host
+}
+
+// Port returns the port the broker listens on
+func (b *FakeBroker) Port() int {
+	_, port, _ := net.SplitHostPort(b.listener.Addr().String())
+	value, _ := strconv.Atoi(port)
+	return value
+}
+
+// Close stops the broker and drops all connections without publishing their Last Will
+func (b *FakeBroker) Close() {
+	b.listener.Close()
+	b.mutex.Lock()
+	for c := range b.conns {
+		c.will = nil
+		c.conn.Close()
+	}
+	b.mutex.Unlock()
+	b.wg.Wait()
+}
+
+// DropConnections closes all client connections like a network failure, their Last Will is published
+func (b *FakeBroker) DropConnections() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for c := range b.conns {
+		c.conn.Close()
+	}
+}
+
+// DropConnection closes the connection of a client like a network failure, it reports whether the client was connected
+func (b *FakeBroker) DropConnection(clientID string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for c := range b.conns {
+		if c.clientID == clientID {
+			c.conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// Connected reports whether a client with the client ID is connected
+func (b *FakeBroker) Connected(clientID string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for c := range b.conns {
+		if c.clientID == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Connects returns the CONNECT packets received so far in order
+func (b *FakeBroker) Connects() []BrokerConnect {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]BrokerConnect{}, b.connects...)
+}
+
+// Published returns the messages published by clients in order, Last Will messages are not included
+func (b *FakeBroker) Published() []PublishedMessage {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]PublishedMessage{}, b.published...)
+}
+
+// Wills returns the Last Will messages published for dropped connections in order
+func (b *FakeBroker) Wills() []PublishedMessage {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]PublishedMessage{}, b.wills...)
+}
+
+// PublishedTo returns the payloads clients published to a topic in order
+func (b *FakeBroker) PublishedTo(topic string) []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	payloads := make([]string, 0)
+	for _, msg := range b.published {
+		if msg.Topic == topic {
+			payloads = append(payloads, msg.Payload)
+		}
+	}
+	return payloads
+}
+
+// Retained returns the retained payload of a topic
+func (b *FakeBroker) Retained(topic string) (string, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	payload, ok := b.retained[topic]
+	return payload, ok
+}
+
+// Publish delivers a message with QoS 1 to the clients subscribed to the topic, persistent sessions get it on their next connect
+func (b *FakeBroker) Publish(topic, payload string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.route(PublishedMessage{Topic: topic, Payload: payload})
+}
+
+// accept serves connections until the listener is closed
+func (b *FakeBroker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.wg.Add(1)
+		go b.serve(&brokerConn{conn: conn})
+	}
+}
+
+// serve reads the packets of a connection, the Last Will is published unless the client sent DISCONNECT
+func (b *FakeBroker) serve(c *brokerConn) {
+	defer b.wg.Done()
+	defer c.conn.Close()
+	defer func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.conns, c)
+		if c.session != nil && c.session.conn == c {
+			c.session.conn = nil
+		}
+		if c.will != nil {
+			b.wills = append(b.wills, *c.will)
+			b.publishLocked(*c.will, c.willRetain)
+		}
+	}()
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		header, body, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case packetConnect:
+			if err := b.connect(c, body); err != nil {
+				return
+			}
+		case packetPublish:
+			b.receivePublish(c, header, body)
+		case packetSubscribe:
+			b.subscribe(c, body)
+		case packetUnsubscribe:
+			b.unsubscribe(c, body)
+		case packetPingreq:
+			c.write(packetPingresp<<4, nil)
+		case packetDisconnect:
+			b.mutex.Lock()
+			c.will = nil
+			b.mutex.Unlock()
+			return
+		case packetPuback:
+		default:
+			return
+		}
+	}
+}
+
+// connect records a CONNECT packet and accepts the connection
+func (b *FakeBroker) connect(c *brokerConn, body []byte) error {
+	p := &packetReader{data: body}
+	if p.readString() != "MQTT" || p.readByte() != 4 {
+		return fmt.Errorf("unsupported protocol")
+	}
+	flags := p.readByte()
+	p.readUint16()
+	record := BrokerConnect{ClientID: p.readString(), CleanSession: flags&0x02 != 0}
+	if flags&0x04 != 0 {
+		record.WillTopic = p.readString()
+		record.WillPayload = p.readString()
+		record.WillRetain = flags&0x20 != 0
+	}
+	if flags&0x80 != 0 {
+		record.Username = p.readString()
+	}
+	if p.err != nil {
+		return p.err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.connects = append(b.connects, record)
+	c.clientID = record.ClientID
+	if record.WillTopic != "" {
+		c.will = &PublishedMessage{Topic: record.WillTopic, Payload: record.WillPayload}
+		c.willRetain = record.WillRetain
+	}
+
+	// A second connection with the same client ID takes the session over
+	session, present := b.sessions[record.ClientID]
+	if present && session.conn != nil {
+		session.conn.conn.Close()
+	}
+	if record.CleanSession || !present {
+		session = &brokerSession{subscriptions: make(map[string]struct{})}
+		present = false
+		delete(b.sessions, record.ClientID)
+		if !record.CleanSession {
+			b.sessions[record.ClientID] = session
+		}
+	}
+	session.conn = c
+	c.session = session
+	b.conns[c] = struct{}{}
+
+	ack := []byte{0, 0}
+	if present {
+		ack[0] = 1
+	}
+	c.write(packetConnack<<4, ack)
+	for _, msg := range session.pending {
+		c.send(msg, false)
+	}
+	session.pending = nil
+	return nil
+}
+
+// receivePublish records a client publish, acknowledges QoS 1 and routes it to the subscribers
+func (b *FakeBroker) receivePublish(c *brokerConn, header byte, body []byte) {
+	p := &packetReader{data: body}
+	msg := PublishedMessage{Topic: p.readString()}
+	qos := (header >> 1) & 0x03
+	var id uint16
+	if qos > 0 {
+		id = p.readUint16()
+	}
+	msg.Payload = string(p.readRest())
+	if p.err != nil {
+		return
+	}
+
+	b.mutex.Lock()
+	b.published = append(b.published, msg)
+	b.publishLocked(msg, header&0x01 != 0)
+	b.mutex.Unlock()
+	if qos > 0 {
+		c.write(packetPuback<<4, binary.BigEndian.AppendUint16(nil, id))
+	}
+}
+
+// subscribe acknowledges the filters with QoS 1 at most and sends the retained messages they match
+func (b *FakeBroker) subscribe(c *brokerConn, body []byte) {
+	p := &packetReader{data: body}
+	ack := binary.BigEndian.AppendUint16(nil, p.readUint16())
+	var filters []string
+	for p.err == nil && len(p.data) > 0 {
+		filter := p.readString()
+		qos := p.readByte()
+		if qos > 1 {
+			qos = 1
+		}
+		filters = append(filters, filter)
+		ack = append(ack, qos)
+	}
+	if p.err != nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, filter := range filters {
+		c.session.subscriptions[filter] = struct{}{}
+	}
+	c.write(packetSuback<<4, ack)
+	for topic, payload := range b.retained {
+		for _, filter := range filters {
+			if TopicMatches(filter, topic) {
+				c.send(PublishedMessage{Topic: topic, Payload: payload}, true)
+				break
+			}
+		}
+	}
+}
+
+// unsubscribe removes the filters of a connection
+func (b *FakeBroker) unsubscribe(c *brokerConn, body []byte) {
+	p := &packetReader{data: body}
+	id := p.readUint16()
+	b.mutex.Lock()
+	for p.err == nil && len(p.data) > 0 {
+		delete(c.session.subscriptions, p.readString())
+	}
+	b.mutex.Unlock()
+	c.write(packetUnsuback<<4, binary.BigEndian.AppendUint16(nil, id))
+}
+
+// publishLocked stores a retained message and routes it, an empty retained payload clears the topic
+func (b *FakeBroker) publishLocked(msg PublishedMessage, retain bool) {
+	if retain {
+		if msg.Payload == "" {
+			delete(b.retained, msg.Topic)
+		} else {
+			b.retained[msg.Topic] = msg.Payload
+		}
+	}
+	b.route(msg)
+}
+
+// route sends a message to every connection subscribed to its topic and queues it for persistent sessions that are away
+func (b *FakeBroker) route(msg PublishedMessage) {
+	sessions := make(map[*brokerSession]struct{}, len(b.conns)+len(b.sessions))
+	for c := range b.conns {
+		sessions[c.session] = struct{}{}
+	}
+	for _, session := range b.sessions {
+		sessions[session] = struct{}{}
+	}
+	for session := range sessions {
+		for filter := range session.subscriptions {
+			if !TopicMatches(filter, msg.Topic) {
+				continue
+			}
+			if session.conn == nil {
+				session.pending = append(session.pending, msg)
+			} else {
+				session.conn.send(msg, false)
+			}
+			break
+		}
+	}
+}
+
+// send writes a QoS 1 PUBLISH packet, the acknowledgement of the client is ignored
+func (c *brokerConn) send(msg PublishedMessage, retain bool) {
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+	header := byte(packetPublish<<4 | 0x02)
+	if retain {
+		header |= 0x01
+	}
+	body := appendString(nil, msg.Topic)
+	body = binary.BigEndian.AppendUint16(body, c.packetID)
+	c.write(header, append(body, msg.Payload...))
+}
+
+// write sends a packet, errors surface as a closed connection in serve
+func (c *brokerConn) write(header byte, body []byte) {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, _ = c.conn.Write(append(packet, body...))
+}
+
+// readPacket reads the fixed header and the body of a control packet
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		if i == 4 {
+			return 0, nil, fmt.Errorf("malformed remaining length")
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// packetReader decodes the fields of a packet body, the first error stops all further reads
+type packetReader struct {
+	data []byte
+	err  error
+}
+
+// take returns the next n bytes of the body
+func (p *packetReader) take(n int) []byte {
+	if p.err != nil || len(p.data) < n {
+		p.err = fmt.Errorf("packet too short")
+		return nil
+	}
+	value := p.data[:n]
+	p.data = p.data[n:]
+	return value
+}
+
+func (p *packetReader) readByte() byte {
+	if value := p.take(1); value != nil {
+		return value[0]
+	}
+	return 0
+}
+
+func (p *packetReader) readUint16() uint16 {
+	if value := p.take(2); value != nil {
+		return binary.BigEndian.Uint16(value)
+	}
+	return 0
+}
+
+func (p *packetReader) readString() string {
+	return string(p.take(int(p.readUint16())))
+}
+
+func (p *packetReader) readRest() []byte {
+	return p.take(len(p.data))
+}
+
+// appendString appends a length prefixed UTF-8 string
+func appendString(data []byte, value string) []byte {
+	data = binary.BigEndian.AppendUint16(data, uint16(len(value)))
+	return append(data, value...)
+}
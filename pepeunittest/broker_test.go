@@ -0,0 +1,130 @@
+package pepeunittest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// connectPaho connects a paho client to the broker with the given will, messages of subscriptions are sent to received
+func connectPaho(t *testing.T, broker *FakeBroker, clientID string, will *PublishedMessage, received chan<- PublishedMessage) mqtt.Client {
+	t.Helper()
+	client, _ := connectPahoSession(t, broker, clientID, true, will, received)
+	return client
+}
+
+// connectPahoSession connects a paho client with clean session on or off and reports whether the broker had a session
+func connectPahoSession(t *testing.T, broker *FakeBroker, clientID string, clean bool, will *PublishedMessage, received chan<- PublishedMessage) (mqtt.Client, bool) {
+	t.Helper()
+	opts := mqtt.NewClientOptions().AddBroker(fmt.Sprintf("tcp://%s:%d", broker.Host(), broker.Port())).SetClientID(clientID)
+	opts.SetAutoReconnect(false)
+	opts.SetCleanSession(clean)
+	if will != nil {
+		opts.SetWill(will.Topic, will.Payload, 1, true)
+	}
+	opts.SetDefaultPublishHandler(func(client mqtt.Client, msg mqtt.Message) {
+		received <- PublishedMessage{Topic: msg.Topic(), Payload: string(msg.Payload())}
+	})
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect %s: %v", clientID, token.Error())
+	}
+	return client, token.(*mqtt.ConnectToken).SessionPresent()
+}
+
+func TestFakeBroker(t *testing.T) {
+	broker := NewTestBroker(t)
+	received := make(chan PublishedMessage, 10)
+	unit := connectPaho(t, broker, "unit", &PublishedMessage{Topic: "units/1/availability", Payload: "offline"}, received)
+	if token := unit.Publish("units/1/availability", 1, true, "online"); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("publish: %v", token.Error())
+	}
+
+	// A late subscriber gets the retained message, then the will of the dropped unit
+	watcher := connectPaho(t, broker, "watcher", nil, received)
+	defer watcher.Disconnect(250)
+	if token := watcher.Subscribe("units/+/availability", 1, nil); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribe: %v", token.Error())
+	}
+	if !broker.DropConnection("unit") {
+		t.Fatal("unit not connected")
+	}
+	for _, want := range []string{"online", "offline"} {
+		select {
+		case msg := <-received:
+			if msg.Payload != want {
+				t.Errorf("received %+v, want %s", msg, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s never delivered", want)
+		}
+	}
+	if payload, _ := broker.Retained("units/1/availability"); payload != "offline" {
+		t.Errorf("retained %q after the drop", payload)
+	}
+
+	connects := broker.Connects()
+	if len(connects) != 2 || connects[0].WillTopic != "units/1/availability" || !connects[0].WillRetain || connects[1].WillTopic != "" {
+		t.Errorf("connects = %+v", connects)
+	}
+	if got := broker.PublishedTo("units/1/availability"); len(got) != 1 || len(broker.Wills()) != 1 {
+		t.Errorf("client publishes = %v, the will is not one of them", got)
+	}
+}
+
+// disconnect disconnects a paho client and waits until the broker has seen it
+func disconnect(t *testing.T, broker *FakeBroker, client mqtt.Client, clientID string) {
+	t.Helper()
+	client.Disconnect(250)
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.Connected(clientID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s still connected", clientID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFakeBrokerSession(t *testing.T) {
+	broker := NewTestBroker(t)
+	received := make(chan PublishedMessage, 10)
+	unit, present := connectPahoSession(t, broker, "unit", false, nil, received)
+	if present {
+		t.Error("new session reported as present")
+	}
+	if token := unit.Subscribe("units/1/input", 1, nil); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribe: %v", token.Error())
+	}
+	disconnect(t, broker, unit, "unit")
+
+	// The command sent while the unit is away waits for the same client ID, a clean connect discards it
+	broker.Publish("units/1/input", "reboot")
+	unit, present = connectPahoSession(t, broker, "unit", false, nil, received)
+	defer unit.Disconnect(250)
+	if !present {
+		t.Error("kept session not reported as present")
+	}
+	select {
+	case msg := <-received:
+		if msg.Topic != "units/1/input" || msg.Payload != "reboot" {
+			t.Errorf("received %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued message never delivered")
+	}
+
+	disconnect(t, broker, unit, "unit")
+	broker.Publish("units/1/input", "lost")
+	unit, present = connectPahoSession(t, broker, "unit", true, nil, received)
+	if present {
+		t.Error("clean connect reported a session")
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("clean session received %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
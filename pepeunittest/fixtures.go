@@ -0,0 +1,323 @@
+package pepeunittest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+)
+
+// TestDomain is the domain used in generated topic URLs
+const TestDomain = "pepeunit.test"
+
+// NewUUID returns a random UUID4 string
+func NewUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// NewJWT returns an HS256 signed unit token carrying unitUUID, as accepted by Settings.UnitUUID
+func NewJWT(unitUUID string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(map[string]interface{}{
+		"uuid": unitUUID,
+		"type": "Unit",
+		"iat":  time.Now().Unix(),
+	})
+	signingInput := encode(header) + "." + encode(payload)
+	mac := hmac.New(sha256.New, []byte("pepeunittest"))
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + encode(mac.Sum(nil))
+}
+
+// NewEnv returns env values for a unit, values from overrides replace the defaults
+func NewEnv(unitUUID string, overrides map[string]interface{}) map[string]interface{} {
+	env := map[string]interface{}{
+		"PU_DOMAIN":              TestDomain,
+		"PU_HTTP_TYPE":           "https",
+		"PU_APP_PREFIX":          AppPrefix,
+		"PU_API_ACTUAL_PREFIX":   APIPrefix,
+		"PU_MQTT_HOST":           "mqtt." + TestDomain,
+		"PU_MQTT_PORT":           1883,
+		"PU_AUTH_TOKEN":          NewJWT(unitUUID),
+		"PU_SECRET_KEY":          base64.StdEncoding.EncodeToString(randomBytes(32)),
+		"PU_ENCRYPT_KEY":         base64.StdEncoding.EncodeToString(randomBytes(32)),
+		"PU_COMMIT_VERSION":      "0000000000000000000000000000000000000000",
+		"PU_MQTT_PING_INTERVAL":  20,
+		"PU_MQTT_KEEPALIVE":      60,
+		"PU_STATE_SEND_INTERVAL": 300,
+		"PU_MIN_LOG_LEVEL":       "Debug",
+		"PU_MAX_LOG_LENGTH":      64,
+	}
+	for key, value := range overrides {
+		env[key] = value
+	}
+	return env
+}
+
+// NewSchema returns a unit schema with all base topics and one topic URL per input and output topic key
+func NewSchema(unitUUID string, inputKeys, outputKeys []string) map[string]interface{} {
+	return map[string]interface{}{
+		string(pepeunit.DestinationTopicTypeInputBaseTopic): map[string]interface{}{
+			string(pepeunit.BaseInputTopicTypeUpdatePepeunit):       []interface{}{BaseTopic(unitUUID, string(pepeunit.BaseInputTopicTypeUpdatePepeunit))},
+			string(pepeunit.BaseInputTopicTypeEnvUpdatePepeunit):    []interface{}{BaseTopic(unitUUID, string(pepeunit.BaseInputTopicTypeEnvUpdatePepeunit))},
+			string(pepeunit.BaseInputTopicTypeSchemaUpdatePepeunit): []interface{}{BaseTopic(unitUUID, string(pepeunit.BaseInputTopicTypeSchemaUpdatePepeunit))},
+			string(pepeunit.BaseInputTopicTypeLogSyncPepeunit):      []interface{}{BaseTopic(unitUUID, string(pepeunit.BaseInputTopicTypeLogSyncPepeunit))},
+		},
+		string(pepeunit.DestinationTopicTypeOutputBaseTopic): map[string]interface{}{
+			string(pepeunit.BaseOutputTopicTypeLogPepeunit):   []interface{}{BaseTopic(unitUUID, string(pepeunit.BaseOutputTopicTypeLogPepeunit))},
+			string(pepeunit.BaseOutputTopicTypeStatePepeunit): []interface{}{BaseTopic(unitUUID, string(pepeunit.BaseOutputTopicTypeStatePepeunit))},
+		},
+		string(pepeunit.DestinationTopicTypeInputTopic):  unitNodeTopics(inputKeys),
+		string(pepeunit.DestinationTopicTypeOutputTopic): unitNodeTopics(outputKeys),
+	}
+}
+
+// AddTransferTopics adds the transfer/pepeunit and transfer_ack/pepeunit base topics of the MQTT file transfer to a schema built by NewSchema
+func AddTransferTopics(schema map[string]interface{}, unitUUID string) {
+	input := schema[string(pepeunit.DestinationTopicTypeInputBaseTopic)].(map[string]interface{})
+	input[string(pepeunit.BaseInputTopicTypeTransferPepeunit)] = []interface{}{BaseTopic(unitUUID, string(pepeunit.BaseInputTopicTypeTransferPepeunit))}
+	output := schema[string(pepeunit.DestinationTopicTypeOutputBaseTopic)].(map[string]interface{})
+	output[string(pepeunit.BaseOutputTopicTypeTransferAckPepeunit)] = []interface{}{BaseTopic(unitUUID, string(pepeunit.BaseOutputTopicTypeTransferAckPepeunit))}
+}
+
+// AddAvailabilityTopic adds the availability/pepeunit output base topic to a schema built by NewSchema
+func AddAvailabilityTopic(schema map[string]interface{}, unitUUID string) {
+	output := schema[string(pepeunit.DestinationTopicTypeOutputBaseTopic)].(map[string]interface{})
+	output[string(pepeunit.BaseOutputTopicTypeAvailabilityPepeunit)] = []interface{}{BaseTopic(unitUUID, string(pepeunit.BaseOutputTopicTypeAvailabilityPepeunit))}
+}
+
+// BaseTopic returns the URL of a base topic of a unit
+func BaseTopic(unitUUID, topicKey string) string {
+	return TestDomain + "/" + unitUUID + "/" + topicKey
+}
+
+// unitNodeTopics returns one unit node topic URL per topic key
+func unitNodeTopics(keys []string) map[string]interface{} {
+	topics := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		topics[key] = []interface{}{TestDomain + "/" + NewUUID() + "/pepeunit"}
+	}
+	return topics
+}
+
+// WriteJSONFile writes data as indented JSON to path
+func WriteJSONFile(path string, data interface{}) error {
+	b, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// WriteTree writes files below root, keys are slash separated paths
+func WriteTree(root string, files map[string]string) error {
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadTree returns the regular files below root keyed by slash separated path, a missing root has no files
+func ReadTree(root string) (map[string]string, error) {
+	files := map[string]string{}
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return files, nil
+	}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	return files, err
+}
+
+// SignUpdate returns the verification data of an update artifact signed with privateKey
+func SignUpdate(data []byte, privateKey ed25519.PrivateKey) pepeunit.UpdateVerification {
+	digest := sha256.Sum256(data)
+	return pepeunit.UpdateVerification{
+		SHA256:    hex.EncodeToString(digest[:]),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, digest[:])),
+	}
+}
+
+// randomBytes returns n random bytes
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
+
+// Fixture is a complete unit environment: env and schema files, a fake broker and a fake API
+type Fixture struct {
+	Dir        string
+	UnitUUID   string
+	EnvPath    string
+	SchemaPath string
+	LogPath    string
+	Env        map[string]interface{}
+	Schema     map[string]interface{}
+	MQTT       *FakeMQTTClient
+	Server     *FakeServer
+}
+
+// NewFixture writes env.json and schema.json into dir and starts a fake API serving them.
+// The schema has input/pepeunit and output/pepeunit topics like the universal test unit.
+func NewFixture(dir string) (*Fixture, error) {
+	unitUUID := NewUUID()
+	token := NewJWT(unitUUID)
+	server := NewFakeServer(token, unitUUID)
+
+	overrides := server.SettingsValues()
+	env := NewEnv(unitUUID, overrides)
+	schema := NewSchema(unitUUID, []string{"input/pepeunit"}, []string{"output/pepeunit"})
+	server.SetEnv(env)
+	server.SetSchema(schema)
+
+	f := &Fixture{
+		Dir:        dir,
+		UnitUUID:   unitUUID,
+		EnvPath:    filepath.Join(dir, "env.json"),
+		SchemaPath: filepath.Join(dir, "schema.json"),
+		LogPath:    filepath.Join(dir, "log.json"),
+		Env:        env,
+		Schema:     schema,
+		MQTT:       NewFakeMQTTClient(),
+		Server:     server,
+	}
+	if err := WriteJSONFile(f.EnvPath, env); err != nil {
+		server.Close()
+		return nil, err
+	}
+	if err := WriteJSONFile(f.SchemaPath, schema); err != nil {
+		server.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// NewTestFixture creates a fixture in a temporary directory of t and stops its fake API when the test ends
+func NewTestFixture(t testing.TB) *Fixture {
+	t.Helper()
+	f, err := NewFixture(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(f.Close)
+	return f
+}
+
+// SetSchema replaces the schema file and the schema served by the fake API
+func (f *Fixture) SetSchema(schema map[string]interface{}) error {
+	if err := WriteJSONFile(f.SchemaPath, schema); err != nil {
+		return err
+	}
+	f.Schema = schema
+	f.Server.SetSchema(schema)
+	return nil
+}
+
+// SetBroker points PU_MQTT_HOST and PU_MQTT_PORT of the env file and the fake API at a FakeBroker
+func (f *Fixture) SetBroker(broker *FakeBroker) error {
+	f.Env["PU_MQTT_HOST"] = broker.Host()
+	f.Env["PU_MQTT_PORT"] = broker.Port()
+	if err := WriteJSONFile(f.EnvPath, f.Env); err != nil {
+		return err
+	}
+	f.Server.SetEnv(f.Env)
+	return nil
+}
+
+// Config returns a client configuration using the fixture files, fake broker and fake API
+func (f *Fixture) Config() pepeunit.PepeunitClientConfig {
+	return pepeunit.PepeunitClientConfig{
+		EnvFilePath:    f.EnvPath,
+		SchemaFilePath: f.SchemaPath,
+		LogFilePath:    f.LogPath,
+		EnableMQTT:     true,
+		EnableREST:     true,
+		CycleSpeed:     10 * time.Millisecond,
+		RestartMode:    pepeunit.RestartModeNoRestart,
+		MQTTClient:     f.MQTT,
+	}
+}
+
+// NewClient creates a PepeunitClient for the fixture and subscribes it to all schema topics
+func (f *Fixture) NewClient() (*pepeunit.PepeunitClient, error) {
+	return f.NewClientWith(f.Config())
+}
+
+// NewClientWith creates a PepeunitClient from a configuration based on Config and subscribes it to all schema topics
+func (f *Fixture) NewClientWith(config pepeunit.PepeunitClientConfig) (*pepeunit.PepeunitClient, error) {
+	client, err := pepeunit.NewPepeunitClient(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.MQTT.Connect(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := client.SubscribeAllSchemaTopics(context.Background()); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// InputTopic returns the first topic URL of an input topic key
+func (f *Fixture) InputTopic(topicKey string) string {
+	return firstTopic(f.Schema, pepeunit.DestinationTopicTypeInputTopic, topicKey)
+}
+
+// OutputTopic returns the first topic URL of an output topic key
+func (f *Fixture) OutputTopic(topicKey string) string {
+	return firstTopic(f.Schema, pepeunit.DestinationTopicTypeOutputTopic, topicKey)
+}
+
+// Close stops the fake API
+func (f *Fixture) Close() {
+	f.Server.Close()
+}
+
+// firstTopic returns the first topic URL of a topic key in a schema section
+func firstTopic(schema map[string]interface{}, section pepeunit.DestinationTopicType, topicKey string) string {
+	topics, ok := schema[string(section)].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	list, ok := topics[topicKey].([]interface{})
+	if !ok || len(list) == 0 {
+		return ""
+	}
+	topic, _ := list[0].(string)
+	return topic
+}
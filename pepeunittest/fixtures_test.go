@@ -0,0 +1,165 @@
+package pepeunittest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+)
+
+func TestNewJWT(t *testing.T) {
+	tests := []struct {
+		name     string
+		unitUUID string
+	}{
+		{name: "random", unitUUID: NewUUID()},
+		{name: "fixed", unitUUID: "c1a9b8e0-3f4d-4a6b-9c2e-5d7f8a9b0c1d"},
+		{name: "needs padding", unitUUID: "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := NewJWT(tt.unitUUID)
+			if parts := strings.Split(token, "."); len(parts) != 3 {
+				t.Fatalf("token has %d parts", len(parts))
+			}
+			settings := pepeunit.NewSettingsWith("", map[string]interface{}{"PU_AUTH_TOKEN": token})
+			got, err := settings.UnitUUID()
+			if err != nil || got != tt.unitUUID {
+				t.Errorf("UnitUUID() = %q, %v, want %q", got, err, tt.unitUUID)
+			}
+		})
+	}
+}
+
+func TestNewEnv(t *testing.T) {
+	unitUUID := NewUUID()
+	tests := []struct {
+		name      string
+		overrides map[string]interface{}
+		key       string
+		want      interface{}
+	}{
+		{name: "default domain", key: "PU_DOMAIN", want: TestDomain},
+		{name: "default log length", key: "PU_MAX_LOG_LENGTH", want: 64},
+		{name: "override", overrides: map[string]interface{}{"PU_DOMAIN": "127.0.0.1:8080"}, key: "PU_DOMAIN", want: "127.0.0.1:8080"},
+		{name: "extra value", overrides: map[string]interface{}{"CUSTOM": true}, key: "CUSTOM", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := NewEnv(unitUUID, tt.overrides)
+			if env[tt.key] != tt.want {
+				t.Errorf("%s = %v, want %v", tt.key, env[tt.key], tt.want)
+			}
+			settings := pepeunit.NewSettingsWith("", map[string]interface{}{"PU_AUTH_TOKEN": env["PU_AUTH_TOKEN"]})
+			if got, _ := settings.UnitUUID(); got != unitUUID {
+				t.Errorf("token of unit %q, want %q", got, unitUUID)
+			}
+		})
+	}
+}
+
+func TestSignUpdate(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("firmware")
+	verification := SignUpdate(data, privateKey)
+
+	digest := sha256.Sum256(data)
+	if verification.SHA256 != hex.EncodeToString(digest[:]) {
+		t.Errorf("SHA256 = %s", verification.SHA256)
+	}
+	signature, err := base64.StdEncoding.DecodeString(verification.Signature)
+	if err != nil || !ed25519.Verify(publicKey, digest[:], signature) {
+		t.Errorf("signature does not verify: %v", err)
+	}
+}
+
+func TestFixtureClient(t *testing.T) {
+	fixture, err := NewFixture(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fixture.Close()
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unitUUID, err := client.GetUnitUUID(); err != nil || unitUUID != fixture.UnitUUID {
+		t.Fatalf("GetUnitUUID() = %q, %v, want %q", unitUUID, err, fixture.UnitUUID)
+	}
+
+	subscribed := strings.Join(fixture.MQTT.Subscriptions(), ",")
+	for _, topic := range []string{fixture.InputTopic("input/pepeunit"), BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeUpdatePepeunit))} {
+		if topic == "" || !strings.Contains(subscribed, topic) {
+			t.Errorf("topic %q not subscribed in %s", topic, subscribed)
+		}
+	}
+
+	var received []string
+	client.SetMQTTInputHandler(func(msg pepeunit.MQTTMessage) { received = append(received, string(msg.Payload)) })
+	if !fixture.MQTT.Inject(fixture.InputTopic("input/pepeunit"), "ping") {
+		t.Fatal("input topic message not delivered")
+	}
+	if strings.Join(received, ",") != "ping" {
+		t.Errorf("received = %v", received)
+	}
+
+	if err := client.PublishToTopics(context.Background(), "output/pepeunit", "pong"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fixture.MQTT.PublishedTo(fixture.OutputTopic("output/pepeunit")); strings.Join(got, ",") != "pong" {
+		t.Errorf("published = %v", got)
+	}
+
+	// env_update/pepeunit downloads the env from the fake API
+	fixture.Server.SetEnv(NewEnv(fixture.UnitUUID, mergeValues(fixture.Server.SettingsValues(), map[string]interface{}{"PU_STATE_SEND_INTERVAL": 42})))
+	fixture.MQTT.Inject(BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeEnvUpdatePepeunit)), "")
+	if got := client.GetSettings().PU_STATE_SEND_INTERVAL; got != 42 {
+		t.Errorf("PU_STATE_SEND_INTERVAL = %d after env update, want 42", got)
+	}
+}
+
+func mergeValues(values ...map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for _, m := range values {
+		for key, value := range m {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+func TestFixtureSetSchema(t *testing.T) {
+	fixture := NewTestFixture(t)
+	schema := NewSchema(fixture.UnitUUID, []string{"input/a", "input/b"}, []string{"output/pepeunit"})
+	if err := fixture.SetSchema(schema); err != nil {
+		t.Fatal(err)
+	}
+	config := fixture.Config()
+	config.EnableREST = false
+	client, err := fixture.NewClientWith(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.GetRESTClient() != nil {
+		t.Error("REST client enabled by the config override")
+	}
+	for _, topicKey := range []string{"input/a", "input/b"} {
+		got, ok := client.ResolveInputTopicKey(fixture.InputTopic(topicKey))
+		if !ok || got != topicKey {
+			t.Errorf("ResolveInputTopicKey(%s) = %q, %v", topicKey, got, ok)
+		}
+	}
+	if fixture.InputTopic("input/pepeunit") != "" {
+		t.Error("input/pepeunit kept after SetSchema")
+	}
+}
@@ -0,0 +1,244 @@
+package pepeunittest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+)
+
+// PublishedMessage is a message recorded by FakeMQTTClient
+type PublishedMessage struct {
+	Topic   string
+	Payload string
+}
+
+// FakeMQTTClient is an in-memory MQTTClient recording publishes and delivering injected messages
+type FakeMQTTClient struct {
+	mutex         sync.RWMutex
+	connected     bool
+	handler       pepeunit.MQTTInputHandler
+	onConnect     func()
+	onLost        func(error)
+	subscriptions map[string]struct{}
+	published     []PublishedMessage
+	connectErr    error
+	publishErr    error
+	publishHook   func(topic, payload string)
+}
+
+// NewFakeMQTTClient creates a disconnected fake MQTT client
+func NewFakeMQTTClient() *FakeMQTTClient {
+	return &FakeMQTTClient{
+		subscriptions: make(map[string]struct{}),
+	}
+}
+
+// Connect marks the client connected and calls the connect callback
+func (f *FakeMQTTClient) Connect(ctx context.Context) error {
+	f.mutex.Lock()
+	if f.connectErr != nil {
+		err := f.connectErr
+		f.mutex.Unlock()
+		return err
+	}
+	f.connected = true
+	onConnect := f.onConnect
+	f.mutex.Unlock()
+
+	if onConnect != nil {
+		onConnect()
+	}
+	return nil
+}
+
+// Disconnect marks the client disconnected
+func (f *FakeMQTTClient) Disconnect(ctx context.Context) error {
+	f.mutex.Lock()
+	f.connected = false
+	f.mutex.Unlock()
+	return nil
+}
+
+// SubscribeTopics records subscriptions
+func (f *FakeMQTTClient) SubscribeTopics(topics []string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, topic := range topics {
+		f.subscriptions[topic] = struct{}{}
+	}
+	return nil
+}
+
+// UnsubscribeTopics removes recorded subscriptions
+func (f *FakeMQTTClient) UnsubscribeTopics(topics []string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, topic := range topics {
+		delete(f.subscriptions, topic)
+	}
+	return nil
+}
+
+// Publish records a message, or returns the error set by SetPublishError
+func (f *FakeMQTTClient) Publish(topic, message string) error {
+	f.mutex.Lock()
+	if f.publishErr != nil {
+		f.mutex.Unlock()
+		return f.publishErr
+	}
+	f.published = append(f.published, PublishedMessage{Topic: topic, Payload: message})
+	hook := f.publishHook
+	f.mutex.Unlock()
+
+	if hook != nil {
+		hook(topic, message)
+	}
+	return nil
+}
+
+// SetPublishHook sets a function called with every recorded publish, e.g. to deliver it to another fake client
+func (f *FakeMQTTClient) SetPublishHook(hook func(topic, payload string)) {
+	f.mutex.Lock()
+	f.publishHook = hook
+	f.mutex.Unlock()
+}
+
+// SetInputHandler sets the handler receiving injected messages
+func (f *FakeMQTTClient) SetInputHandler(handler pepeunit.MQTTInputHandler) {
+	f.mutex.Lock()
+	f.handler = handler
+	f.mutex.Unlock()
+}
+
+// SetConnectionHandlers sets callbacks for connections and connection losses
+func (f *FakeMQTTClient) SetConnectionHandlers(onConnect func(), onLost func(error)) {
+	f.mutex.Lock()
+	f.onConnect = onConnect
+	f.onLost = onLost
+	f.mutex.Unlock()
+}
+
+// Inject delivers a message to the input handler if the topic matches a subscription.
+// It returns false when the message was not delivered.
+func (f *FakeMQTTClient) Inject(topic, payload string) bool {
+	f.mutex.RLock()
+	handler := f.handler
+	subscribed := false
+	for filter := range f.subscriptions {
+		if TopicMatches(filter, topic) {
+			subscribed = true
+			break
+		}
+	}
+	f.mutex.RUnlock()
+
+	if handler == nil || !subscribed {
+		return false
+	}
+	handler(pepeunit.MQTTMessage{Topic: topic, Payload: []byte(payload)})
+	return true
+}
+
+// InjectUnsubscribed delivers a message to the input handler regardless of subscriptions
+func (f *FakeMQTTClient) InjectUnsubscribed(topic, payload string) error {
+	f.mutex.RLock()
+	handler := f.handler
+	f.mutex.RUnlock()
+	if handler == nil {
+		return fmt.Errorf("input handler is not set")
+	}
+	handler(pepeunit.MQTTMessage{Topic: topic, Payload: []byte(payload)})
+	return nil
+}
+
+// DropConnection marks the client disconnected and calls the connection lost callback
+func (f *FakeMQTTClient) DropConnection(err error) {
+	f.mutex.Lock()
+	f.connected = false
+	onLost := f.onLost
+	f.mutex.Unlock()
+
+	if onLost != nil {
+		onLost(err)
+	}
+}
+
+// SetConnectError makes Connect fail with err, nil restores successful connects
+func (f *FakeMQTTClient) SetConnectError(err error) {
+	f.mutex.Lock()
+	f.connectErr = err
+	f.mutex.Unlock()
+}
+
+// SetPublishError makes Publish fail with err, nil restores successful publishes
+func (f *FakeMQTTClient) SetPublishError(err error) {
+	f.mutex.Lock()
+	f.publishErr = err
+	f.mutex.Unlock()
+}
+
+// IsConnected returns whether the client is connected
+func (f *FakeMQTTClient) IsConnected() bool {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.connected
+}
+
+// Published returns all recorded messages in publish order
+func (f *FakeMQTTClient) Published() []PublishedMessage {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return append([]PublishedMessage{}, f.published...)
+}
+
+// PublishedTo returns the payloads recorded for a topic in publish order
+func (f *FakeMQTTClient) PublishedTo(topic string) []string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	payloads := make([]string, 0)
+	for _, msg := range f.published {
+		if msg.Topic == topic {
+			payloads = append(payloads, msg.Payload)
+		}
+	}
+	return payloads
+}
+
+// Subscriptions returns the currently subscribed topics
+func (f *FakeMQTTClient) Subscriptions() []string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	topics := make([]string, 0, len(f.subscriptions))
+	for topic := range f.subscriptions {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// Reset clears recorded publishes
+func (f *FakeMQTTClient) Reset() {
+	f.mutex.Lock()
+	f.published = nil
+	f.mutex.Unlock()
+}
+
+// TopicMatches checks if a topic matches an MQTT subscription filter with + and # wildcards
+func TopicMatches(filter, topic string) bool {
+	filterParts := strings.Split(filter, "/")
+	topicParts := strings.Split(topic, "/")
+	for i, part := range filterParts {
+		if part == "#" {
+			return true
+		}
+		if i >= len(topicParts) {
+			return false
+		}
+		if part != "+" && part != topicParts[i] {
+			return false
+		}
+	}
+	return len(filterParts) == len(topicParts)
+}
@@ -0,0 +1,164 @@
+package pepeunittest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+)
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{filter: "a/b/c", topic: "a/b/c", want: true},
+		{filter: "a/b/c", topic: "a/b/d", want: false},
+		{filter: "a/b", topic: "a/b/c", want: false},
+		{filter: "a/b/c", topic: "a/b", want: false},
+		{filter: "a/+/c", topic: "a/b/c", want: true},
+		{filter: "a/+/c", topic: "a/b/x/c", want: false},
+		{filter: "+/+", topic: "a/b", want: true},
+		{filter: "a/#", topic: "a/b/c", want: true},
+		{filter: "a/#", topic: "a", want: true},
+		{filter: "#", topic: "a/b", want: true},
+		{filter: "a/+/#", topic: "a/b/c/d", want: true},
+		{filter: "b/#", topic: "a/b", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter+"|"+tt.topic, func(t *testing.T) {
+			if got := TopicMatches(tt.filter, tt.topic); got != tt.want {
+				t.Errorf("TopicMatches(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeMQTTClientInject(t *testing.T) {
+	tests := []struct {
+		name          string
+		subscribe     []string
+		unsubscribe   []string
+		noHandler     bool
+		topic         string
+		wantDelivered bool
+	}{
+		{name: "subscribed", subscribe: []string{"unit/input"}, topic: "unit/input", wantDelivered: true},
+		{name: "wildcard", subscribe: []string{"unit/+"}, topic: "unit/input", wantDelivered: true},
+		{name: "not subscribed", subscribe: []string{"unit/other"}, topic: "unit/input"},
+		{name: "unsubscribed", subscribe: []string{"unit/input"}, unsubscribe: []string{"unit/input"}, topic: "unit/input"},
+		{name: "no handler", subscribe: []string{"unit/input"}, noHandler: true, topic: "unit/input"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewFakeMQTTClient()
+			var received []pepeunit.MQTTMessage
+			if !tt.noHandler {
+				client.SetInputHandler(func(msg pepeunit.MQTTMessage) { received = append(received, msg) })
+			}
+			_ = client.SubscribeTopics(tt.subscribe)
+			_ = client.UnsubscribeTopics(tt.unsubscribe)
+
+			if got := client.Inject(tt.topic, "payload"); got != tt.wantDelivered {
+				t.Fatalf("Inject() = %v, want %v", got, tt.wantDelivered)
+			}
+			if tt.wantDelivered && (len(received) != 1 || received[0].Topic != tt.topic || string(received[0].Payload) != "payload") {
+				t.Errorf("received = %+v", received)
+			}
+			if !tt.wantDelivered && len(received) != 0 {
+				t.Errorf("received %d messages without delivery", len(received))
+			}
+		})
+	}
+}
+
+func TestFakeMQTTClientInjectUnsubscribed(t *testing.T) {
+	client := NewFakeMQTTClient()
+	if err := client.InjectUnsubscribed("unit/input", "payload"); err == nil {
+		t.Fatal("InjectUnsubscribed() without handler succeeded")
+	}
+	delivered := 0
+	client.SetInputHandler(func(msg pepeunit.MQTTMessage) { delivered++ })
+	if err := client.InjectUnsubscribed("unit/input", "payload"); err != nil || delivered != 1 {
+		t.Fatalf("InjectUnsubscribed() = %v, delivered %d", err, delivered)
+	}
+}
+
+func TestFakeMQTTClientPublish(t *testing.T) {
+	client := NewFakeMQTTClient()
+	var hooked []string
+	client.SetPublishHook(func(topic, payload string) { hooked = append(hooked, topic+"="+payload) })
+
+	for _, msg := range []PublishedMessage{{"a", "1"}, {"b", "2"}, {"a", "3"}} {
+		if err := client.Publish(msg.Topic, msg.Payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publishErr := errors.New("broker down")
+	client.SetPublishError(publishErr)
+	if err := client.Publish("a", "lost"); err != publishErr {
+		t.Fatalf("Publish() error = %v, want %v", err, publishErr)
+	}
+	client.SetPublishError(nil)
+
+	if got := strings.Join(client.PublishedTo("a"), ","); got != "1,3" {
+		t.Errorf("PublishedTo(a) = %s, want 1,3", got)
+	}
+	if got := len(client.PublishedTo("c")); got != 0 {
+		t.Errorf("PublishedTo(c) has %d payloads", got)
+	}
+	if got := strings.Join(hooked, ","); got != "a=1,b=2,a=3" {
+		t.Errorf("hook calls = %s", got)
+	}
+	if got := len(client.Published()); got != 3 {
+		t.Errorf("Published() has %d messages, want 3", got)
+	}
+	client.Reset()
+	if got := len(client.Published()); got != 0 {
+		t.Errorf("Published() after Reset has %d messages", got)
+	}
+}
+
+func TestFakeMQTTClientConnection(t *testing.T) {
+	client := NewFakeMQTTClient()
+	var events []string
+	client.SetConnectionHandlers(
+		func() { events = append(events, "connect") },
+		func(err error) { events = append(events, "lost: "+err.Error()) },
+	)
+
+	client.SetConnectError(errors.New("refused"))
+	if err := client.Connect(context.Background()); err == nil || client.IsConnected() {
+		t.Fatalf("Connect() = %v with connect error set", err)
+	}
+	client.SetConnectError(nil)
+	if err := client.Connect(context.Background()); err != nil || !client.IsConnected() {
+		t.Fatalf("Connect() = %v, connected %v", err, client.IsConnected())
+	}
+	client.DropConnection(errors.New("eof"))
+	if client.IsConnected() {
+		t.Error("connected after DropConnection")
+	}
+	_ = client.Connect(context.Background())
+	_ = client.Disconnect(context.Background())
+	if client.IsConnected() {
+		t.Error("connected after Disconnect")
+	}
+
+	if got := strings.Join(events, ","); got != "connect,lost: eof,connect" {
+		t.Errorf("events = %s", got)
+	}
+
+	_ = client.SubscribeTopics([]string{"b", "a"})
+	subscriptions := client.Subscriptions()
+	sort.Strings(subscriptions)
+	if got := strings.Join(subscriptions, ","); got != "a,b" {
+		t.Errorf("Subscriptions() = %s, want a,b", got)
+	}
+}
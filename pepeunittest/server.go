@@ -0,0 +1,307 @@
+package pepeunittest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AppPrefix is the application prefix served by FakeServer
+	AppPrefix = "/pepeunit"
+	// APIPrefix is the API prefix served by FakeServer
+	APIPrefix = "/api/v1"
+	// FilesPrefix is the path prefix of external files served by FakeServer
+	FilesPrefix = "/files/"
+)
+
+// RecordedRequest is a request received by FakeServer
+type RecordedRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   string
+}
+
+// FileFault breaks the next downloads of an external file
+type FileFault struct {
+	// After is the number of body bytes sent before the fault
+	After int64
+	// Stall keeps the connection open without sending data for this long before it is dropped
+	Stall time.Duration
+	// Times is the number of requests the fault applies to
+	Times int
+}
+
+// FakeServer is an httptest based fake of the Pepeunit REST API used by PepeunitRESTClient
+type FakeServer struct {
+	server    *httptest.Server
+	mutex     sync.RWMutex
+	token     string
+	unitUUID  string
+	env       map[string]interface{}
+	schema    map[string]interface{}
+	firmware  []byte
+	state     string
+	unitNodes map[string]interface{}
+	units     map[string]interface{}
+	files     map[string][]byte
+	faults    map[string]FileFault
+	requests  []RecordedRequest
+}
+
+// NewFakeServer starts a fake Pepeunit API accepting requests authorized with token for unitUUID
+func NewFakeServer(token, unitUUID string) *FakeServer {
+	f := &FakeServer{
+		token:     token,
+		unitUUID:  unitUUID,
+		env:       map[string]interface{}{},
+		schema:    map[string]interface{}{},
+		unitNodes: map[string]interface{}{"count": 0, "unit_nodes": []interface{}{}},
+		units:     map[string]interface{}{"count": 0, "units": []interface{}{}},
+		files:     make(map[string][]byte),
+		faults:    make(map[string]FileFault),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// Close shuts the server down
+func (f *FakeServer) Close() {
+	f.server.Close()
+}
+
+// URL returns the root URL of the server
+func (f *FakeServer) URL() string {
+	return f.server.URL
+}
+
+// Host returns host:port of the server, usable as PU_DOMAIN
+func (f *FakeServer) Host() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+// FileURL returns the URL of a file registered with SetFile
+func (f *FakeServer) FileURL(name string) string {
+	return f.server.URL + FilesPrefix + name
+}
+
+// SettingsValues returns env values pointing PepeunitRESTClient to this server
+func (f *FakeServer) SettingsValues() map[string]interface{} {
+	return map[string]interface{}{
+		"PU_HTTP_TYPE":         "http",
+		"PU_DOMAIN":            f.Host(),
+		"PU_APP_PREFIX":        AppPrefix,
+		"PU_API_ACTUAL_PREFIX": APIPrefix,
+		"PU_AUTH_TOKEN":        f.token,
+	}
+}
+
+// SetEnv sets the document served by /units/env
+func (f *FakeServer) SetEnv(env map[string]interface{}) {
+	f.mutex.Lock()
+	f.env = env
+	f.mutex.Unlock()
+}
+
+// SetSchema sets the document served by /units/get_current_schema
+func (f *FakeServer) SetSchema(schema map[string]interface{}) {
+	f.mutex.Lock()
+	f.schema = schema
+	f.mutex.Unlock()
+}
+
+// SetFirmware sets the archive served by /units/firmware/tgz
+func (f *FakeServer) SetFirmware(archive []byte) {
+	f.mutex.Lock()
+	f.firmware = archive
+	f.mutex.Unlock()
+}
+
+// SetState sets the value served by /units/get_state_storage
+func (f *FakeServer) SetState(state string) {
+	f.mutex.Lock()
+	f.state = state
+	f.mutex.Unlock()
+}
+
+// State returns the value stored through /units/set_state_storage
+func (f *FakeServer) State() string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.state
+}
+
+// SetUnitNodes sets the response of /unit_nodes
+func (f *FakeServer) SetUnitNodes(response map[string]interface{}) {
+	f.mutex.Lock()
+	f.unitNodes = response
+	f.mutex.Unlock()
+}
+
+// SetUnits sets the response of /units
+func (f *FakeServer) SetUnits(response map[string]interface{}) {
+	f.mutex.Lock()
+	f.units = response
+	f.mutex.Unlock()
+}
+
+// SetFile registers an external file served without authorization at FileURL(name), nil data removes it
+func (f *FakeServer) SetFile(name string, data []byte) {
+	f.mutex.Lock()
+	if data == nil {
+		delete(f.files, name)
+	} else {
+		f.files[name] = data
+	}
+	f.mutex.Unlock()
+}
+
+// SetFileFault makes the next fault.Times downloads of an external file break after fault.After bytes
+func (f *FakeServer) SetFileFault(name string, fault FileFault) {
+	f.mutex.Lock()
+	f.faults[name] = fault
+	f.mutex.Unlock()
+}
+
+// Requests returns all received requests in arrival order
+func (f *FakeServer) Requests() []RecordedRequest {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return append([]RecordedRequest{}, f.requests...)
+}
+
+// serveHTTP routes requests to the fake endpoints
+func (f *FakeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mutex.Lock()
+	f.requests = append(f.requests, RecordedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   string(body),
+	})
+	f.mutex.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, FilesPrefix) {
+		f.serveFile(w, r, strings.TrimPrefix(r.URL.Path, FilesPrefix))
+		return
+	}
+
+	base := AppPrefix + APIPrefix
+	if !strings.HasPrefix(r.URL.Path, base) {
+		http.NotFound(w, r)
+		return
+	}
+	if f.token != "" && r.Header.Get("x-auth-token") != f.token {
+		http.Error(w, `{"detail":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, base)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	switch {
+	case r.Method == http.MethodGet && path == "/units/env/"+f.unitUUID:
+		writeJSON(w, f.env)
+	case r.Method == http.MethodGet && path == "/units/get_current_schema/"+f.unitUUID:
+		writeJSON(w, f.schema)
+	case r.Method == http.MethodGet && path == "/units/firmware/tgz/"+f.unitUUID:
+		if f.firmware == nil {
+			http.NotFound(w, r)
+			return
+		}
+		serveContent(w, r, "firmware.tgz", f.firmware)
+	case r.Method == http.MethodPost && path == "/units/set_state_storage/"+f.unitUUID:
+		var payload map[string]interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		state, _ := payload["state"].(string)
+		f.state = state
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && path == "/units/get_state_storage/"+f.unitUUID:
+		_, _ = w.Write([]byte(f.state))
+	case r.Method == http.MethodGet && path == "/unit_nodes":
+		writeJSON(w, f.unitNodes)
+	case r.Method == http.MethodGet && path == "/units":
+		writeJSON(w, f.units)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveFile serves an external file registered with SetFile, supporting Range requests and file faults
+func (f *FakeServer) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	f.mutex.Lock()
+	data, ok := f.files[name]
+	fault, faulty := f.faults[name]
+	if faulty {
+		fault.Times--
+		if fault.Times <= 0 {
+			delete(f.faults, name)
+		} else {
+			f.faults[name] = fault
+		}
+	}
+	f.mutex.Unlock()
+	if !ok {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	if faulty {
+		w = &faultyWriter{ResponseWriter: w, request: r, fault: fault}
+	}
+	serveContent(w, r, name, data)
+}
+
+// serveContent serves data with an ETag, so resumed downloads can be checked with If-Range
+func serveContent(w http.ResponseWriter, r *http.Request, name string, data []byte) {
+	digest := sha256.Sum256(data)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, digest[:8]))
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+// faultyWriter sends fault.After body bytes, stalls and then drops the connection
+type faultyWriter struct {
+	http.ResponseWriter
+	request *http.Request
+	fault   FileFault
+	written int64
+}
+
+func (w *faultyWriter) Write(p []byte) (int, error) {
+	if w.written+int64(len(p)) <= w.fault.After {
+		n, err := w.ResponseWriter.Write(p)
+		w.written += int64(n)
+		return n, err
+	}
+	n, _ := w.ResponseWriter.Write(p[:w.fault.After-w.written])
+	w.written += int64(n)
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	select {
+	case <-time.After(w.fault.Stall):
+	case <-w.request.Context().Done():
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(data)
+}
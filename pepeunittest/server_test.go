@@ -0,0 +1,222 @@
+package pepeunittest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+)
+
+// newRESTClient returns a REST client of the server unit, token overrides the auth token when set
+func newRESTClient(t *testing.T, server *FakeServer, token string) *pepeunit.PepeunitRESTClient {
+	t.Helper()
+	values := server.SettingsValues()
+	if token != "" {
+		values["PU_AUTH_TOKEN"] = token
+	}
+	client := pepeunit.NewPepeunitRESTClient(pepeunit.NewSettingsWith("", values))
+	client.SetDownloadRetries(0, 0)
+	return client
+}
+
+func readJSONFile(t *testing.T, path string) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatal(err)
+	}
+	return document
+}
+
+func TestFakeServerDownloads(t *testing.T) {
+	unitUUID := NewUUID()
+	token := NewJWT(unitUUID)
+	firmware, err := BuildTarGz(map[string]string{"main": "binary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		setup    func(server *FakeServer)
+		token    string
+		download func(ctx context.Context, client *pepeunit.PepeunitRESTClient, server *FakeServer, path string) error
+		check    func(t *testing.T, path string)
+		wantErr  string
+	}{
+		{
+			name:  "env",
+			setup: func(server *FakeServer) { server.SetEnv(map[string]interface{}{"PU_DOMAIN": "example.test"}) },
+			download: func(ctx context.Context, client *pepeunit.PepeunitRESTClient, server *FakeServer, path string) error {
+				return client.DownloadEnv(ctx, path)
+			},
+			check: func(t *testing.T, path string) {
+				if got := readJSONFile(t, path)["PU_DOMAIN"]; got != "example.test" {
+					t.Errorf("PU_DOMAIN = %v", got)
+				}
+			},
+		},
+		{
+			name:  "schema",
+			setup: func(server *FakeServer) { server.SetSchema(NewSchema(unitUUID, nil, []string{"output/pepeunit"})) },
+			download: func(ctx context.Context, client *pepeunit.PepeunitRESTClient, server *FakeServer, path string) error {
+				return client.DownloadSchema(ctx, path)
+			},
+			check: func(t *testing.T, path string) {
+				schema := readJSONFile(t, path)
+				if _, ok := schema[string(pepeunit.DestinationTopicTypeOutputTopic)].(map[string]interface{})["output/pepeunit"]; !ok {
+					t.Errorf("schema without output/pepeunit: %v", schema)
+				}
+			},
+		},
+		{
+			name:  "firmware",
+			setup: func(server *FakeServer) { server.SetFirmware(firmware) },
+			download: func(ctx context.Context, client *pepeunit.PepeunitRESTClient, server *FakeServer, path string) error {
+				return client.DownloadUpdate(ctx, path)
+			},
+			check: func(t *testing.T, path string) {
+				if data, _ := os.ReadFile(path); string(data) != string(firmware) {
+					t.Errorf("firmware differs from the served archive")
+				}
+			},
+		},
+		{
+			name: "firmware missing",
+			download: func(ctx context.Context, client *pepeunit.PepeunitRESTClient, server *FakeServer, path string) error {
+				return client.DownloadUpdate(ctx, path)
+			},
+			wantErr: "404",
+		},
+		{
+			name:  "external file",
+			setup: func(server *FakeServer) { server.SetFile("fw.bin", []byte("external")) },
+			token: "not-the-unit-token",
+			download: func(ctx context.Context, client *pepeunit.PepeunitRESTClient, server *FakeServer, path string) error {
+				return client.DownloadFileFromURL(ctx, server.FileURL("fw.bin"), path)
+			},
+			check: func(t *testing.T, path string) {
+				if data, _ := os.ReadFile(path); string(data) != "external" {
+					t.Errorf("file = %q", data)
+				}
+			},
+		},
+		{
+			// A part file without download state can not be validated and is downloaded again
+			name:  "external file with stale part",
+			setup: func(server *FakeServer) { server.SetFile("fw.bin", []byte("resumed download")) },
+			download: func(ctx context.Context, client *pepeunit.PepeunitRESTClient, server *FakeServer, path string) error {
+				if err := os.WriteFile(path+".part", []byte("stale!!"), 0644); err != nil {
+					return err
+				}
+				return client.DownloadFileFromURL(ctx, server.FileURL("fw.bin"), path)
+			},
+			check: func(t *testing.T, path string) {
+				if data, _ := os.ReadFile(path); string(data) != "resumed download" {
+					t.Errorf("file = %q", data)
+				}
+			},
+		},
+		{
+			name: "external file missing",
+			download: func(ctx context.Context, client *pepeunit.PepeunitRESTClient, server *FakeServer, path string) error {
+				return client.DownloadFileFromURL(ctx, server.FileURL("missing.bin"), path)
+			},
+			wantErr: "404",
+		},
+		{
+			name:  "wrong signature",
+			token: token + "x",
+			download: func(ctx context.Context, client *pepeunit.PepeunitRESTClient, server *FakeServer, path string) error {
+				return client.DownloadEnv(ctx, path)
+			},
+			wantErr: "401",
+		},
+		{
+			name:  "other unit",
+			token: NewJWT(NewUUID()),
+			download: func(ctx context.Context, client *pepeunit.PepeunitRESTClient, server *FakeServer, path string) error {
+				return client.DownloadSchema(ctx, path)
+			},
+			wantErr: "401",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewFakeServer(token, unitUUID)
+			defer server.Close()
+			if tt.setup != nil {
+				tt.setup(server)
+			}
+			path := filepath.Join(t.TempDir(), "download")
+
+			err := tt.download(context.Background(), newRESTClient(t, server, tt.token), server, path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("download error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("download error = %v", err)
+			}
+			tt.check(t, path)
+		})
+	}
+}
+
+func TestFakeServerState(t *testing.T) {
+	unitUUID := NewUUID()
+	server := NewFakeServer(NewJWT(unitUUID), unitUUID)
+	defer server.Close()
+	client := newRESTClient(t, server, "")
+	ctx := context.Background()
+
+	server.SetState("initial")
+	if state, err := client.GetStateStorage(ctx); err != nil || state != "initial" {
+		t.Fatalf("GetStateStorage() = %q, %v", state, err)
+	}
+	if err := client.SetStateStorage(ctx, `{"counter":1}`); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.State(); got != `{"counter":1}` {
+		t.Errorf("State() = %q", got)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 2 || requests[1].Method != "POST" || !strings.HasSuffix(requests[1].Path, "/units/set_state_storage/"+unitUUID) {
+		t.Errorf("requests = %+v", requests)
+	}
+}
+
+func TestFakeServerUnits(t *testing.T) {
+	unitUUID := NewUUID()
+	server := NewFakeServer(NewJWT(unitUUID), unitUUID)
+	defer server.Close()
+	client := newRESTClient(t, server, "")
+	ctx := context.Background()
+
+	server.SetUnits(map[string]interface{}{"count": 1, "units": []interface{}{map[string]interface{}{"uuid": unitUUID}}})
+	units, err := client.GetUnitsByNodes(ctx, []string{NewUUID()}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if units["count"] != float64(1) {
+		t.Errorf("units = %v", units)
+	}
+
+	requests := server.Requests()
+	last := requests[len(requests)-1]
+	if last.Path != AppPrefix+APIPrefix+"/units" || last.Query.Get("limit") != "10" {
+		t.Errorf("request = %+v", last)
+	}
+}
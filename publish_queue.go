@@ -0,0 +1,407 @@
+package pepeunit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// publishQueueCompactEvery is the number of replayed messages after which the queue file is rewritten
+const publishQueueCompactEvery = 64
+
+// PublishQueueConfig configures the disk-backed queue of messages published while the broker is unreachable.
+// TopicDropPolicy is keyed by full topic or by output topic key, like output/pepeunit or log/pepeunit.
+type PublishQueueConfig struct {
+	FilePath        string
+	MaxBytes        int64
+	MaxAge          time.Duration
+	DropPolicy      OverflowPolicy
+	TopicDropPolicy map[string]OverflowPolicy
+}
+
+// PublishQueueStats holds counters of the publish queue
+type PublishQueueStats struct {
+	Depth    int
+	Bytes    int64
+	Capacity int64
+	Replayed uint64
+	Dropped  uint64
+	Expired  uint64
+}
+
+// publishQueueEntry is a message captured while the broker was unreachable
+type publishQueueEntry struct {
+	Topic    string    `json:"topic"`
+	Payload  string    `json:"payload"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// size returns the bytes an entry counts against MaxBytes
+func (e publishQueueEntry) size() int64 {
+	return int64(len(e.Topic) + len(e.Payload))
+}
+
+// publishQueue keeps messages in publish order in memory and in a JSON lines file.
+// New messages are appended to the file, it is rewritten when messages are dropped or replayed.
+type publishQueue struct {
+	config    PublishQueueConfig
+	mutex     sync.Mutex
+	entries   []publishQueueEntry
+	bytes     int64
+	replaying bool
+	unsaved   int
+	replayed  uint64
+	dropped   uint64
+	expired   uint64
+}
+
+// newPublishQueue creates a queue and restores the messages left in its file by a previous run
+func newPublishQueue(config PublishQueueConfig) (*publishQueue, error) {
+	if config.FilePath == "" {
+		return nil, fmt.Errorf("publish queue file path is required")
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DefaultPublishQueueMaxBytes
+	}
+	if config.MaxAge == 0 {
+		config.MaxAge = DefaultPublishQueueMaxAge
+	}
+	if config.DropPolicy == "" {
+		config.DropPolicy = OverflowPolicyDropOldest
+	}
+	if err := checkQueueDropPolicy(config.DropPolicy); err != nil {
+		return nil, err
+	}
+	for topic, policy := range config.TopicDropPolicy {
+		if err := checkQueueDropPolicy(policy); err != nil {
+			return nil, fmt.Errorf("topic %s: %v", topic, err)
+		}
+	}
+
+	q := &publishQueue{config: config}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// checkQueueDropPolicy rejects policies a publish queue can not apply, a full queue can not wait for an absent broker
+func checkQueueDropPolicy(policy OverflowPolicy) error {
+	switch policy {
+	case OverflowPolicyDrop, OverflowPolicyDropOldest:
+		return nil
+	}
+	return fmt.Errorf("unsupported publish queue drop policy %q, use %q or %q", policy, OverflowPolicyDrop, OverflowPolicyDropOldest)
+}
+
+// load reads the queue file skipping invalid lines and messages over the age or size limit
+func (q *publishQueue) load() error {
+	data, err := os.ReadFile(q.config.FilePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read publish queue: %v", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		var entry publishQueueEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Topic == "" {
+			continue
+		}
+		q.entries = append(q.entries, entry)
+		q.bytes += entry.size()
+	}
+	q.expire(time.Now())
+	for q.bytes > q.config.MaxBytes {
+		q.removeFirst()
+		q.dropped++
+	}
+	return q.save()
+}
+
+// push appends a message, making room according to the drop policy when the queue is full
+func (q *publishQueue) push(topic, payload string, policy OverflowPolicy) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	entry := publishQueueEntry{Topic: topic, Payload: payload, QueuedAt: time.Now().UTC()}
+	if entry.size() > q.config.MaxBytes {
+		q.dropped++
+		return fmt.Errorf("message to %s is larger than the publish queue", topic)
+	}
+
+	removed := q.expire(time.Now())
+	if q.bytes+entry.size() > q.config.MaxBytes {
+		if policy != OverflowPolicyDropOldest {
+			q.dropped++
+			if removed > 0 {
+				_ = q.save()
+			}
+			return fmt.Errorf("publish queue is full, message to %s dropped", topic)
+		}
+		for q.bytes+entry.size() > q.config.MaxBytes {
+			q.removeFirst()
+			q.dropped++
+			removed++
+		}
+	}
+
+	q.entries = append(q.entries, entry)
+	q.bytes += entry.size()
+	if removed > 0 {
+		return q.save()
+	}
+	return q.append(entry)
+}
+
+// expire removes messages older than MaxAge from the head of the queue, a negative MaxAge keeps them forever
+func (q *publishQueue) expire(now time.Time) int {
+	if q.config.MaxAge < 0 {
+		return 0
+	}
+	removed := 0
+	for len(q.entries) > 0 && now.Sub(q.entries[0].QueuedAt) > q.config.MaxAge {
+		q.removeFirst()
+		q.expired++
+		removed++
+	}
+	return removed
+}
+
+// removeFirst removes the oldest message
+func (q *publishQueue) removeFirst() {
+	q.bytes -= q.entries[0].size()
+	q.entries[0] = publishQueueEntry{}
+	q.entries = q.entries[1:]
+}
+
+// startReplay reports whether the caller should replay the queue, only one replay runs at a time
+func (q *publishQueue) startReplay() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.replaying || len(q.entries) == 0 {
+		return false
+	}
+	q.replaying = true
+	return true
+}
+
+// stopReplay ends a replay interrupted by a failed publish
+func (q *publishQueue) stopReplay() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.replaying = false
+	q.saveReplayed()
+}
+
+// next returns the oldest message, or ends the replay when the queue is empty
+func (q *publishQueue) next() (publishQueueEntry, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.expire(time.Now()) > 0 {
+		q.unsaved++
+	}
+	if len(q.entries) == 0 {
+		q.replaying = false
+		q.saveReplayed()
+		return publishQueueEntry{}, false
+	}
+	return q.entries[0], true
+}
+
+// done removes the message returned by next after it was published
+func (q *publishQueue) done() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.entries) == 0 {
+		return
+	}
+	q.removeFirst()
+	q.replayed++
+	q.unsaved++
+	if q.unsaved >= publishQueueCompactEvery {
+		q.saveReplayed()
+	}
+}
+
+// saveReplayed rewrites the file once replayed messages were removed from memory
+func (q *publishQueue) saveReplayed() {
+	if q.unsaved == 0 {
+		return
+	}
+	q.unsaved = 0
+	_ = q.save()
+}
+
+// isEmpty reports whether no message waits for replay
+func (q *publishQueue) isEmpty() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.entries) == 0
+}
+
+// stats returns the current queue counters
+func (q *publishQueue) stats() PublishQueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return PublishQueueStats{
+		Depth:    len(q.entries),
+		Bytes:    q.bytes,
+		Capacity: q.config.MaxBytes,
+		Replayed: q.replayed,
+		Dropped:  q.dropped,
+		Expired:  q.expired,
+	}
+}
+
+// append adds a message to the end of the queue file
+func (q *publishQueue) append(entry publishQueueEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(q.config.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open publish queue: %v", err)
+	}
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write publish queue: %v", err)
+	}
+	return nil
+}
+
+// save rewrites the queue file with the messages in memory, an empty queue removes the file
+func (q *publishQueue) save() error {
+	if len(q.entries) == 0 {
+		if err := os.Remove(q.config.FilePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove publish queue: %v", err)
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range q.entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(q.config.FilePath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write publish queue: %v", err)
+	}
+	return nil
+}
+
+// SetPublishQueue enables the disk-backed queue: messages published while the broker is unreachable
+// are stored in cfg.FilePath and replayed in order after the next connect, also after a restart
+func (c *PepeunitMQTTClient) SetPublishQueue(cfg PublishQueueConfig) error {
+	queue, err := newPublishQueue(cfg)
+	if err != nil {
+		return err
+	}
+	c.connectMu.Lock()
+	c.publishQueue = queue
+	c.connectMu.Unlock()
+	if c.IsConnected() {
+		go c.replayPublishQueue()
+	}
+	return nil
+}
+
+// currentPublishQueue returns the publish queue set by SetPublishQueue, nil when it is not enabled
+func (c *PepeunitMQTTClient) currentPublishQueue() *publishQueue {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	return c.publishQueue
+}
+
+// GetPublishQueueStats returns depth, size and drop counters of the publish queue.
+// The second value is false when the publish queue is not enabled.
+func (c *PepeunitMQTTClient) GetPublishQueueStats() (PublishQueueStats, bool) {
+	queue := c.currentPublishQueue()
+	if queue == nil {
+		return PublishQueueStats{}, false
+	}
+	return queue.stats(), true
+}
+
+// publishQueued publishes directly while connected with nothing queued, otherwise the message is queued.
+// Delivery is at least once: a direct publish that timed out is queued although paho may still deliver it,
+// so the broker can receive it twice rather than lose it when the connection drops.
+func (c *PepeunitMQTTClient) publishQueued(queue *publishQueue, topic, message string) error {
+	if c.IsConnected() && queue.isEmpty() {
+		token := c.client.Publish(topic, 1, false, message)
+		if token.WaitTimeout(5*time.Second) && token.Error() == nil {
+			return nil
+		}
+	}
+
+	if err := queue.push(topic, message, c.dropPolicy(queue, topic)); err != nil {
+		c.Logger.Warning(fmt.Sprintf("Publish queue: %v", err), true)
+		return err
+	}
+	if c.IsConnected() {
+		go c.replayPublishQueue()
+	}
+	return nil
+}
+
+// dropPolicy returns the drop policy of a topic, looked up by full topic and then by output topic key
+func (c *PepeunitMQTTClient) dropPolicy(queue *publishQueue, topic string) OverflowPolicy {
+	config := queue.config
+	if policy, ok := config.TopicDropPolicy[topic]; ok {
+		return policy
+	}
+	if len(config.TopicDropPolicy) > 0 && c.SchemaManager != nil {
+		for _, section := range []map[string][]string{c.SchemaManager.GetOutputTopic(), c.SchemaManager.GetOutputBaseTopic()} {
+			for key, topics := range section {
+				policy, ok := config.TopicDropPolicy[key]
+				if !ok {
+					continue
+				}
+				for _, candidate := range topics {
+					if candidate == topic {
+						return policy
+					}
+				}
+			}
+		}
+	}
+	return config.DropPolicy
+}
+
+// replayPublishQueue publishes the queued messages in order until the queue is empty or a publish fails
+func (c *PepeunitMQTTClient) replayPublishQueue() {
+	queue := c.currentPublishQueue()
+	if queue == nil || !queue.startReplay() {
+		return
+	}
+	for {
+		entry, ok := queue.next()
+		if !ok {
+			return
+		}
+		client := c.client
+		if !c.IsConnected() {
+			queue.stopReplay()
+			return
+		}
+		token := client.Publish(entry.Topic, 1, false, entry.Payload)
+		if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			c.Logger.Warning(fmt.Sprintf("Publish queue replay interrupted at message to %s", entry.Topic), true)
+			queue.stopReplay()
+			return
+		}
+		queue.done()
+	}
+}
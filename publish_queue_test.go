@@ -0,0 +1,272 @@
+package pepeunit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewPublishQueueDropPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      OverflowPolicy
+		topicPolicy map[string]OverflowPolicy
+		wantErr     string
+	}{
+		{name: "default", policy: ""},
+		{name: "drop", policy: OverflowPolicyDrop},
+		{name: "drop oldest", policy: OverflowPolicyDropOldest},
+		{name: "block", policy: OverflowPolicyBlock, wantErr: "unsupported publish queue drop policy"},
+		{name: "unknown", policy: "keep", wantErr: "unsupported publish queue drop policy"},
+		{name: "topic drop", topicPolicy: map[string]OverflowPolicy{"log/pepeunit": OverflowPolicyDrop}},
+		{name: "topic block", topicPolicy: map[string]OverflowPolicy{"log/pepeunit": OverflowPolicyBlock}, wantErr: "topic log/pepeunit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := newPublishQueue(PublishQueueConfig{
+				FilePath:        filepath.Join(t.TempDir(), "queue.jsonl"),
+				DropPolicy:      tt.policy,
+				TopicDropPolicy: tt.topicPolicy,
+			})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("newPublishQueue() error = %v", err)
+				}
+				if q.config.MaxBytes != DefaultPublishQueueMaxBytes || q.config.MaxAge != DefaultPublishQueueMaxAge {
+					t.Errorf("defaults not applied: %+v", q.config)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("newPublishQueue() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := newPublishQueue(PublishQueueConfig{}); err == nil {
+		t.Error("newPublishQueue() without file path succeeded")
+	}
+}
+
+func TestPublishQueuePush(t *testing.T) {
+	// Every message of these cases is 10 bytes: a 1 byte topic and a 9 byte payload
+	tests := []struct {
+		name        string
+		maxBytes    int64
+		policy      OverflowPolicy
+		payloads    []string
+		wantErrs    int
+		wantQueued  []string
+		wantDropped uint64
+	}{
+		{
+			name:       "fits",
+			maxBytes:   30,
+			policy:     OverflowPolicyDrop,
+			payloads:   []string{"payload-1", "payload-2", "payload-3"},
+			wantQueued: []string{"payload-1", "payload-2", "payload-3"},
+		},
+		{
+			name:        "drop newest",
+			maxBytes:    20,
+			policy:      OverflowPolicyDrop,
+			payloads:    []string{"payload-1", "payload-2", "payload-3"},
+			wantErrs:    1,
+			wantQueued:  []string{"payload-1", "payload-2"},
+			wantDropped: 1,
+		},
+		{
+			name:        "drop oldest",
+			maxBytes:    20,
+			policy:      OverflowPolicyDropOldest,
+			payloads:    []string{"payload-1", "payload-2", "payload-3"},
+			wantQueued:  []string{"payload-2", "payload-3"},
+			wantDropped: 1,
+		},
+		{
+			name:        "larger than queue",
+			maxBytes:    5,
+			policy:      OverflowPolicyDropOldest,
+			payloads:    []string{"payload-1"},
+			wantErrs:    1,
+			wantDropped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "queue.jsonl")
+			q, err := newPublishQueue(PublishQueueConfig{FilePath: path, MaxBytes: tt.maxBytes})
+			if err != nil {
+				t.Fatalf("newPublishQueue() error = %v", err)
+			}
+			errs := 0
+			for _, payload := range tt.payloads {
+				if err := q.push("t", payload, tt.policy); err != nil {
+					errs++
+				}
+			}
+			if errs != tt.wantErrs {
+				t.Errorf("push errors = %d, want %d", errs, tt.wantErrs)
+			}
+			if got := queuedPayloads(q.entries); strings.Join(got, ",") != strings.Join(tt.wantQueued, ",") {
+				t.Errorf("queued = %v, want %v", got, tt.wantQueued)
+			}
+			if stats := q.stats(); stats.Dropped != tt.wantDropped || stats.Depth != len(tt.wantQueued) {
+				t.Errorf("stats = %+v, want %d dropped, %d queued", stats, tt.wantDropped, len(tt.wantQueued))
+			}
+
+			// The file holds the same messages a restart restores
+			reloaded, err := newPublishQueue(PublishQueueConfig{FilePath: path, MaxBytes: tt.maxBytes})
+			if err != nil {
+				t.Fatalf("reload error = %v", err)
+			}
+			if got := queuedPayloads(reloaded.entries); strings.Join(got, ",") != strings.Join(tt.wantQueued, ",") {
+				t.Errorf("reloaded = %v, want %v", got, tt.wantQueued)
+			}
+		})
+	}
+}
+
+func TestPublishQueueLoad(t *testing.T) {
+	now := time.Now().UTC()
+	line := func(topic, payload string, age time.Duration) string {
+		data, _ := json.Marshal(publishQueueEntry{Topic: topic, Payload: payload, QueuedAt: now.Add(-age)})
+		return string(data)
+	}
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	lines := []string{
+		line("t", "old", 2*time.Hour),
+		"not json",
+		line("", "no topic", 0),
+		line("t", "one", 0),
+		line("t", "two", 0),
+		line("t", "six", 0),
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid lines are skipped, the old message expires and the oldest valid one is dropped to fit 8 bytes
+	q, err := newPublishQueue(PublishQueueConfig{FilePath: path, MaxBytes: 8, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("newPublishQueue() error = %v", err)
+	}
+	if got := strings.Join(queuedPayloads(q.entries), ","); got != "two,six" {
+		t.Errorf("queued = %s, want two,six", got)
+	}
+	if stats := q.stats(); stats.Expired != 1 || stats.Dropped != 1 {
+		t.Errorf("stats = %+v, want 1 expired and 1 dropped", stats)
+	}
+
+	// A negative MaxAge keeps even old messages
+	if err := os.WriteFile(path, []byte(line("t", "old", 2*time.Hour)+"\n"+line("t", "new", 0)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q, err = newPublishQueue(PublishQueueConfig{FilePath: path, MaxAge: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(queuedPayloads(q.entries), ","); got != "old,new" {
+		t.Errorf("queued without max age = %s, want old,new", got)
+	}
+}
+
+func TestPublishQueueReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q, err := newPublishQueue(PublishQueueConfig{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"one", "two", "three"} {
+		if err := q.push("t", payload, OverflowPolicyDropOldest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !q.startReplay() {
+		t.Fatal("startReplay() = false with queued messages")
+	}
+	if q.startReplay() {
+		t.Fatal("second startReplay() = true while replaying")
+	}
+
+	// An interrupted replay keeps the unpublished messages on disk
+	entry, ok := q.next()
+	if !ok || entry.Payload != "one" {
+		t.Fatalf("next() = %+v, %v, want one", entry, ok)
+	}
+	q.done()
+	q.stopReplay()
+	reloaded, err := newPublishQueue(PublishQueueConfig{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(queuedPayloads(reloaded.entries), ","); got != "two,three" {
+		t.Fatalf("after interrupted replay = %s, want two,three", got)
+	}
+
+	var replayed []string
+	if !q.startReplay() {
+		t.Fatal("startReplay() = false after stopReplay")
+	}
+	for {
+		entry, ok := q.next()
+		if !ok {
+			break
+		}
+		replayed = append(replayed, entry.Payload)
+		q.done()
+	}
+	if got := strings.Join(replayed, ","); got != "two,three" {
+		t.Errorf("replayed = %s, want two,three", got)
+	}
+	if !q.isEmpty() || q.stats().Replayed != 3 {
+		t.Errorf("stats = %+v, want empty queue with 3 replayed", q.stats())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("queue file left after full replay: %v", err)
+	}
+}
+
+func TestPublishQueueSwapWhilePublishing(t *testing.T) {
+	settings := NewSettingsWith("", map[string]interface{}{"PU_MQTT_HOST": "127.0.0.1"})
+	client := NewPepeunitMQTTClient(settings, nil, NewLogger("", nil, nil, settings, false))
+	dir := t.TempDir()
+
+	// Enabling a queue races with publishes and stats readers, run with -race to catch unguarded reads
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			if err := client.SetPublishQueue(PublishQueueConfig{FilePath: filepath.Join(dir, fmt.Sprintf("queue-%d.jsonl", i))}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		client.GetPublishQueueStats()
+	}
+	<-done
+
+	if err := client.Publish("t", "offline"); err != nil {
+		t.Fatalf("Publish() = %v, want queued", err)
+	}
+	if stats, ok := client.GetPublishQueueStats(); !ok || stats.Depth != 1 {
+		t.Errorf("stats = %+v, %v, want the message queued", stats, ok)
+	}
+}
+
+func queuedPayloads(entries []publishQueueEntry) []string {
+	payloads := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		payloads = append(payloads, entry.Payload)
+	}
+	return payloads
+}
@@ -1,7 +1,6 @@
 package pepeunit
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -18,14 +17,23 @@ import (
 // PepeunitRESTClient implements RESTClient interface
 type PepeunitRESTClient struct {
 	*AbstractRESTClient
-	httpClient *http.Client
+	httpClient          *http.Client
+	downloadClient      *http.Client
+	downloadRetries     int
+	downloadBackoff     time.Duration
+	downloadIdleTimeout time.Duration
+	downloadProgress    func(DownloadProgress)
 }
 
 // NewPepeunitRESTClient creates a new REST client
 func NewPepeunitRESTClient(settings *Settings) *PepeunitRESTClient {
 	return &PepeunitRESTClient{
-		AbstractRESTClient: NewAbstractRESTClient(settings),
-		httpClient:         newDefaultHTTPClient(30 * time.Second),
+		AbstractRESTClient:  NewAbstractRESTClient(settings),
+		httpClient:          newDefaultHTTPClient(30 * time.Second),
+		downloadClient:      newDownloadHTTPClient(),
+		downloadRetries:     DefaultDownloadRetries,
+		downloadBackoff:     DefaultDownloadRetryBackoff,
+		downloadIdleTimeout: DefaultDownloadIdleTimeout,
 	}
 }
 
@@ -49,6 +57,23 @@ func newDefaultHTTPClient(timeout time.Duration) *http.Client {
 	}
 }
 
+// newDownloadHTTPClient returns the client of firmware and file downloads. It has no total timeout,
+// so large files are not cut off; stalled downloads are ended by the idle timeout of downloadAttempt.
+func newDownloadHTTPClient() *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: DefaultDownloadIdleTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableKeepAlives:     true,
+	}
+	return &http.Client{Transport: transport}
+}
+
 // DownloadUpdate downloads firmware update archive
 func (c *PepeunitRESTClient) DownloadUpdate(ctx context.Context, filePath string) error {
 	uuid, err := c.Settings.UnitUUID()
@@ -57,43 +82,10 @@ func (c *PepeunitRESTClient) DownloadUpdate(ctx context.Context, filePath string
 	}
 	url := c.GetBaseURL() + "/units/firmware/tgz/" + uuid + "?wbits=9&level=9"
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
-	}
-
 	headers := c.GetAuthHeaders()
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
-	req.Header.Set("Accept", "application/octet-stream")
-	req.Header.Set("Accept-Encoding", "identity")
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	reader := bufio.NewReader(resp.Body)
-
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to create file %s: %v", filePath, err)
-	}
-	defer file.Close()
-
-	_, err = io.Copy(file, reader)
-	if err != nil {
-		return fmt.Errorf("failed to write file %s: %v", filePath, err)
-	}
-
-	return nil
+	headers["Accept"] = "application/octet-stream"
+	headers["Accept-Encoding"] = "identity"
+	return c.downloadResumable(ctx, url, filePath, headers)
 }
 
 // DownloadEnv downloads environment configuration
@@ -118,34 +110,7 @@ func (c *PepeunitRESTClient) DownloadSchema(ctx context.Context, filePath string
 
 // DownloadFileFromURL downloads a file from an external URL
 func (c *PepeunitRESTClient) DownloadFileFromURL(ctx context.Context, url, filePath string) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
-	}
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("file download failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to create file %s: %v", filePath, err)
-	}
-	defer file.Close()
-
-	_, err = io.Copy(file, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file %s: %v", filePath, err)
-	}
-
-	return nil
+	return c.downloadResumable(ctx, url, filePath, nil)
 }
 
 // SetStateStorage stores state data in PepeUnit storage
@@ -339,42 +304,7 @@ func (c *PepeunitRESTClient) GetUnitsByNodes(ctx context.Context, unitNodeUUIDs
 
 // downloadFile downloads a file from the given URL to the specified file path
 func (c *PepeunitRESTClient) downloadFile(ctx context.Context, url, filePath string) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
-	}
-
-	// Set headers
-	headers := c.GetAuthHeaders()
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	// Create the destination file
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to create file %s: %v", filePath, err)
-	}
-	defer file.Close()
-
-	// Copy the response body to the file
-	_, err = io.Copy(file, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file %s: %v", filePath, err)
-	}
-
-	return nil
+	return c.downloadResumable(ctx, url, filePath, c.GetAuthHeaders())
 }
 
 // downloadJSONFile downloads JSON data from the given URL and writes it to the specified file path
@@ -437,9 +367,10 @@ func (c *PepeunitRESTClient) downloadJSONFile(ctx context.Context, url, filePath
 	return nil
 }
 
-// SetHTTPClient sets a custom HTTP client
+// SetHTTPClient sets a custom HTTP client for API requests and downloads
 func (c *PepeunitRESTClient) SetHTTPClient(httpClient *http.Client) {
 	c.httpClient = httpClient
+	c.downloadClient = httpClient
 }
 
 // GetHTTPClient returns the current HTTP client
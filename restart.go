@@ -0,0 +1,108 @@
+package pepeunit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// restartFlushTimeout bounds how long pending log messages are flushed before a restart
+const restartFlushTimeout = 5 * time.Second
+
+// Restarter restarts the process after an update, on success Restart does not return
+type Restarter interface {
+	Restart(ctx context.Context) error
+}
+
+// RestarterFunc adapts a function to the Restarter interface
+type RestarterFunc func(ctx context.Context) error
+
+// Restart calls f
+func (f RestarterFunc) Restart(ctx context.Context) error {
+	return f(ctx)
+}
+
+// ExecRestarter replaces the current process with the executable using syscall.Exec
+func ExecRestarter() Restarter {
+	return RestarterFunc(func(ctx context.Context) error {
+		executable, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to get executable path: %v", err)
+		}
+		if err := syscall.Exec(executable, os.Args, os.Environ()); err != nil {
+			return fmt.Errorf("failed to exec new process: %v", err)
+		}
+		return nil
+	})
+}
+
+// SpawnRestarter starts the executable as a new process in its own process group and exits the current one
+func SpawnRestarter() Restarter {
+	return RestarterFunc(func(ctx context.Context) error {
+		executable, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to get executable path: %v", err)
+		}
+		cmd := exec.Command(executable, os.Args[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Stdin = os.Stdin
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to start new process: %v", err)
+		}
+		os.Exit(0)
+		return nil
+	})
+}
+
+// ExitCodeRestarter exits with code and leaves the restart to a supervisor such as systemd, Docker or runit
+func ExitCodeRestarter(code int) Restarter {
+	return RestarterFunc(func(ctx context.Context) error {
+		os.Exit(code)
+		return nil
+	})
+}
+
+// restarterForMode returns the built-in restarter of a restart mode, nil for modes without restart
+func restarterForMode(mode RestartMode) Restarter {
+	switch mode {
+	case RestartModeRestartPopen:
+		return SpawnRestarter()
+	case RestartModeRestartExec:
+		return ExecRestarter()
+	case RestartModeRestartExitCode:
+		return ExitCodeRestarter(DefaultRestartExitCode)
+	}
+	return nil
+}
+
+// restartsProcess reports whether the restart mode restarts the process after an update
+func (c *PepeunitClient) restartsProcess() bool {
+	return c.restartMode != RestartModeEnvSchemaOnly && c.restartMode != RestartModeNoRestart
+}
+
+// restart is the single restart path of all update flows: pre-restart hook, BeforeRestart subscribers,
+// main cycle stop, log flush and the configured Restarter
+func (c *PepeunitClient) restart(ctx context.Context) error {
+	if c.restarter == nil {
+		return fmt.Errorf("no restarter for restart mode %s", c.restartMode)
+	}
+	if c.preRestartHook != nil {
+		if err := c.preRestartHook(ctx); err != nil {
+			return fmt.Errorf("restart aborted by pre-restart hook: %v", err)
+		}
+	}
+	c.events.emitBeforeRestart(c.restartMode)
+	c.StopMainCycle()
+	c.logger.Info("I`ll Be Back - restarting process")
+
+	flushCtx, cancel := context.WithTimeout(ctx, restartFlushTimeout)
+	_ = c.logger.Flush(flushCtx)
+	cancel()
+
+	return c.restarter.Restart(ctx)
+}
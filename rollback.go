@@ -0,0 +1,225 @@
+package pepeunit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// pendingUpdate is the marker written before restarting into a new binary
+type pendingUpdate struct {
+	Executable   string `json:"executable"`
+	Backup       string `json:"backup"`
+	Version      string `json:"version"`
+	BootAttempts int    `json:"boot_attempts"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// backupPath returns the path of the previous binary kept for rollback
+func backupPath(executable string) string {
+	return executable + ".bak"
+}
+
+// pendingMarkerPath returns the path of the pending confirmation marker
+func pendingMarkerPath(executable string) string {
+	return executable + ".pending"
+}
+
+// swapBinary replaces the executable with the new binary, keeping the previous one as a backup
+// and marking the update as pending confirmation when rollback is enabled
+func (c *PepeunitClient) swapBinary(newPath, executable string, event UpdateEvent) error {
+	if !c.ffUpdateRollbackEnable {
+		if err := os.Rename(newPath, executable); err != nil {
+			_ = os.Remove(executable)
+			if err2 := os.Rename(newPath, executable); err2 != nil {
+				return fmt.Errorf("failed to replace binary: %v", err2)
+			}
+		}
+		return nil
+	}
+
+	backup := backupPath(executable)
+	markerPath := pendingMarkerPath(executable)
+	fm := NewFileManager()
+	replacingPending := c.IsUpdatePending() && fm.FileExists(backup)
+	previousMarker, _ := os.ReadFile(markerPath)
+
+	// The marker goes first, so a crash during the swap never leaves an unconfirmed binary without one
+	marker := pendingUpdate{
+		Executable: executable,
+		Backup:     backup,
+		Version:    event.Version,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := fm.WriteJSON(markerPath, marker); err != nil {
+		return fmt.Errorf("failed to write update marker: %v", err)
+	}
+	undoMarker := func() error {
+		if len(previousMarker) > 0 {
+			return os.WriteFile(markerPath, previousMarker, 0644)
+		}
+		if err := os.Remove(markerPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if replacingPending {
+		// Keep the last confirmed binary as the backup when replacing an unconfirmed one
+		if err := os.Rename(newPath, executable); err != nil {
+			return undoSwapError(fmt.Errorf("failed to replace binary: %v", err), undoMarker())
+		}
+	} else {
+		if err := os.Rename(executable, backup); err != nil {
+			return undoSwapError(fmt.Errorf("failed to back up binary: %v", err), undoMarker())
+		}
+		if err := os.Rename(newPath, executable); err != nil {
+			undoErr := os.Rename(backup, executable)
+			if undoErr == nil {
+				undoErr = undoMarker()
+			}
+			return undoSwapError(fmt.Errorf("failed to replace binary: %v", err), undoErr)
+		}
+	}
+	c.logger.Info(fmt.Sprintf("Previous binary kept as %s until the update is confirmed", backup))
+	return nil
+}
+
+// undoSwapError adds the error of undoing a failed swap to the swap error
+func undoSwapError(err, undoErr error) error {
+	if undoErr != nil {
+		return fmt.Errorf("%v, failed to undo the swap: %v", err, undoErr)
+	}
+	return err
+}
+
+// checkPendingUpdate counts a boot of an unconfirmed binary and restores the backup
+// once the number of boots exceeds the configured limit
+func (c *PepeunitClient) checkPendingUpdate() {
+	executable, err := os.Executable()
+	if err != nil {
+		return
+	}
+	markerPath := pendingMarkerPath(executable)
+	fm := NewFileManager()
+	if !fm.FileExists(markerPath) {
+		return
+	}
+
+	var marker pendingUpdate
+	data, err := fm.ReadJSON(markerPath)
+	if err != nil {
+		c.logger.Error(fmt.Sprintf("Failed to read update marker: %v", err), true)
+		return
+	}
+	marker.Backup = toString(data["backup"])
+	marker.Version = toString(data["version"])
+	marker.CreatedAt = toString(data["created_at"])
+	marker.Executable = executable
+	marker.BootAttempts = toInt(data["boot_attempts"]) + 1
+	if marker.Backup == "" || !fm.FileExists(marker.Backup) {
+		// The swap stopped before the backup was taken, the running binary is still the previous one
+		c.logger.Warning("Update marker without a backup binary, removing it", true)
+		_ = os.Remove(markerPath)
+		return
+	}
+
+	if marker.BootAttempts <= c.updateMaxBootAttempts {
+		if err := fm.WriteJSON(markerPath, marker); err != nil {
+			c.logger.Error(fmt.Sprintf("Failed to write update marker: %v", err), true)
+		}
+		c.updatePending = true
+		c.logger.Info(fmt.Sprintf("Unconfirmed update boot %d of %d", marker.BootAttempts, c.updateMaxBootAttempts), true)
+		return
+	}
+
+	c.logger.Warning(fmt.Sprintf("Update was not confirmed after %d boots, restoring previous binary", marker.BootAttempts-1), true)
+	if err := c.restoreBackup(marker); err != nil {
+		c.logger.Error(fmt.Sprintf("Failed to roll back update: %v", err), true)
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	rollback := UpdateStatus{
+		Phase:     UpdatePhaseFailed,
+		Type:      UpdateTypeBinary,
+		Version:   marker.Version,
+		Error:     fmt.Sprintf("update was not confirmed after %d boots, rolled back", marker.BootAttempts-1),
+		StartedAt: marker.CreatedAt,
+		UpdatedAt: now,
+	}
+	if err := c.appendUpdateHistory(rollback); err != nil {
+		c.logger.Error(fmt.Sprintf("Failed to write update history: %v", err), true)
+	}
+	// The running binary is the unconfirmed one, so it is replaced even when updates do not restart
+	restarter := c.restarter
+	if restarter == nil {
+		restarter = ExecRestarter()
+	}
+	if err := restarter.Restart(context.Background()); err != nil {
+		c.logger.Error(fmt.Sprintf("Failed to restart restored binary: %v", err), true)
+	}
+}
+
+// restoreBackup moves the backup over the executable and removes the marker
+func (c *PepeunitClient) restoreBackup(marker pendingUpdate) error {
+	if marker.Backup == "" || !NewFileManager().FileExists(marker.Backup) {
+		_ = os.Remove(pendingMarkerPath(marker.Executable))
+		return fmt.Errorf("backup binary not found")
+	}
+	if err := os.Rename(marker.Backup, marker.Executable); err != nil {
+		return err
+	}
+	_ = os.Remove(pendingMarkerPath(marker.Executable))
+	c.logger.Warning(fmt.Sprintf("Rolled back to previous binary from %s", marker.Backup), true)
+	return nil
+}
+
+// ConfirmUpdate marks the running binary as healthy, removing the rollback marker and backup
+func (c *PepeunitClient) ConfirmUpdate() error {
+	c.mutex.Lock()
+	pending := c.updatePending
+	c.updatePending = false
+	c.mutex.Unlock()
+	if !pending {
+		return nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %v", err)
+	}
+	if err := os.Remove(pendingMarkerPath(executable)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove update marker: %v", err)
+	}
+	_ = os.Remove(backupPath(executable))
+	c.logger.Info("Update confirmed")
+	return nil
+}
+
+// IsUpdatePending reports whether the running binary is an update awaiting confirmation
+func (c *PepeunitClient) IsUpdatePending() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.updatePending
+}
+
+// autoConfirmUpdate confirms a pending update once the main cycle ran the configured number of cycles
+func (c *PepeunitClient) autoConfirmUpdate(cycles int) {
+	if c.updateConfirmCycles <= 0 || cycles < c.updateConfirmCycles || !c.IsUpdatePending() {
+		return
+	}
+	if err := c.ConfirmUpdate(); err != nil {
+		c.logger.Error(fmt.Sprintf("Failed to confirm update: %v", err))
+	}
+}
+
+// confirmUpdateOnConnect confirms a pending update after a successful broker connection
+func (c *PepeunitClient) confirmUpdateOnConnect() {
+	if !c.IsUpdatePending() {
+		return
+	}
+	if err := c.ConfirmUpdate(); err != nil {
+		c.logger.Error(fmt.Sprintf("Failed to confirm update: %v", err))
+	}
+}
@@ -0,0 +1,162 @@
+package pepeunit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newRollbackClient returns a client with rollback enabled and a binary "app" in a temporary directory
+func newRollbackClient(t *testing.T) (*PepeunitClient, string) {
+	t.Helper()
+	executable := filepath.Join(t.TempDir(), "app")
+	if err := os.WriteFile(executable, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	client := &PepeunitClient{
+		ffUpdateRollbackEnable: true,
+		updateMaxBootAttempts:  DefaultUpdateMaxBootAttempts,
+		logger:                 NewLogger("", nil, nil, NewSettingsWith("", nil), false),
+	}
+	return client, executable
+}
+
+func writeNewBinary(t *testing.T, executable, content string) string {
+	t.Helper()
+	newPath := executable + ".new"
+	if err := os.WriteFile(newPath, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return newPath
+}
+
+func readFileString(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return string(data)
+}
+
+func TestSwapBinaryKeepsBackupAndMarker(t *testing.T) {
+	client, executable := newRollbackClient(t)
+	if err := client.swapBinary(writeNewBinary(t, executable, "new"), executable, UpdateEvent{Version: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFileString(t, executable); got != "new" {
+		t.Errorf("executable = %q", got)
+	}
+	if got := readFileString(t, backupPath(executable)); got != "old" {
+		t.Errorf("backup = %q", got)
+	}
+	marker, err := NewFileManager().ReadJSON(pendingMarkerPath(executable))
+	if err != nil || marker["version"] != "v2" || marker["backup"] != backupPath(executable) {
+		t.Errorf("marker = %v, %v", marker, err)
+	}
+
+	// A second update before confirmation keeps the confirmed binary as the backup
+	client.updatePending = true
+	if err := client.swapBinary(writeNewBinary(t, executable, "newer"), executable, UpdateEvent{Version: "v3"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFileString(t, backupPath(executable)); got != "old" {
+		t.Errorf("backup after second update = %q", got)
+	}
+	if marker, _ := NewFileManager().ReadJSON(pendingMarkerPath(executable)); marker["version"] != "v3" {
+		t.Errorf("marker version = %v", marker["version"])
+	}
+}
+
+func TestSwapBinaryMarkerFailureLeavesBinary(t *testing.T) {
+	client, executable := newRollbackClient(t)
+	// A directory in place of the marker makes writing it fail
+	if err := os.Mkdir(pendingMarkerPath(executable), 0755); err != nil {
+		t.Fatal(err)
+	}
+	newPath := writeNewBinary(t, executable, "new")
+
+	err := client.swapBinary(newPath, executable, UpdateEvent{Version: "v2"})
+	if err == nil || !strings.Contains(err.Error(), "update marker") {
+		t.Fatalf("swapBinary() = %v, want marker error", err)
+	}
+	if got := readFileString(t, executable); got != "old" {
+		t.Errorf("executable = %q, want the old binary", got)
+	}
+	if _, err := os.Stat(backupPath(executable)); !os.IsNotExist(err) {
+		t.Errorf("backup left behind: %v", err)
+	}
+	if got := readFileString(t, newPath); got != "new" {
+		t.Errorf("new binary = %q", got)
+	}
+}
+
+func TestSwapBinaryUndo(t *testing.T) {
+	tests := []struct {
+		name        string
+		pending     bool
+		wantMarker  string
+		wantBackup  string
+		wantCurrent string
+	}{
+		{name: "first update", wantCurrent: "old"},
+		{name: "unconfirmed update", pending: true, wantMarker: `{"version":"v2"}`, wantBackup: "confirmed", wantCurrent: "old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, executable := newRollbackClient(t)
+			if tt.pending {
+				client.updatePending = true
+				if err := os.WriteFile(backupPath(executable), []byte(tt.wantBackup), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(pendingMarkerPath(executable), []byte(tt.wantMarker), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The new binary is missing, so moving it into place fails after the marker was written
+			err := client.swapBinary(executable+".missing", executable, UpdateEvent{Version: "v3"})
+			if err == nil || !strings.Contains(err.Error(), "failed to replace binary") || strings.Contains(err.Error(), "undo") {
+				t.Fatalf("swapBinary() = %v, want a replace error without undo errors", err)
+			}
+			if got := readFileString(t, executable); got != tt.wantCurrent {
+				t.Errorf("executable = %q, want %q", got, tt.wantCurrent)
+			}
+			marker, err := os.ReadFile(pendingMarkerPath(executable))
+			if tt.wantMarker == "" && !os.IsNotExist(err) {
+				t.Errorf("marker left behind: %q, %v", marker, err)
+			}
+			if tt.wantMarker != "" && string(marker) != tt.wantMarker {
+				t.Errorf("marker = %q, want the previous marker %q", marker, tt.wantMarker)
+			}
+			backup, err := os.ReadFile(backupPath(executable))
+			if tt.wantBackup == "" && !os.IsNotExist(err) {
+				t.Errorf("backup left behind: %q, %v", backup, err)
+			}
+			if tt.wantBackup != "" && string(backup) != tt.wantBackup {
+				t.Errorf("backup = %q, want %q", backup, tt.wantBackup)
+			}
+		})
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	client, executable := newRollbackClient(t)
+	if err := client.swapBinary(writeNewBinary(t, executable, "new"), executable, UpdateEvent{Version: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.restoreBackup(pendingUpdate{Executable: executable, Backup: backupPath(executable)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFileString(t, executable); got != "old" {
+		t.Errorf("executable after rollback = %q", got)
+	}
+	if _, err := os.Stat(pendingMarkerPath(executable)); !os.IsNotExist(err) {
+		t.Errorf("marker kept after rollback: %v", err)
+	}
+	if err := client.restoreBackup(pendingUpdate{Executable: executable, Backup: backupPath(executable)}); err == nil {
+		t.Error("restoreBackup() without a backup succeeded")
+	}
+}
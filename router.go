@@ -0,0 +1,117 @@
+package pepeunit
+
+import "sync"
+
+// inputRouter dispatches incoming MQTT messages to handlers registered by schema topic key
+type inputRouter struct {
+	schema   *SchemaManager
+	handlers map[string]MQTTInputHandler
+	fallback MQTTInputHandler
+	routes   map[string]string
+	mutex    sync.RWMutex
+}
+
+// newInputRouter creates a router and builds its routing table from the schema
+func newInputRouter(schema *SchemaManager) *inputRouter {
+	router := &inputRouter{
+		schema:   schema,
+		handlers: make(map[string]MQTTInputHandler),
+		routes:   make(map[string]string),
+	}
+	router.rebuild()
+	return router
+}
+
+// rebuild resolves every input topic URL of the schema to its topic key
+func (r *inputRouter) rebuild() {
+	routes := make(map[string]string)
+	for topicKey, topics := range r.schema.GetInputBaseTopic() {
+		for _, topicURL := range topics {
+			routes[topicURL] = topicKey
+		}
+	}
+	for topicKey, topics := range r.schema.GetInputTopic() {
+		for _, topicURL := range topics {
+			routes[topicURL] = topicKey
+		}
+	}
+
+	r.mutex.Lock()
+	r.routes = routes
+	r.mutex.Unlock()
+}
+
+// handle registers a handler for a schema topic key, a nil handler removes it
+func (r *inputRouter) handle(topicKey string, handler MQTTInputHandler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if handler == nil {
+		delete(r.handlers, topicKey)
+		return
+	}
+	r.handlers[topicKey] = handler
+}
+
+// setFallback sets the handler for input messages without a registered topic key handler
+func (r *inputRouter) setFallback(handler MQTTInputHandler) {
+	r.mutex.Lock()
+	r.fallback = handler
+	r.mutex.Unlock()
+}
+
+// resolve returns the schema topic key for a topic URL
+func (r *inputRouter) resolve(topic string) (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	topicKey, ok := r.routes[topic]
+	return topicKey, ok
+}
+
+// dispatch calls the handler registered for the message topic key or the fallback handler.
+// Base command topics without a dedicated handler are not passed to the fallback.
+func (r *inputRouter) dispatch(msg MQTTMessage) {
+	r.mutex.RLock()
+	topicKey, known := r.routes[msg.Topic]
+	handler := r.handlers[topicKey]
+	fallback := r.fallback
+	r.mutex.RUnlock()
+
+	if known && handler != nil {
+		handler(msg)
+		return
+	}
+	if known && isBaseInputTopicKey(topicKey) {
+		return
+	}
+	if fallback != nil {
+		fallback(msg)
+	}
+}
+
+// isBaseInputTopicKey checks if the topic key belongs to a base Pepeunit command
+func isBaseInputTopicKey(topicKey string) bool {
+	switch BaseInputTopicType(topicKey) {
+	case BaseInputTopicTypeUpdatePepeunit,
+		BaseInputTopicTypeEnvUpdatePepeunit,
+		BaseInputTopicTypeSchemaUpdatePepeunit,
+		BaseInputTopicTypeLogSyncPepeunit:
+		return true
+	}
+	return false
+}
+
+// HandleInput registers a handler for messages arriving on the topics of a schema topic key,
+// e.g. "input/pepeunit". A nil handler removes the registration.
+func (c *PepeunitClient) HandleInput(topicKey string, handler MQTTInputHandler) {
+	c.router.handle(topicKey, handler)
+}
+
+// HandleInputFallback sets the handler for input messages without a registered topic key handler
+func (c *PepeunitClient) HandleInputFallback(handler MQTTInputHandler) {
+	c.router.setFallback(handler)
+}
+
+// ResolveInputTopicKey returns the schema topic key of an input topic URL
+func (c *PepeunitClient) ResolveInputTopicKey(topic string) (string, bool) {
+	return c.router.resolve(topic)
+}
@@ -0,0 +1,106 @@
+package pepeunit_test
+
+import (
+	"strings"
+	"testing"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+// newRouterClient returns a client of a unit with input/a and input/b topics
+func newRouterClient(t *testing.T) (*pepeunittest.Fixture, *pepeunit.PepeunitClient) {
+	t.Helper()
+	fixture := pepeunittest.NewTestFixture(t)
+	if err := fixture.SetSchema(pepeunittest.NewSchema(fixture.UnitUUID, []string{"input/a", "input/b"}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fixture, client
+}
+
+func TestHandleInputRoutesByTopicKey(t *testing.T) {
+	fixture, client := newRouterClient(t)
+	var got []string
+	record := func(prefix string) pepeunit.MQTTInputHandler {
+		return func(msg pepeunit.MQTTMessage) { got = append(got, prefix+":"+string(msg.Payload)) }
+	}
+	client.HandleInput("input/a", record("a"))
+	client.HandleInput("input/b", record("b"))
+	client.HandleInputFallback(record("fallback"))
+
+	fixture.MQTT.Inject(fixture.InputTopic("input/a"), "1")
+	fixture.MQTT.Inject(fixture.InputTopic("input/b"), "2")
+	if want := "a:1,b:2"; strings.Join(got, ",") != want {
+		t.Fatalf("handled = %v, want %s", got, want)
+	}
+
+	// Removing a handler passes its topic to the fallback
+	got = nil
+	client.HandleInput("input/b", nil)
+	fixture.MQTT.Inject(fixture.InputTopic("input/b"), "3")
+	if want := "fallback:3"; strings.Join(got, ",") != want {
+		t.Errorf("handled = %v, want %s", got, want)
+	}
+}
+
+func TestHandleInputFallbackSkipsBaseTopics(t *testing.T) {
+	fixture, client := newRouterClient(t)
+	var got []string
+	client.HandleInputFallback(func(msg pepeunit.MQTTMessage) { got = append(got, msg.Topic) })
+
+	fixture.MQTT.Inject(pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeLogSyncPepeunit)), "")
+	fixture.MQTT.Inject(fixture.InputTopic("input/a"), "1")
+	if len(got) != 1 || got[0] != fixture.InputTopic("input/a") {
+		t.Errorf("fallback got %v, want only input/a", got)
+	}
+}
+
+func TestHandleInputBaseTopicHandler(t *testing.T) {
+	fixture, client := newRouterClient(t)
+	handled := 0
+	client.HandleInput(string(pepeunit.BaseInputTopicTypeLogSyncPepeunit), func(msg pepeunit.MQTTMessage) { handled++ })
+
+	fixture.MQTT.Inject(pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeLogSyncPepeunit)), "")
+	if handled != 1 {
+		t.Errorf("base topic handler called %d times, want 1", handled)
+	}
+}
+
+func TestResolveInputTopicKey(t *testing.T) {
+	fixture, client := newRouterClient(t)
+	tests := []struct {
+		topic   string
+		wantKey string
+		wantOK  bool
+	}{
+		{topic: fixture.InputTopic("input/a"), wantKey: "input/a", wantOK: true},
+		{topic: pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeUpdatePepeunit)), wantKey: string(pepeunit.BaseInputTopicTypeUpdatePepeunit), wantOK: true},
+		{topic: pepeunittest.BaseTopic(fixture.UnitUUID, "unknown/pepeunit")},
+	}
+	for _, tt := range tests {
+		got, ok := client.ResolveInputTopicKey(tt.topic)
+		if got != tt.wantKey || ok != tt.wantOK {
+			t.Errorf("ResolveInputTopicKey(%s) = %q, %v, want %q, %v", tt.topic, got, ok, tt.wantKey, tt.wantOK)
+		}
+	}
+}
+
+func TestRouterRebuildsOnSchemaUpdate(t *testing.T) {
+	fixture, client := newRouterClient(t)
+	schema := pepeunittest.NewSchema(fixture.UnitUUID, []string{"input/c"}, nil)
+	fixture.Server.SetSchema(schema)
+
+	fixture.MQTT.Inject(pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeSchemaUpdatePepeunit)), "")
+
+	topic := schema[string(pepeunit.DestinationTopicTypeInputTopic)].(map[string]interface{})["input/c"].([]interface{})[0].(string)
+	if got, ok := client.ResolveInputTopicKey(topic); !ok || got != "input/c" {
+		t.Errorf("ResolveInputTopicKey(input/c) = %q, %v after schema update", got, ok)
+	}
+	if _, ok := client.ResolveInputTopicKey(fixture.InputTopic("input/a")); ok {
+		t.Error("input/a still routed after schema update")
+	}
+}
@@ -0,0 +1,342 @@
+package pepeunit
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TaskFunc is a function executed periodically by the main cycle scheduler
+type TaskFunc func(ctx context.Context, client *PepeunitClient) error
+
+// TaskSchedule computes the next run time of a task, a zero time stops the task
+type TaskSchedule interface {
+	Next(after time.Time) time.Time
+}
+
+// TaskOptions holds per-task execution options
+type TaskOptions struct {
+	// Timeout limits the context of a single run, zero means no limit
+	Timeout time.Duration
+	// Overrun decides what happens when a run is due while the previous one is still running
+	Overrun OverrunPolicy
+	// Immediate runs the task on the first cycle instead of waiting for the first schedule time
+	Immediate bool
+}
+
+// intervalSchedule runs a task at a fixed interval with an optional random jitter
+type intervalSchedule struct {
+	interval time.Duration
+	jitter   time.Duration
+}
+
+// Every returns a schedule running a task at a fixed interval
+func Every(interval time.Duration) TaskSchedule {
+	return &intervalSchedule{interval: interval}
+}
+
+// EveryWithJitter returns a schedule running a task at an interval extended by a random delay up to jitter
+func EveryWithJitter(interval, jitter time.Duration) TaskSchedule {
+	return &intervalSchedule{interval: interval, jitter: jitter}
+}
+
+// Next returns the next run time of the interval schedule
+func (s *intervalSchedule) Next(after time.Time) time.Time {
+	next := after.Add(s.interval)
+	if s.jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.jitter))))
+	}
+	return next
+}
+
+// settingsIntervalSchedule runs a task at an interval read from settings on every run
+type settingsIntervalSchedule struct {
+	settings *Settings
+	key      string
+}
+
+// Next returns the next run time using the current settings value in seconds
+func (s *settingsIntervalSchedule) Next(after time.Time) time.Time {
+	seconds, _ := s.settings.GetInt(s.key)
+	return after.Add(time.Duration(seconds) * time.Second)
+}
+
+// cronSchedule runs a task at times matching a cron expression
+type cronSchedule struct {
+	minute     map[int]bool
+	hour       map[int]bool
+	dayOfMonth map[int]bool
+	month      map[int]bool
+	dayOfWeek  map[int]bool
+	anyDom     bool
+	anyDow     bool
+}
+
+// Cron parses a standard 5-field cron expression: minute hour day-of-month month day-of-week.
+// Fields support "*", values, ranges "a-b", lists "a,b" and steps "*/n" or "a-b/n".
+func Cron(expression string) (TaskSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields: %q", expression)
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := make([]map[int]bool, 5)
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron field %q: %v", field, err)
+		}
+		sets[i] = set
+	}
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+
+	return &cronSchedule{
+		minute:     sets[0],
+		hour:       sets[1],
+		dayOfMonth: sets[2],
+		month:      sets[3],
+		dayOfWeek:  sets[4],
+		anyDom:     fields[2] == "*",
+		anyDow:     fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses a single cron field into the set of matching values
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			s, err := strconv.Atoi(part[idx+1:])
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("invalid step %q", part[idx+1:])
+			}
+			step = s
+			part = part[:idx]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			l, err1 := strconv.Atoi(bounds[0])
+			h, err2 := strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+			low, high = l, h
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			low = v
+			if step == 1 {
+				high = v
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("value out of range %d-%d", min, max)
+		}
+		for v := low; v <= high; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// matchesDay checks the day of month and day of week fields like cron does
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dayOfMonth[t.Day()]
+	dow := s.dayOfWeek[int(t.Weekday())]
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first minute after the given time matching the expression
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !s.month[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// scheduledTask is a task registered in the scheduler
+type scheduledTask struct {
+	name     string
+	schedule TaskSchedule
+	run      TaskFunc
+	options  TaskOptions
+	nextRun  time.Time
+	finished bool
+	running  bool
+	queued   int
+}
+
+// taskScheduler runs registered tasks from the main cycle
+type taskScheduler struct {
+	logger *Logger
+	tasks  map[string]*scheduledTask
+	order  []string
+	mutex  sync.Mutex
+}
+
+// newTaskScheduler creates an empty scheduler
+func newTaskScheduler(logger *Logger) *taskScheduler {
+	return &taskScheduler{
+		logger: logger,
+		tasks:  make(map[string]*scheduledTask),
+	}
+}
+
+// add registers a task
+func (s *taskScheduler) add(name string, schedule TaskSchedule, run TaskFunc, options TaskOptions) error {
+	if name == "" {
+		return fmt.Errorf("task name is required")
+	}
+	if schedule == nil || run == nil {
+		return fmt.Errorf("task %s requires a schedule and a function", name)
+	}
+	if options.Overrun == "" {
+		options.Overrun = OverrunPolicySkip
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.tasks[name]; ok {
+		return fmt.Errorf("task %s is already registered", name)
+	}
+
+	task := &scheduledTask{
+		name:     name,
+		schedule: schedule,
+		run:      run,
+		options:  options,
+	}
+	if !options.Immediate {
+		task.nextRun = schedule.Next(time.Now())
+		task.finished = task.nextRun.IsZero()
+	}
+	s.tasks[name] = task
+	s.order = append(s.order, name)
+	return nil
+}
+
+// remove unregisters a task, a running instance completes normally
+func (s *taskScheduler) remove(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.tasks[name]; !ok {
+		return
+	}
+	delete(s.tasks, name)
+	for i, n := range s.order {
+		if n == name {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// runDue starts every task whose run time has come
+func (s *taskScheduler) runDue(ctx context.Context, client *PepeunitClient, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, name := range s.order {
+		task := s.tasks[name]
+		if task.finished || now.Before(task.nextRun) {
+			continue
+		}
+		task.nextRun = task.schedule.Next(now)
+		task.finished = task.nextRun.IsZero()
+
+		if task.running {
+			if task.options.Overrun == OverrunPolicyQueue {
+				task.queued++
+			} else {
+				s.logger.Debug(fmt.Sprintf("Task %s skipped: previous run still in progress", task.name), true)
+			}
+			continue
+		}
+		if !client.beginHandler() {
+			return
+		}
+		task.running = true
+		go s.execute(ctx, client, task)
+	}
+}
+
+// execute runs a task and its queued runs
+func (s *taskScheduler) execute(ctx context.Context, client *PepeunitClient, task *scheduledTask) {
+	defer client.handlersWG.Done()
+	for {
+		s.runOnce(ctx, client, task)
+
+		s.mutex.Lock()
+		if task.queued > 0 && ctx.Err() == nil {
+			task.queued--
+			s.mutex.Unlock()
+			continue
+		}
+		task.queued = 0
+		task.running = false
+		s.mutex.Unlock()
+		return
+	}
+}
+
+// runOnce runs a task with its timeout and recovers from panics
+func (s *taskScheduler) runOnce(ctx context.Context, client *PepeunitClient, task *scheduledTask) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error(fmt.Sprintf("Task %s panicked: %v", task.name, r))
+		}
+	}()
+
+	taskCtx := ctx
+	if task.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		taskCtx, cancel = context.WithTimeout(ctx, task.options.Timeout)
+		defer cancel()
+	}
+
+	if err := task.run(taskCtx, client); err != nil {
+		s.logger.Error(fmt.Sprintf("Task %s failed: %v", task.name, err))
+	}
+}
+
+// AddTask registers a named periodic task executed by RunMainCycle.
+// Schedules are checked every cycle, so the cycle speed is the finest resolution.
+func (c *PepeunitClient) AddTask(name string, schedule TaskSchedule, task TaskFunc, options TaskOptions) error {
+	return c.scheduler.add(name, schedule, task, options)
+}
+
+// RemoveTask unregisters a periodic task
+func (c *PepeunitClient) RemoveTask(name string) {
+	c.scheduler.remove(name)
+}
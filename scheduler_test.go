@@ -0,0 +1,201 @@
+package pepeunit_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+// limitedSchedule is due on every cycle until it has returned runs run times, then it stops the task
+type limitedSchedule struct {
+	runs      int
+	exhausted chan struct{}
+	mutex     sync.Mutex
+}
+
+func newLimitedSchedule(runs int) *limitedSchedule {
+	return &limitedSchedule{runs: runs, exhausted: make(chan struct{})}
+}
+
+func (s *limitedSchedule) Next(after time.Time) time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.runs == 0 {
+		close(s.exhausted)
+		s.runs = -1
+	}
+	if s.runs < 0 {
+		return time.Time{}
+	}
+	s.runs--
+	return after
+}
+
+// runCycle starts the main cycle of a fixture client and stops it when the test ends
+func runCycle(t *testing.T, client *pepeunit.PepeunitClient) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		client.RunMainCycle(ctx, nil)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	base := time.Date(2026, time.October, 16, 10, 30, 15, 0, time.UTC)
+	tests := []struct {
+		expression string
+		want       time.Time
+	}{
+		{expression: "* * * * *", want: time.Date(2026, time.October, 16, 10, 31, 0, 0, time.UTC)},
+		{expression: "*/15 * * * *", want: time.Date(2026, time.October, 16, 10, 45, 0, 0, time.UTC)},
+		{expression: "0 3 * * *", want: time.Date(2026, time.October, 17, 3, 0, 0, 0, time.UTC)},
+		{expression: "0 0 1 1 *", want: time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		// 2026-10-18 is a Sunday, written as 0 and 7
+		{expression: "0 12 * * 0", want: time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)},
+		{expression: "0 12 * * 7", want: time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)},
+		// Restricted day of month and day of week match either field
+		{expression: "0 0 20 * 6", want: time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC)},
+		{expression: "0 0 30 2 *", want: time.Time{}},
+	}
+	for _, tt := range tests {
+		schedule, err := pepeunit.Cron(tt.expression)
+		if err != nil {
+			t.Fatalf("Cron(%q) error = %v", tt.expression, err)
+		}
+		if got := schedule.Next(base); !got.Equal(tt.want) {
+			t.Errorf("Cron(%q).Next() = %v, want %v", tt.expression, got, tt.want)
+		}
+	}
+
+	for _, expression := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := pepeunit.Cron(expression); err == nil {
+			t.Errorf("Cron(%q) accepted", expression)
+		}
+	}
+}
+
+func TestEveryWithJitter(t *testing.T) {
+	base := time.Now()
+	if got := pepeunit.Every(time.Minute).Next(base); !got.Equal(base.Add(time.Minute)) {
+		t.Errorf("Every().Next() = %v", got)
+	}
+	schedule := pepeunit.EveryWithJitter(time.Minute, time.Second)
+	for i := 0; i < 100; i++ {
+		got := schedule.Next(base).Sub(base)
+		if got < time.Minute || got >= time.Minute+time.Second {
+			t.Fatalf("EveryWithJitter().Next() after %v", got)
+		}
+	}
+}
+
+func TestAddTaskValidation(t *testing.T) {
+	client, err := pepeunittest.NewTestFixture(t).NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	noop := func(ctx context.Context, client *pepeunit.PepeunitClient) error { return nil }
+	if err := client.AddTask("", pepeunit.Every(time.Second), noop, pepeunit.TaskOptions{}); err == nil {
+		t.Error("task without name accepted")
+	}
+	if err := client.AddTask("nil", nil, noop, pepeunit.TaskOptions{}); err == nil {
+		t.Error("task without schedule accepted")
+	}
+	if err := client.AddTask("task", pepeunit.Every(time.Second), noop, pepeunit.TaskOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.AddTask("task", pepeunit.Every(time.Second), noop, pepeunit.TaskOptions{}); err == nil {
+		t.Error("duplicate task accepted")
+	}
+	client.RemoveTask("task")
+	if err := client.AddTask("task", pepeunit.Every(time.Second), noop, pepeunit.TaskOptions{}); err != nil {
+		t.Errorf("AddTask() after RemoveTask = %v", err)
+	}
+}
+
+func TestTaskOverrun(t *testing.T) {
+	tests := []struct {
+		policy   pepeunit.OverrunPolicy
+		wantRuns int32
+	}{
+		// The first run blocks while the schedule is due three more times
+		{policy: pepeunit.OverrunPolicySkip, wantRuns: 1},
+		{policy: pepeunit.OverrunPolicyQueue, wantRuns: 4},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			client, err := pepeunittest.NewTestFixture(t).NewClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			schedule := newLimitedSchedule(3)
+			release := make(chan struct{})
+			var runs int32
+			task := func(ctx context.Context, client *pepeunit.PepeunitClient) error {
+				atomic.AddInt32(&runs, 1)
+				<-release
+				return nil
+			}
+			if err := client.AddTask("overrun", schedule, task, pepeunit.TaskOptions{Overrun: tt.policy, Immediate: true}); err != nil {
+				t.Fatal(err)
+			}
+			runCycle(t, client)
+
+			<-schedule.exhausted
+			close(release)
+			waitFor(t, "task runs", func() bool { return atomic.LoadInt32(&runs) >= tt.wantRuns })
+			time.Sleep(50 * time.Millisecond)
+			if got := atomic.LoadInt32(&runs); got != tt.wantRuns {
+				t.Errorf("runs = %d, want %d", got, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestTaskTimeoutAndPanic(t *testing.T) {
+	client, err := pepeunittest.NewTestFixture(t).NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs int32
+	var deadlineSet atomic.Bool
+	task := func(ctx context.Context, client *pepeunit.PepeunitClient) error {
+		_, ok := ctx.Deadline()
+		deadlineSet.Store(ok)
+		if atomic.AddInt32(&runs, 1) == 1 {
+			panic("first run")
+		}
+		return nil
+	}
+	if err := client.AddTask("panics", pepeunit.Every(time.Millisecond), task, pepeunit.TaskOptions{Timeout: time.Minute, Immediate: true}); err != nil {
+		t.Fatal(err)
+	}
+	runCycle(t, client)
+
+	waitFor(t, "a run after the panic", func() bool { return atomic.LoadInt32(&runs) >= 2 })
+	if !deadlineSet.Load() {
+		t.Error("task context without the Timeout deadline")
+	}
+}
@@ -3,12 +3,15 @@ package pepeunit
 import (
 	"fmt"
 	"strings"
+	"sync"
 )
 
 // SchemaManager manages MQTT topic schema configuration
 type SchemaManager struct {
 	schemaFilePath string
 	schemaData     map[string]interface{}
+	listeners      []func()
+	listenersMu    sync.RWMutex
 }
 
 // NewSchemaManager creates a new schema manager
@@ -39,16 +42,43 @@ func (sm *SchemaManager) loadSchema() error {
 
 // UpdateFromFile reloads the schema from the file
 func (sm *SchemaManager) UpdateFromFile() error {
-	return sm.loadSchema()
+	if err := sm.loadSchema(); err != nil {
+		return err
+	}
+	sm.notifyListeners()
+	return nil
 }
 
 // UpdateSchema updates the schema with new data and saves to file
 func (sm *SchemaManager) UpdateSchema(schemaDict map[string]interface{}) error {
 	sm.schemaData = schemaDict
+	defer sm.notifyListeners()
 	fm := NewFileManager()
 	return fm.WriteJSON(sm.schemaFilePath, schemaDict)
 }
 
+// OnUpdate registers a callback invoked after the schema data is reloaded or replaced
+func (sm *SchemaManager) OnUpdate(listener func()) {
+	if listener == nil {
+		return
+	}
+	sm.listenersMu.Lock()
+	sm.listeners = append(sm.listeners, listener)
+	sm.listenersMu.Unlock()
+}
+
+// notifyListeners calls every registered update listener
+func (sm *SchemaManager) notifyListeners() {
+	sm.listenersMu.RLock()
+	listeners := make([]func(), len(sm.listeners))
+	copy(listeners, sm.listeners)
+	sm.listenersMu.RUnlock()
+
+	for _, listener := range listeners {
+		listener()
+	}
+}
+
 // GetInputBaseTopic returns the input base topics configuration
 func (sm *SchemaManager) GetInputBaseTopic() map[string][]string {
 	if data, ok := sm.schemaData[string(DestinationTopicTypeInputBaseTopic)].(map[string]interface{}); ok {
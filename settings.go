@@ -294,6 +294,16 @@ func (s *Settings) All() map[string]interface{} {
 	return result
 }
 
+// snapshot returns a copy of the settings that is not affected by later reloads
+func (s *Settings) snapshot() Settings {
+	copied := *s
+	copied.extras = make(map[string]interface{}, len(s.extras))
+	for k, v := range s.extras {
+		copied.extras[k] = v
+	}
+	return copied
+}
+
 // Helper functions for type conversion
 func toString(value interface{}) string {
 	if str, ok := value.(string); ok {
@@ -318,6 +328,21 @@ func toInt(value interface{}) int {
 	return 0
 }
 
+func toBool(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		b, err := strconv.ParseBool(v)
+		return err == nil && b
+	case float64:
+		return v != 0
+	case int:
+		return v != 0
+	}
+	return false
+}
+
 // UnitUUID extracts the unit UUID from the JWT token in settings
 func (s *Settings) UnitUUID() (string, error) {
 	tokenParts := strings.Split(s.PU_AUTH_TOKEN, ".")
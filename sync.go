@@ -0,0 +1,276 @@
+package pepeunit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyncManifest lists the paths, relative to the synced directory, changed by SyncDirectory
+type SyncManifest struct {
+	Added   []string
+	Changed []string
+	Removed []string
+}
+
+// syncEntry is the manifest record of one path in a directory tree
+type syncEntry struct {
+	mode   os.FileMode
+	size   int64
+	digest []byte
+	link   string
+}
+
+// SyncDirectory makes destDir an exact copy of srcDir. The new tree is staged next to destDir, paths matching
+// the preserve patterns are kept from the current destDir, and the staged tree replaces destDir by renames.
+// Patterns are slash separated paths relative to destDir matched with filepath.Match, a matching directory is kept whole.
+func (fm *FileManager) SyncDirectory(srcDir, destDir string, preserve []string) (SyncManifest, error) {
+	destDir, err := filepath.Abs(destDir)
+	if err != nil {
+		return SyncManifest{}, err
+	}
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+	staging := destDir + ".staging-" + suffix
+	old := destDir + ".old-" + suffix
+
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return SyncManifest{}, fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	if info, err := os.Stat(destDir); err == nil {
+		_ = os.Chmod(staging, info.Mode().Perm())
+	}
+	cleanup := func() { _ = os.RemoveAll(staging) }
+
+	if err := fm.CopyDirectoryContents(srcDir, staging); err != nil {
+		cleanup()
+		return SyncManifest{}, fmt.Errorf("failed to stage new tree: %v", err)
+	}
+	if err := fm.copyPreserved(destDir, staging, preserve); err != nil {
+		cleanup()
+		return SyncManifest{}, fmt.Errorf("failed to stage preserved files: %v", err)
+	}
+
+	before, err := buildSyncManifest(destDir)
+	if err != nil && !os.IsNotExist(err) {
+		cleanup()
+		return SyncManifest{}, fmt.Errorf("failed to read current tree: %v", err)
+	}
+	after, err := buildSyncManifest(staging)
+	if err != nil {
+		cleanup()
+		return SyncManifest{}, fmt.Errorf("failed to read staged tree: %v", err)
+	}
+	manifest := diffSyncManifests(before, after)
+
+	// A working directory inside destDir would follow the old tree into removal, it is moved to the new tree
+	cwdRel := ""
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(destDir, cwd); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			cwdRel = rel
+		}
+	}
+
+	if err := os.Rename(destDir, old); err != nil && !os.IsNotExist(err) {
+		cleanup()
+		return SyncManifest{}, fmt.Errorf("failed to move current tree aside: %v", err)
+	}
+	if err := os.Rename(staging, destDir); err != nil {
+		_ = os.Rename(old, destDir)
+		cleanup()
+		return SyncManifest{}, fmt.Errorf("failed to swap in new tree: %v", err)
+	}
+	if cwdRel != "" {
+		if err := chdirBelow(destDir, cwdRel); err != nil {
+			_ = os.Rename(destDir, staging)
+			_ = os.Rename(old, destDir)
+			_ = os.Chdir(filepath.Join(destDir, cwdRel))
+			cleanup()
+			return SyncManifest{}, fmt.Errorf("failed to change into new tree: %v", err)
+		}
+	}
+	_ = os.RemoveAll(old)
+	return manifest, nil
+}
+
+// chdirBelow changes into rel below dir, or into dir when rel is missing from the new tree
+func chdirBelow(dir, rel string) error {
+	if err := os.Chdir(filepath.Join(dir, rel)); err == nil {
+		return nil
+	}
+	return os.Chdir(dir)
+}
+
+// RecoverSyncDirectory cleans up after a SyncDirectory interrupted by a crash. When destDir is missing because
+// the process died between the two renames, the newest moved aside tree is restored. Leftover staged and
+// moved aside trees are removed. Only siblings named like SyncDirectory names them, <dir>.old-<unixnano> and
+// <dir>.staging-<unixnano>, are touched. It reports whether destDir was restored.
+func (fm *FileManager) RecoverSyncDirectory(destDir string) (bool, error) {
+	destDir, err := filepath.Abs(destDir)
+	if err != nil {
+		return false, err
+	}
+	olds := syncLeftovers(destDir + ".old-")
+	stagings := syncLeftovers(destDir + ".staging-")
+	if len(olds) == 0 && len(stagings) == 0 {
+		return false, nil
+	}
+
+	restored := false
+	if _, err := os.Lstat(destDir); os.IsNotExist(err) && len(olds) > 0 {
+		sort.Slice(olds, func(i, j int) bool { return syncSuffix(olds[i]) < syncSuffix(olds[j]) })
+		newest := olds[len(olds)-1]
+		if err := os.Rename(newest, destDir); err != nil {
+			return false, fmt.Errorf("failed to restore %s: %v", destDir, err)
+		}
+		olds = olds[:len(olds)-1]
+		restored = true
+	}
+	for _, path := range append(olds, stagings...) {
+		if err := os.RemoveAll(path); err != nil {
+			return restored, fmt.Errorf("failed to remove %s: %v", path, err)
+		}
+	}
+	return restored, nil
+}
+
+// syncLeftovers returns the paths made of prefix and a decimal timestamp suffix
+func syncLeftovers(prefix string) []string {
+	matches, _ := filepath.Glob(prefix + "[0-9]*")
+	var paths []string
+	for _, path := range matches {
+		suffix := path[len(prefix):]
+		if strings.Trim(suffix, "0123456789") == "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// syncSuffix returns the timestamp suffix of a staged or moved aside tree
+func syncSuffix(path string) int64 {
+	index := strings.LastIndex(path, "-")
+	value, _ := strconv.ParseInt(path[index+1:], 10, 64)
+	return value
+}
+
+// copyPreserved copies the paths of destDir matching the preserve patterns into staging
+func (fm *FileManager) copyPreserved(destDir, staging string, preserve []string) error {
+	if len(preserve) == 0 {
+		return nil
+	}
+	err := filepath.Walk(destDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(destDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !matchesPreserve(filepath.ToSlash(rel), preserve) {
+			return nil
+		}
+
+		target := filepath.Join(staging, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			if err := fm.CopyDirectoryContents(path, target); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		return fm.CopyFile(path, target)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// matchesPreserve reports whether a slash separated relative path matches one of the patterns
+func matchesPreserve(rel string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// buildSyncManifest records mode, size and SHA-256 of every entry below root
+func buildSyncManifest(root string) (map[string]syncEntry, error) {
+	entries := make(map[string]syncEntry)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		entry := syncEntry{mode: info.Mode(), size: info.Size()}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			entry.link, err = os.Readlink(path)
+		case info.Mode().IsRegular():
+			entry.digest, err = fileSHA256(path)
+		case info.IsDir():
+			entry.size = 0
+		}
+		if err != nil {
+			return err
+		}
+		entries[filepath.ToSlash(rel)] = entry
+		return nil
+	})
+	return entries, err
+}
+
+// diffSyncManifests returns the paths added, changed and removed between two manifests
+func diffSyncManifests(before, after map[string]syncEntry) SyncManifest {
+	var manifest SyncManifest
+	for path, entry := range after {
+		prev, ok := before[path]
+		switch {
+		case !ok:
+			manifest.Added = append(manifest.Added, path)
+		case prev.mode != entry.mode || prev.size != entry.size || prev.link != entry.link || !bytes.Equal(prev.digest, entry.digest):
+			manifest.Changed = append(manifest.Changed, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			manifest.Removed = append(manifest.Removed, path)
+		}
+	}
+	sort.Strings(manifest.Added)
+	sort.Strings(manifest.Changed)
+	sort.Strings(manifest.Removed)
+	return manifest
+}
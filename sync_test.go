@@ -0,0 +1,200 @@
+package pepeunit_test
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+func joinSorted(values []string) string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files, err := pepeunittest.ReadTree(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSyncDirectory(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     map[string]string
+		update      map[string]string
+		preserve    []string
+		want        map[string]string
+		wantAdded   string
+		wantChanged string
+		wantRemoved string
+	}{
+		{
+			name:      "new directory",
+			update:    map[string]string{"main": "v2"},
+			want:      map[string]string{"main": "v2"},
+			wantAdded: "main",
+		},
+		{
+			name:        "replace tree",
+			current:     map[string]string{"main": "v1", "old.txt": "gone", "same": "kept"},
+			update:      map[string]string{"main": "v2", "new.txt": "added", "same": "kept"},
+			want:        map[string]string{"main": "v2", "new.txt": "added", "same": "kept"},
+			wantAdded:   "new.txt",
+			wantChanged: "main",
+			wantRemoved: "old.txt",
+		},
+		{
+			name:     "preserve files and directories",
+			current:  map[string]string{"main": "v1", "env.json": "local", "data/db": "rows", "cache/x": "tmp"},
+			update:   map[string]string{"main": "v2", "env.json": "shipped"},
+			preserve: []string{"env.json", "data"},
+			want:     map[string]string{"main": "v2", "env.json": "local", "data/db": "rows"},
+			// Preserved paths are staged into the new tree, so they do not show up as changes
+			wantChanged: "main",
+			wantRemoved: "cache,cache/x",
+		},
+		{
+			name:        "preserve pattern",
+			current:     map[string]string{"main": "v1", "a.log": "1", "b.log": "2"},
+			update:      map[string]string{"main": "v2"},
+			preserve:    []string{"*.log"},
+			want:        map[string]string{"main": "v2", "a.log": "1", "b.log": "2"},
+			wantChanged: "main",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			srcDir := filepath.Join(base, "src")
+			destDir := filepath.Join(base, "unit")
+			if err := pepeunittest.WriteTree(srcDir, tt.update); err != nil {
+				t.Fatal(err)
+			}
+			if err := pepeunittest.WriteTree(destDir, tt.current); err != nil {
+				t.Fatal(err)
+			}
+
+			manifest, err := pepeunit.NewFileManager().SyncDirectory(srcDir, destDir, tt.preserve)
+			if err != nil {
+				t.Fatalf("SyncDirectory() error = %v", err)
+			}
+			got := readTree(t, destDir)
+			if len(got) != len(tt.want) {
+				t.Errorf("tree = %v, want %v", got, tt.want)
+			}
+			for name, content := range tt.want {
+				if got[name] != content {
+					t.Errorf("%s = %q, want %q", name, got[name], content)
+				}
+			}
+			if joinSorted(manifest.Added) != tt.wantAdded || joinSorted(manifest.Changed) != tt.wantChanged || joinSorted(manifest.Removed) != tt.wantRemoved {
+				t.Errorf("manifest = %+v, want added %q changed %q removed %q", manifest, tt.wantAdded, tt.wantChanged, tt.wantRemoved)
+			}
+			if leftovers, _ := filepath.Glob(destDir + ".*"); len(leftovers) != 0 {
+				t.Errorf("leftover trees %v", leftovers)
+			}
+		})
+	}
+}
+
+func TestRecoverSyncDirectory(t *testing.T) {
+	tests := []struct {
+		name         string
+		dest         bool
+		trees        []string
+		wantRestored bool
+		wantMain     string
+	}{
+		{name: "nothing to recover", dest: true, wantMain: "current"},
+		{name: "leftovers removed", dest: true, trees: []string{".old-100", ".staging-100"}, wantMain: "current"},
+		{name: "restore moved aside tree", trees: []string{".old-100", ".staging-100"}, wantRestored: true, wantMain: ".old-100"},
+		{name: "restore newest tree", trees: []string{".old-900", ".old-1000", ".staging-1000"}, wantRestored: true, wantMain: ".old-1000"},
+		{name: "only staged tree", trees: []string{".staging-100"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destDir := filepath.Join(t.TempDir(), "unit")
+			if tt.dest {
+				if err := pepeunittest.WriteTree(destDir, map[string]string{"main": "current"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, suffix := range tt.trees {
+				if err := pepeunittest.WriteTree(destDir+suffix, map[string]string{"main": suffix}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			restored, err := pepeunit.NewFileManager().RecoverSyncDirectory(destDir)
+			if err != nil {
+				t.Fatalf("RecoverSyncDirectory() error = %v", err)
+			}
+			if restored != tt.wantRestored {
+				t.Errorf("restored = %v, want %v", restored, tt.wantRestored)
+			}
+			if got := readTree(t, destDir)["main"]; got != tt.wantMain {
+				t.Errorf("main = %q, want %q", got, tt.wantMain)
+			}
+			if leftovers, _ := filepath.Glob(destDir + ".*"); len(leftovers) != 0 {
+				t.Errorf("leftover trees %v", leftovers)
+			}
+		})
+	}
+}
+
+func TestRecoverSyncDirectoryKeepsForeignSiblings(t *testing.T) {
+	destDir := filepath.Join(t.TempDir(), "app")
+	foreign := []string{destDir + ".old-backup", destDir + ".staging-v2", destDir + ".old-12x"}
+	for _, path := range append(foreign, destDir+".old-100") {
+		if err := pepeunittest.WriteTree(path, map[string]string{"main": filepath.Base(path)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The unit directory is missing, but only app.old-100 was moved aside by SyncDirectory
+	restored, err := pepeunit.NewFileManager().RecoverSyncDirectory(destDir)
+	if err != nil || !restored {
+		t.Fatalf("RecoverSyncDirectory() = %v, %v", restored, err)
+	}
+	if got := readTree(t, destDir)["main"]; got != "app.old-100" {
+		t.Errorf("restored main = %q", got)
+	}
+	for _, path := range foreign {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s removed: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestSyncRecoveryOnlyInSyncMode(t *testing.T) {
+	for _, mode := range []pepeunit.UpdateApplyMode{pepeunit.UpdateApplyModeOverlay, pepeunit.UpdateApplyModeSync} {
+		t.Run(string(mode), func(t *testing.T) {
+			fixture := pepeunittest.NewTestFixture(t)
+			leftover := fixture.Dir + ".staging-100"
+			if err := pepeunittest.WriteTree(leftover, map[string]string{"main": "staged"}); err != nil {
+				t.Fatal(err)
+			}
+			config := fixture.Config()
+			config.UpdateApplyMode = mode
+			if _, err := fixture.NewClientWith(config); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := os.Stat(leftover)
+			if removed := os.IsNotExist(err); removed != (mode == pepeunit.UpdateApplyModeSync) {
+				t.Errorf("leftover removed = %v in %s mode", removed, mode)
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package pepeunit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// MQTTTransferScheme prefixes update sources received with the MQTT file transfer, e.g. "mqtt-transfer://fw-1.4.0"
+const MQTTTransferScheme = "mqtt-transfer://"
+
+// transferResendInterval is how long a receiver waits for new chunks before it requests the missing ones
+const transferResendInterval = 5 * time.Second
+
+// transferCheckInterval is how often a receiver checks its transfer for the resend interval and timeout
+const transferCheckInterval = time.Second
+
+// transferMaxResendIndexes bounds the chunk indexes listed in one resend request
+const transferMaxResendIndexes = 256
+
+// transferAckEvery is the number of received chunks between two acks
+const transferAckEvery = 64
+
+// transferMaxChunks bounds the chunk count of a manifest, so tiny chunks can not exhaust memory
+const transferMaxChunks = 1 << 16
+
+// transferMessage is a message of the MQTT file transfer protocol
+type transferMessage struct {
+	Type      TransferMessageType `json:"type"`
+	ID        string              `json:"id"`
+	Name      string              `json:"name,omitempty"`
+	Size      int64               `json:"size,omitempty"`
+	SHA256    string              `json:"sha256,omitempty"`
+	ChunkSize int                 `json:"chunk_size,omitempty"`
+	Chunks    int                 `json:"chunks,omitempty"`
+	Index     int                 `json:"index,omitempty"`
+	Data      string              `json:"data,omitempty"`
+	Received  int                 `json:"received,omitempty"`
+	Missing   []int               `json:"missing,omitempty"`
+	Error     string              `json:"error,omitempty"`
+}
+
+// mqttTransfer is the receive state of one file transfer
+type mqttTransfer struct {
+	id       string
+	filePath string
+	partPath string
+	maxSize  int64
+	file     *os.File
+	manifest *transferMessage
+	received []bool
+	count    int
+	updated  time.Time
+	done     chan error
+}
+
+// IsMQTTTransferSource reports whether an update source is received with the MQTT file transfer
+func IsMQTTTransferSource(source string) bool {
+	return strings.HasPrefix(source, MQTTTransferScheme)
+}
+
+// transferTopics returns the topic the unit receives transfer messages on and the topic it answers on,
+// taken from the transfer/pepeunit input and transfer_ack/pepeunit output base topics of the schema
+func (c *PepeunitClient) transferTopics() (string, string, error) {
+	var input, ack string
+	if topics := c.schema.GetInputBaseTopic()[string(BaseInputTopicTypeTransferPepeunit)]; len(topics) > 0 {
+		input = topics[0]
+	}
+	if topics := c.schema.GetOutputBaseTopic()[string(BaseOutputTopicTypeTransferAckPepeunit)]; len(topics) > 0 {
+		ack = topics[0]
+	}
+	if input == "" || ack == "" {
+		return "", "", fmt.Errorf("schema has no %s input and %s output base topics for the MQTT file transfer",
+			BaseInputTopicTypeTransferPepeunit, BaseOutputTopicTypeTransferAckPepeunit)
+	}
+	return input, ack, nil
+}
+
+// ReceiveFileOverMQTT receives the file of a transfer published with MQTTFileSender and writes it to filePath.
+// Once the manifest has arrived the unit requests missing chunks when none arrive, and checks the SHA-256 of the manifest.
+func (c *PepeunitClient) ReceiveFileOverMQTT(ctx context.Context, transferID, filePath string) error {
+	if !c.enableMQTT || c.mqttClient == nil {
+		return fmt.Errorf("MQTT client is not enabled or available")
+	}
+	input, ack, err := c.transferTopics()
+	if err != nil {
+		return err
+	}
+
+	transfer := &mqttTransfer{
+		id:       transferID,
+		filePath: filePath,
+		partPath: filePath + ".part",
+		maxSize:  c.transferMaxSize,
+		updated:  time.Now(),
+		done:     make(chan error, 1),
+	}
+	c.transfersMutex.Lock()
+	if _, ok := c.transfers[transferID]; ok {
+		c.transfersMutex.Unlock()
+		return fmt.Errorf("transfer %s is already running", transferID)
+	}
+	c.transfers[transferID] = transfer
+	c.transfersMutex.Unlock()
+	defer func() {
+		c.transfersMutex.Lock()
+		delete(c.transfers, transferID)
+		if transfer.file != nil {
+			transfer.file.Close()
+			_ = os.Remove(transfer.partPath)
+		}
+		c.transfersMutex.Unlock()
+	}()
+
+	if err := c.mqttClient.SubscribeTopics([]string{input}); err != nil {
+		return fmt.Errorf("failed to subscribe transfer topic: %v", err)
+	}
+	defer func() {
+		c.mutex.RLock()
+		_, schemaTopic := c.subscribedTopics[input]
+		c.mutex.RUnlock()
+		if !schemaTopic {
+			_ = c.mqttClient.UnsubscribeTopics([]string{input})
+		}
+	}()
+
+	c.logger.Info(fmt.Sprintf("Waiting for MQTT transfer %s", transferID))
+
+	ticker := time.NewTicker(transferCheckInterval)
+	defer ticker.Stop()
+	var requested time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-transfer.done:
+			if err != nil {
+				c.publishTransfer(ack, transferMessage{Type: TransferMessageTypeError, ID: transferID, Error: err.Error()})
+				return fmt.Errorf("transfer %s failed: %v", transferID, err)
+			}
+			c.publishTransfer(ack, transferMessage{Type: TransferMessageTypeComplete, ID: transferID})
+			c.logger.Info(fmt.Sprintf("MQTT transfer %s received to %s", transferID, filePath))
+			return nil
+		case <-ticker.C:
+			c.transfersMutex.Lock()
+			idle := time.Since(transfer.updated)
+			started := transfer.manifest != nil
+			missing := transfer.missing()
+			c.transfersMutex.Unlock()
+
+			if idle >= c.transferTimeout {
+				c.publishTransfer(ack, transferMessage{Type: TransferMessageTypeError, ID: transferID, Error: "timeout"})
+				return fmt.Errorf("transfer %s timed out after %v without data", transferID, c.transferTimeout)
+			}
+			// A request before the manifest would restart a sender that is already streaming
+			if !started || idle < transferResendInterval || time.Since(requested) < transferResendInterval {
+				continue
+			}
+			requested = time.Now()
+			c.publishTransfer(ack, transferMessage{Type: TransferMessageTypeRequest, ID: transferID, Missing: missing})
+		}
+	}
+}
+
+// handleTransferMessage handles a message of the transfer topic, it returns false for messages of other topics
+func (c *PepeunitClient) handleTransferMessage(msg MQTTMessage) bool {
+	c.transfersMutex.Lock()
+	running := len(c.transfers) > 0
+	c.transfersMutex.Unlock()
+	if !running {
+		return false
+	}
+	input, ack, err := c.transferTopics()
+	if err != nil || msg.Topic != input {
+		return false
+	}
+
+	var message transferMessage
+	if err := json.Unmarshal(msg.Payload, &message); err != nil {
+		c.logger.Warning(fmt.Sprintf("Invalid MQTT transfer message: %v", err), true)
+		return true
+	}
+
+	c.transfersMutex.Lock()
+	transfer, ok := c.transfers[message.ID]
+	if !ok {
+		c.transfersMutex.Unlock()
+		return true
+	}
+	var (
+		complete bool
+		progress DownloadProgress
+	)
+	switch message.Type {
+	case TransferMessageTypeManifest:
+		err = transfer.start(message)
+		// An empty file has no chunks, so it is complete with its manifest
+		complete = err == nil && transfer.file != nil && transfer.count == transfer.manifest.Chunks
+	case TransferMessageTypeChunk:
+		complete, err = transfer.write(message)
+	default:
+		c.transfersMutex.Unlock()
+		return true
+	}
+	if transfer.manifest != nil {
+		progress = transfer.progress()
+	}
+	sendAck := err == nil && !complete && message.Type == TransferMessageTypeChunk && transfer.count%transferAckEvery == 0
+	received := transfer.count
+	if complete {
+		err = transfer.finish()
+	}
+	c.transfersMutex.Unlock()
+
+	if transfer.manifest != nil {
+		c.events.emitDownloadProgress(progress)
+	}
+	if sendAck {
+		c.publishTransfer(ack, transferMessage{Type: TransferMessageTypeAck, ID: message.ID, Received: received})
+	}
+	if err != nil || complete {
+		select {
+		case transfer.done <- err:
+		default:
+		}
+	}
+	return true
+}
+
+// publishTransfer publishes a transfer protocol message
+func (c *PepeunitClient) publishTransfer(topic string, message transferMessage) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	if err := c.mqttClient.Publish(topic, string(data)); err != nil {
+		c.logger.Warning(fmt.Sprintf("Failed to publish MQTT transfer message: %v", err), true)
+	}
+}
+
+// start prepares the transfer for the chunks described by a manifest, a repeated manifest keeps received chunks
+func (t *mqttTransfer) start(manifest transferMessage) error {
+	t.updated = time.Now()
+	if t.manifest != nil && t.manifest.SHA256 == manifest.SHA256 && t.manifest.Size == manifest.Size &&
+		t.manifest.ChunkSize == manifest.ChunkSize {
+		return nil
+	}
+	if manifest.Size < 0 || manifest.ChunkSize <= 0 || manifest.SHA256 == "" {
+		return fmt.Errorf("invalid transfer manifest")
+	}
+	// Checked before anything is allocated for the manifest
+	if t.maxSize > 0 && manifest.Size > t.maxSize {
+		return fmt.Errorf("transfer of %d bytes exceeds the maximum of %d bytes", manifest.Size, t.maxSize)
+	}
+	if manifest.Chunks > transferMaxChunks {
+		return fmt.Errorf("transfer of %d chunks exceeds the maximum of %d chunks", manifest.Chunks, transferMaxChunks)
+	}
+	if int64(manifest.Chunks) != (manifest.Size+int64(manifest.ChunkSize)-1)/int64(manifest.ChunkSize) {
+		return fmt.Errorf("invalid transfer manifest: %d chunks of %d bytes for %d bytes", manifest.Chunks, manifest.ChunkSize, manifest.Size)
+	}
+
+	if t.file != nil {
+		t.file.Close()
+	}
+	file, err := os.OpenFile(t.partPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create transfer file: %v", err)
+	}
+	if err := file.Truncate(manifest.Size); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to allocate transfer file: %v", err)
+	}
+	t.file = file
+	t.manifest = &manifest
+	t.received = make([]bool, manifest.Chunks)
+	t.count = 0
+	return nil
+}
+
+// write stores a chunk and reports whether all chunks are received
+func (t *mqttTransfer) write(chunk transferMessage) (bool, error) {
+	if t.manifest == nil {
+		return false, nil
+	}
+	if chunk.Index < 0 || chunk.Index >= t.manifest.Chunks {
+		return false, fmt.Errorf("chunk index %d out of range", chunk.Index)
+	}
+	t.updated = time.Now()
+	if t.received[chunk.Index] {
+		return false, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(chunk.Data)
+	if err != nil {
+		return false, fmt.Errorf("invalid chunk %d: %v", chunk.Index, err)
+	}
+	offset := int64(chunk.Index) * int64(t.manifest.ChunkSize)
+	expected := int64(t.manifest.ChunkSize)
+	if remaining := t.manifest.Size - offset; remaining < expected {
+		expected = remaining
+	}
+	if int64(len(data)) != expected {
+		return false, fmt.Errorf("chunk %d has %d bytes, expected %d", chunk.Index, len(data), expected)
+	}
+	if _, err := t.file.WriteAt(data, offset); err != nil {
+		return false, fmt.Errorf("failed to write chunk %d: %v", chunk.Index, err)
+	}
+	t.received[chunk.Index] = true
+	t.count++
+	return t.count == t.manifest.Chunks, nil
+}
+
+// finish checks the received file against the manifest and moves it into place
+func (t *mqttTransfer) finish() error {
+	if err := t.file.Sync(); err != nil {
+		return err
+	}
+	if err := t.file.Close(); err != nil {
+		return err
+	}
+	t.file = nil
+
+	digest, err := fileSHA256(t.partPath)
+	if err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(digest); !strings.EqualFold(actual, t.manifest.SHA256) {
+		_ = os.Remove(t.partPath)
+		return fmt.Errorf("sha256 mismatch: expected %s, got %s", t.manifest.SHA256, actual)
+	}
+	return os.Rename(t.partPath, t.filePath)
+}
+
+// missing returns the first indexes of chunks not received yet
+func (t *mqttTransfer) missing() []int {
+	var missing []int
+	for i, ok := range t.received {
+		if !ok {
+			missing = append(missing, i)
+			if len(missing) == transferMaxResendIndexes {
+				break
+			}
+		}
+	}
+	return missing
+}
+
+// progress returns the transfer progress as a download progress
+func (t *mqttTransfer) progress() DownloadProgress {
+	downloaded := int64(t.count) * int64(t.manifest.ChunkSize)
+	if downloaded > t.manifest.Size {
+		downloaded = t.manifest.Size
+	}
+	return DownloadProgress{URL: MQTTTransferScheme + t.id, FilePath: t.filePath, Downloaded: downloaded, Total: t.manifest.Size}
+}
+
+// fetchFile downloads an update file from a URL with the REST client or receives it with the MQTT file transfer
+func (c *PepeunitClient) fetchFile(ctx context.Context, source, filePath string) error {
+	if IsMQTTTransferSource(source) {
+		return c.ReceiveFileOverMQTT(ctx, strings.TrimPrefix(source, MQTTTransferScheme), filePath)
+	}
+	if !c.enableREST || c.restClient == nil {
+		return fmt.Errorf("REST client is not enabled or available")
+	}
+	return c.restClient.DownloadFileFromURL(ctx, source, filePath)
+}
@@ -0,0 +1,163 @@
+package pepeunit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MQTTFileSender publishes a file to a unit with the MQTT file transfer protocol.
+// Messages of the unit transfer_ack/pepeunit topic must be passed to HandleAck.
+type MQTTFileSender struct {
+	client    MQTTClient
+	topic     string
+	chunkSize int
+	timeout   time.Duration
+	messages  chan transferMessage
+}
+
+// NewMQTTFileSender creates a sender publishing to the transfer/pepeunit topic of a unit
+func NewMQTTFileSender(client MQTTClient, topic string) *MQTTFileSender {
+	return &MQTTFileSender{
+		client:    client,
+		topic:     topic,
+		chunkSize: DefaultTransferChunkSize,
+		timeout:   DefaultTransferTimeout,
+		messages:  make(chan transferMessage, 64),
+	}
+}
+
+// SetChunkSize sets the size of the file chunks, it must fit the broker message size limit after base64 encoding
+func (s *MQTTFileSender) SetChunkSize(size int) {
+	if size > 0 {
+		s.chunkSize = size
+	}
+}
+
+// SetTimeout sets how long Send waits for a message of the unit before it fails
+func (s *MQTTFileSender) SetTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		s.timeout = timeout
+	}
+}
+
+// HandleAck passes a message of the unit transfer_ack/pepeunit topic to the running Send
+func (s *MQTTFileSender) HandleAck(msg MQTTMessage) {
+	var message transferMessage
+	if err := json.Unmarshal(msg.Payload, &message); err != nil {
+		return
+	}
+	select {
+	case s.messages <- message:
+	default:
+	}
+}
+
+// Send publishes the manifest and chunks of a file and republishes the chunks the unit requests until it
+// reports the file received, reports an error, goes silent for the timeout or ctx is done. The unit must be
+// subscribed to its transfer topic before Send starts.
+func (s *MQTTFileSender) Send(ctx context.Context, transferID, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	digest, err := fileSHA256(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to hash file: %v", err)
+	}
+
+	size := info.Size()
+	chunks := int((size + int64(s.chunkSize) - 1) / int64(s.chunkSize))
+	manifest := transferMessage{
+		Type:      TransferMessageTypeManifest,
+		ID:        transferID,
+		Name:      filepath.Base(filePath),
+		Size:      size,
+		SHA256:    hex.EncodeToString(digest),
+		ChunkSize: s.chunkSize,
+		Chunks:    chunks,
+	}
+
+	buf := make([]byte, s.chunkSize)
+	publishChunk := func(index int) error {
+		n, err := file.ReadAt(buf, int64(index)*int64(s.chunkSize))
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read chunk %d: %v", index, err)
+		}
+		return s.publish(transferMessage{
+			Type:  TransferMessageTypeChunk,
+			ID:    transferID,
+			Index: index,
+			Data:  base64.StdEncoding.EncodeToString(buf[:n]),
+		})
+	}
+
+	if err := s.publish(manifest); err != nil {
+		return err
+	}
+	for i := 0; i < chunks; i++ {
+		if err := publishChunk(i); err != nil {
+			return err
+		}
+	}
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return fmt.Errorf("transfer %s timed out after %v without answer", transferID, s.timeout)
+		case message := <-s.messages:
+			if message.ID != transferID {
+				continue
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(s.timeout)
+
+			switch message.Type {
+			case TransferMessageTypeComplete:
+				return nil
+			case TransferMessageTypeError:
+				return fmt.Errorf("transfer %s failed on unit: %s", transferID, message.Error)
+			case TransferMessageTypeRequest:
+				for _, index := range message.Missing {
+					if index < 0 || index >= chunks {
+						continue
+					}
+					if err = publishChunk(index); err != nil {
+						break
+					}
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// publish publishes a transfer protocol message to the unit
+func (s *MQTTFileSender) publish(message transferMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Publish(s.topic, string(data)); err != nil {
+		return fmt.Errorf("failed to publish transfer message: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package pepeunit_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+// transferFixture returns a fixture whose schema declares the MQTT file transfer topics
+func transferFixture(t *testing.T) *pepeunittest.Fixture {
+	t.Helper()
+	fixture := pepeunittest.NewTestFixture(t)
+	pepeunittest.AddTransferTopics(fixture.Schema, fixture.UnitUUID)
+	if err := fixture.SetSchema(fixture.Schema); err != nil {
+		t.Fatal(err)
+	}
+	return fixture
+}
+
+// receiveInBackground starts ReceiveFileOverMQTT and returns once the unit listens on its transfer topic
+func receiveInBackground(t *testing.T, fixture *pepeunittest.Fixture, client *pepeunit.PepeunitClient, filePath string) <-chan error {
+	t.Helper()
+	input := pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeTransferPepeunit))
+	// The fixture subscribed all schema topics, the receiver subscribing again marks the transfer as registered
+	_ = fixture.MQTT.UnsubscribeTopics([]string{input})
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- client.ReceiveFileOverMQTT(ctx, "fw", filePath) }()
+	waitFor(t, "the transfer subscription", func() bool {
+		for _, topic := range fixture.MQTT.Subscriptions() {
+			if topic == input {
+				return true
+			}
+		}
+		return false
+	})
+	return done
+}
+
+// transferAnswers returns the messages the unit published to its transfer_ack/pepeunit topic
+func transferAnswers(fixture *pepeunittest.Fixture) []map[string]interface{} {
+	var answers []map[string]interface{}
+	ack := pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseOutputTopicTypeTransferAckPepeunit))
+	for _, payload := range fixture.MQTT.PublishedTo(ack) {
+		var message map[string]interface{}
+		_ = json.Unmarshal([]byte(payload), &message)
+		answers = append(answers, message)
+	}
+	return answers
+}
+
+// injectTransfer delivers transfer protocol messages to the transfer topic of the unit
+func injectTransfer(fixture *pepeunittest.Fixture, messages ...map[string]interface{}) {
+	input := pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeTransferPepeunit))
+	for _, message := range messages {
+		data, _ := json.Marshal(message)
+		fixture.MQTT.Inject(input, string(data))
+	}
+}
+
+func manifestFor(content []byte, chunkSize int) map[string]interface{} {
+	digest := sha256.Sum256(content)
+	return map[string]interface{}{
+		"type":       "manifest",
+		"id":         "fw",
+		"size":       len(content),
+		"sha256":     hex.EncodeToString(digest[:]),
+		"chunk_size": chunkSize,
+		"chunks":     (len(content) + chunkSize - 1) / chunkSize,
+	}
+}
+
+func chunkOf(content []byte, chunkSize, index int) map[string]interface{} {
+	end := (index + 1) * chunkSize
+	if end > len(content) {
+		end = len(content)
+	}
+	return map[string]interface{}{"type": "chunk", "id": "fw", "index": index, "data": base64.StdEncoding.EncodeToString(content[index*chunkSize : end])}
+}
+
+func TestReceiveFileOverMQTTNeedsSchemaTopics(t *testing.T) {
+	client, err := pepeunittest.NewTestFixture(t).NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.ReceiveFileOverMQTT(context.Background(), "fw", filepath.Join(t.TempDir(), "fw.bin"))
+	if err == nil || !strings.Contains(err.Error(), string(pepeunit.BaseInputTopicTypeTransferPepeunit)) {
+		t.Errorf("ReceiveFileOverMQTT() without schema topics = %v", err)
+	}
+}
+
+func TestReceiveFileOverMQTTOutOfOrder(t *testing.T) {
+	fixture := transferFixture(t)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("firmware over mqtt")
+	filePath := filepath.Join(fixture.Dir, "fw.bin")
+	done := receiveInBackground(t, fixture, client, filePath)
+
+	injectTransfer(fixture, manifestFor(content, 8), chunkOf(content, 8, 2), chunkOf(content, 8, 0), chunkOf(content, 8, 0), chunkOf(content, 8, 1))
+	if err := <-done; err != nil {
+		t.Fatalf("ReceiveFileOverMQTT() = %v", err)
+	}
+	if data, err := os.ReadFile(filePath); err != nil || string(data) != string(content) {
+		t.Errorf("received %q, %v", data, err)
+	}
+	answers := transferAnswers(fixture)
+	if len(answers) != 1 || answers[0]["type"] != string(pepeunit.TransferMessageTypeComplete) {
+		t.Errorf("answers = %v, want a single complete", answers)
+	}
+}
+
+func TestReceiveFileOverMQTTRejectsManifest(t *testing.T) {
+	content := []byte("firmware over mqtt")
+	withField := func(key string, value interface{}) map[string]interface{} {
+		manifest := manifestFor(content, 8)
+		manifest[key] = value
+		return manifest
+	}
+	tests := map[string]struct {
+		manifest map[string]interface{}
+		maxSize  int64
+		wantErr  string
+	}{
+		"larger than the maximum size": {manifest: manifestFor(content, 8), maxSize: 8, wantErr: "exceeds the maximum of 8 bytes"},
+		"too many chunks":              {manifest: withField("chunks", 1<<20), maxSize: -1, wantErr: "chunks exceeds the maximum"},
+		"chunk count not matching":     {manifest: withField("chunks", 2), wantErr: "invalid transfer manifest"},
+		"missing sha256":               {manifest: withField("sha256", ""), wantErr: "invalid transfer manifest"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			fixture := transferFixture(t)
+			config := fixture.Config()
+			config.TransferMaxSize = tt.maxSize
+			client, err := fixture.NewClientWith(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filePath := filepath.Join(fixture.Dir, "fw.bin")
+			done := receiveInBackground(t, fixture, client, filePath)
+
+			injectTransfer(fixture, tt.manifest)
+			if err := <-done; err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ReceiveFileOverMQTT() = %v, want %q", err, tt.wantErr)
+			}
+			if answers := transferAnswers(fixture); len(answers) == 0 || answers[len(answers)-1]["type"] != string(pepeunit.TransferMessageTypeError) {
+				t.Errorf("answers = %v, want a final error", answers)
+			}
+			if _, err := os.Stat(filePath + ".part"); !os.IsNotExist(err) {
+				t.Errorf("part file left after a rejected manifest: %v", err)
+			}
+		})
+	}
+}
+
+func TestReceiveFileOverMQTTChecksChunks(t *testing.T) {
+	content := []byte("firmware over mqtt")
+	wrongDigest := manifestFor(content, 32)
+	wrongDigest["sha256"] = strings.Repeat("0", 64)
+	short := chunkOf(content, 8, 0)
+	short["data"] = base64.StdEncoding.EncodeToString(content[:4])
+
+	for wantErr, messages := range map[string][]map[string]interface{}{
+		"sha256 mismatch":                 {wrongDigest, chunkOf(content, 32, 0)},
+		"chunk index 3 out of range":      {manifestFor(content, 8), chunkOf(content, 8, 0), {"type": "chunk", "id": "fw", "index": 3, "data": ""}},
+		"chunk 0 has 4 bytes, expected 8": {manifestFor(content, 8), short},
+	} {
+		fixture := transferFixture(t)
+		client, err := fixture.NewClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		filePath := filepath.Join(fixture.Dir, "fw.bin")
+		done := receiveInBackground(t, fixture, client, filePath)
+		injectTransfer(fixture, messages...)
+		if err := <-done; err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("ReceiveFileOverMQTT() = %v, want %q", err, wantErr)
+		}
+		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+			t.Errorf("%s: file kept after a failed transfer: %v", wantErr, err)
+		}
+	}
+}
+
+func TestReceiveFileOverMQTTWaitsForManifest(t *testing.T) {
+	fixture := transferFixture(t)
+	config := fixture.Config()
+	config.TransferTimeout = 1500 * time.Millisecond
+	client, err := fixture.NewClientWith(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a manifest the unit asks for nothing, a request would restart a sender already streaming
+	done := receiveInBackground(t, fixture, client, filepath.Join(fixture.Dir, "fw.bin"))
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("ReceiveFileOverMQTT() = %v, want a timeout", err)
+	}
+	answers := transferAnswers(fixture)
+	if len(answers) != 1 || answers[0]["type"] != string(pepeunit.TransferMessageTypeError) {
+		t.Errorf("answers = %v, want only the timeout error", answers)
+	}
+}
+
+func TestReceiveFileOverMQTTRequestsMissingChunks(t *testing.T) {
+	fixture := transferFixture(t)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("firmware over mqtt")
+	filePath := filepath.Join(fixture.Dir, "fw.bin")
+	var requested []interface{}
+	fixture.MQTT.SetPublishHook(func(topic, payload string) {
+		var message map[string]interface{}
+		if json.Unmarshal([]byte(payload), &message) != nil || message["type"] != string(pepeunit.TransferMessageTypeRequest) {
+			return
+		}
+		requested, _ = message["missing"].([]interface{})
+		go injectTransfer(fixture, chunkOf(content, 8, 1), chunkOf(content, 8, 2))
+	})
+	done := receiveInBackground(t, fixture, client, filePath)
+
+	injectTransfer(fixture, manifestFor(content, 8), chunkOf(content, 8, 0))
+	if err := <-done; err != nil {
+		t.Fatalf("ReceiveFileOverMQTT() = %v", err)
+	}
+	if len(requested) != 2 || requested[0] != float64(1) || requested[1] != float64(2) {
+		t.Errorf("requested chunks %v, want [1 2]", requested)
+	}
+	if data, _ := os.ReadFile(filePath); string(data) != string(content) {
+		t.Errorf("received %q", data)
+	}
+}
+
+// senderClient delivers the sender publishes to the unit through the fake broker
+type senderClient struct {
+	pepeunittest.FakeMQTTClient
+	unit *pepeunittest.FakeMQTTClient
+}
+
+func (s *senderClient) Publish(topic, message string) error {
+	s.unit.Inject(topic, message)
+	return nil
+}
+
+func TestMQTTFileSender(t *testing.T) {
+	for _, size := range []int{0, 96, 100, 5000} {
+		fixture := transferFixture(t)
+		client, err := fixture.NewClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		input := pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeTransferPepeunit))
+		sender := pepeunit.NewMQTTFileSender(&senderClient{unit: fixture.MQTT}, input)
+		sender.SetChunkSize(8)
+		sender.SetTimeout(5 * time.Second)
+		fixture.MQTT.SetPublishHook(func(topic, payload string) {
+			sender.HandleAck(pepeunit.MQTTMessage{Topic: topic, Payload: []byte(payload)})
+		})
+
+		content := make([]byte, size)
+		for i := range content {
+			content[i] = byte(i * 7)
+		}
+		sourcePath := filepath.Join(t.TempDir(), "firmware.bin")
+		if err := os.WriteFile(sourcePath, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		filePath := filepath.Join(fixture.Dir, "received.bin")
+		done := receiveInBackground(t, fixture, client, filePath)
+
+		if err := sender.Send(context.Background(), "fw", sourcePath); err != nil {
+			t.Fatalf("Send() of %d bytes = %v", size, err)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("ReceiveFileOverMQTT() of %d bytes = %v", size, err)
+		}
+		if data, _ := os.ReadFile(filePath); string(data) != string(content) {
+			t.Errorf("received %d bytes differing from the %d sent", len(data), size)
+		}
+	}
+}
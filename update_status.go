@@ -0,0 +1,160 @@
+package pepeunit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// updateProgressPublishInterval limits how often download progress is published to the state topic
+const updateProgressPublishInterval = time.Second
+
+// UpdateStatus is the structured state of the current or last update
+type UpdateStatus struct {
+	Phase         UpdatePhase `json:"phase"`
+	Type          UpdateType  `json:"type,omitempty"`
+	Version       string      `json:"version,omitempty"`
+	Downloaded    int64       `json:"downloaded,omitempty"`
+	Total         int64       `json:"total,omitempty"`
+	Error         string      `json:"error,omitempty"`
+	StartedAt     string      `json:"started_at,omitempty"`
+	UpdatedAt     string      `json:"updated_at,omitempty"`
+	DeferredUntil string      `json:"deferred_until,omitempty"`
+}
+
+// isFinished reports whether the phase ends an update
+func (p UpdatePhase) isFinished() bool {
+	return p == UpdatePhaseDone || p == UpdatePhaseFailed
+}
+
+// trackUpdateStatus subscribes the update status to the update lifecycle events
+func (c *PepeunitClient) trackUpdateStatus() {
+	c.OnUpdateStarted(func(event UpdateEvent) error {
+		c.setUpdatePhase(event, UpdatePhaseStarted, nil)
+		return nil
+	})
+	c.OnUpdateApplied(func(event UpdateEvent) {
+		c.setUpdatePhase(event, UpdatePhaseDone, nil)
+	})
+	c.OnUpdateFailed(func(event UpdateEvent, err error) {
+		c.setUpdatePhase(event, UpdatePhaseFailed, err)
+	})
+	c.OnBeforeRestart(func(mode RestartMode) {
+		c.setUpdatePhase(UpdateEvent{}, UpdatePhaseRestarting, nil)
+	})
+	c.OnDownloadProgress(c.trackDownloadProgress)
+}
+
+// setUpdatePhase moves the update status to phase, records finished updates and publishes the state
+func (c *PepeunitClient) setUpdatePhase(event UpdateEvent, phase UpdatePhase, updateErr error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	c.updateMutex.Lock()
+	status := c.updateStatus
+	newUpdate := phase == UpdatePhaseStarted || status.Phase == UpdatePhaseIdle || status.Phase == UpdatePhaseDeferred ||
+		(status.Phase.isFinished() && phase != UpdatePhaseRestarting)
+	if newUpdate {
+		status = UpdateStatus{Type: event.Type, Version: event.Version, StartedAt: now}
+	}
+	if status.Version == "" {
+		status.Version = event.Version
+	}
+	status.Phase = phase
+	status.UpdatedAt = now
+	if updateErr != nil {
+		status.Error = updateErr.Error()
+	}
+	c.updateStatus = status
+	c.updateMutex.Unlock()
+
+	if phase.isFinished() {
+		if err := c.appendUpdateHistory(status); err != nil {
+			c.logger.Error(fmt.Sprintf("Failed to write update history: %v", err), true)
+		}
+	}
+	c.publishUpdateStatus()
+}
+
+// trackDownloadProgress stores the download progress of the running update
+func (c *PepeunitClient) trackDownloadProgress(progress DownloadProgress) {
+	c.updateMutex.Lock()
+	if c.updateStatus.Phase != UpdatePhaseDownloading {
+		c.updateMutex.Unlock()
+		return
+	}
+	c.updateStatus.Downloaded = progress.Downloaded
+	c.updateStatus.Total = progress.Total
+	complete := progress.Total >= 0 && progress.Downloaded >= progress.Total
+	publish := complete || time.Since(c.updateProgressSent) >= updateProgressPublishInterval
+	if publish {
+		c.updateProgressSent = time.Now()
+	}
+	c.updateMutex.Unlock()
+
+	if publish {
+		c.publishUpdateStatus()
+	}
+}
+
+// publishUpdateStatus publishes the state with the current update status
+func (c *PepeunitClient) publishUpdateStatus() {
+	if !c.enableMQTT || c.mqttClient == nil {
+		return
+	}
+	if err := c.publishState(); err != nil {
+		c.logger.Warning(fmt.Sprintf("Failed to publish update status: %v", err), true)
+	}
+}
+
+// GetUpdateStatus returns the status of the current or last update
+func (c *PepeunitClient) GetUpdateStatus() UpdateStatus {
+	c.updateMutex.RLock()
+	defer c.updateMutex.RUnlock()
+	return c.updateStatus
+}
+
+// GetUpdateHistory returns the finished updates, oldest first
+func (c *PepeunitClient) GetUpdateHistory() ([]UpdateStatus, error) {
+	data, err := os.ReadFile(c.updateHistoryFilePath)
+	if os.IsNotExist(err) {
+		return []UpdateStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var history []UpdateStatus
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("invalid update history format: %v", err)
+	}
+	return history, nil
+}
+
+// appendUpdateHistory adds a finished update to the history file, keeping the newest entries
+func (c *PepeunitClient) appendUpdateHistory(status UpdateStatus) error {
+	c.updateHistoryMutex.Lock()
+	defer c.updateHistoryMutex.Unlock()
+
+	history, err := c.GetUpdateHistory()
+	if err != nil {
+		history = []UpdateStatus{}
+	}
+	history = append(history, status)
+	if len(history) > DefaultUpdateHistoryLimit {
+		history = history[len(history)-DefaultUpdateHistoryLimit:]
+	}
+	return NewFileManager().WriteJSON(c.updateHistoryFilePath, history)
+}
+
+// loadUpdateStatus restores the last finished update, so its result survives the restart into the new program
+func (c *PepeunitClient) loadUpdateStatus() {
+	c.updateStatus = UpdateStatus{Phase: UpdatePhaseIdle}
+	history, err := c.GetUpdateHistory()
+	if err != nil {
+		c.logger.Warning(fmt.Sprintf("Failed to read update history: %v", err), true)
+		return
+	}
+	if len(history) > 0 {
+		c.updateStatus = history[len(history)-1]
+	}
+}
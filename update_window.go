@@ -0,0 +1,291 @@
+package pepeunit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// deferredUpdateRestoreDelay is the least time a deferred update restored after a restart waits,
+// so handlers registered right after NewPepeunitClient are in place when it starts
+const deferredUpdateRestoreDelay = time.Second
+
+// UpdateWindow is a daily time-of-day range in local time, an End before Start spans midnight
+type UpdateWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseUpdateWindows parses comma separated "HH:MM-HH:MM" ranges, e.g. "02:00-04:30,23:00-01:00"
+func ParseUpdateWindows(value string) ([]UpdateWindow, error) {
+	var windows []UpdateWindow
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		startStr, endStr, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid update window: '%s'", part)
+		}
+		start, err := parseTimeOfDay(startStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid update window: '%s'", part)
+		}
+		end, err := parseTimeOfDay(endStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid update window: '%s'", part)
+		}
+		windows = append(windows, UpdateWindow{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+// parseTimeOfDay parses "HH:MM" into the offset from midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls into the window
+func (w UpdateWindow) Contains(t time.Time) bool {
+	offset := t.Sub(startOfDay(t))
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// startOfDay returns local midnight of the day of t
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// nextWindowOpen returns t when it falls into one of the windows, otherwise the nearest later window start.
+// Without windows updates are always allowed.
+func nextWindowOpen(t time.Time, windows []UpdateWindow) time.Time {
+	if len(windows) == 0 {
+		return t
+	}
+	var next time.Time
+	for _, window := range windows {
+		if window.Contains(t) {
+			return t
+		}
+		open := startOfDay(t).Add(window.Start)
+		if !open.After(t) {
+			open = startOfDay(t.AddDate(0, 0, 1)).Add(window.Start)
+		}
+		if next.IsZero() || open.Before(next) {
+			next = open
+		}
+	}
+	return next
+}
+
+// windowClose returns the end of the window t falls into, the latest one when windows overlap.
+// It returns the zero time when t is outside all windows.
+func windowClose(t time.Time, windows []UpdateWindow) time.Time {
+	var latest time.Time
+	offset := t.Sub(startOfDay(t))
+	for _, window := range windows {
+		if !window.Contains(t) {
+			continue
+		}
+		end := startOfDay(t).Add(window.End)
+		if window.Start > window.End && offset >= window.Start {
+			end = startOfDay(t.AddDate(0, 0, 1)).Add(window.End)
+		}
+		if end.After(latest) {
+			latest = end
+		}
+	}
+	return latest
+}
+
+// deferredUpdate is the persisted update payload waiting for its jitter delay or maintenance window
+type deferredUpdate struct {
+	Payload    string    `json:"payload"`
+	Version    string    `json:"version,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+	NotBefore  time.Time `json:"not_before"`
+}
+
+// deferredUpdateFilePath returns the file holding the deferred update, next to the update history
+func (c *PepeunitClient) deferredUpdateFilePath() string {
+	return filepath.Join(filepath.Dir(c.updateHistoryFilePath), "update_deferred.json")
+}
+
+// updateWindows returns the maintenance windows from PU_UPDATE_WINDOW
+func (c *PepeunitClient) updateWindows() []UpdateWindow {
+	value, _ := c.settings.GetString("PU_UPDATE_WINDOW")
+	windows, err := ParseUpdateWindows(value)
+	if err != nil {
+		c.logger.Warning(fmt.Sprintf("Ignoring PU_UPDATE_WINDOW: %v", err))
+		return nil
+	}
+	return windows
+}
+
+// updateNotBefore returns the earliest time an update may start: the next maintenance window opening plus a random jitter.
+// The jitter is limited to the time left in the window, so the update never becomes due after the window closed.
+func (c *PepeunitClient) updateNotBefore(now time.Time) time.Time {
+	windows := c.updateWindows()
+	notBefore := nextWindowOpen(now, windows)
+	jitter := c.updateJitter
+	if closes := windowClose(notBefore, windows); !closes.IsZero() && closes.Sub(notBefore) < jitter {
+		jitter = closes.Sub(notBefore)
+	}
+	if jitter > 0 {
+		notBefore = notBefore.Add(time.Duration(rand.Int63n(int64(jitter))))
+	}
+	return notBefore
+}
+
+// deferUpdate persists the update payload when it has to wait for the jitter delay or a maintenance window.
+// A newer payload replaces a deferred one.
+func (c *PepeunitClient) deferUpdate(payload string) bool {
+	now := time.Now()
+	notBefore := c.updateNotBefore(now)
+	if !notBefore.After(now) {
+		return false
+	}
+
+	var meta map[string]interface{}
+	_ = json.Unmarshal([]byte(payload), &meta)
+	version, _ := meta["PU_COMMIT_VERSION"].(string)
+	deferred := deferredUpdate{Payload: payload, Version: version, ReceivedAt: now.UTC(), NotBefore: notBefore.UTC()}
+	if err := NewFileManager().WriteJSON(c.deferredUpdateFilePath(), deferred); err != nil {
+		c.logger.Warning(fmt.Sprintf("Failed to persist deferred update: %v", err))
+	}
+	c.setUpdateDeferred(deferred)
+	c.logger.Info(fmt.Sprintf("Update deferred until %s", deferred.NotBefore.Format(time.RFC3339)))
+	return true
+}
+
+// deferredUpdateStatus returns the update status reporting a deferred update
+func deferredUpdateStatus(deferred deferredUpdate) UpdateStatus {
+	return UpdateStatus{
+		Phase:         UpdatePhaseDeferred,
+		Version:       deferred.Version,
+		StartedAt:     deferred.ReceivedAt.Format(time.RFC3339),
+		UpdatedAt:     time.Now().UTC().Format(time.RFC3339),
+		DeferredUntil: deferred.NotBefore.Format(time.RFC3339),
+	}
+}
+
+// setUpdateDeferred reports the deferred update in the update status and publishes the state
+func (c *PepeunitClient) setUpdateDeferred(deferred deferredUpdate) {
+	c.updateMutex.Lock()
+	c.deferredUpdate = &deferred
+	c.updateStatus = deferredUpdateStatus(deferred)
+	c.updateMutex.Unlock()
+	c.scheduleDeferredUpdate(0)
+	c.publishUpdateStatus()
+}
+
+// scheduleDeferredUpdate starts the timer running the deferred update once it is due, waiting at least minDelay.
+// The timer belongs to the client, so the update starts with or without RunMainCycle.
+func (c *PepeunitClient) scheduleDeferredUpdate(minDelay time.Duration) {
+	c.updateMutex.Lock()
+	defer c.updateMutex.Unlock()
+	if c.deferredTimer != nil {
+		c.deferredTimer.Stop()
+		c.deferredTimer = nil
+	}
+	if c.deferredUpdate == nil {
+		return
+	}
+	delay := time.Until(c.deferredUpdate.NotBefore)
+	if delay < minDelay {
+		delay = minDelay
+	}
+	c.deferredTimer = time.AfterFunc(delay, c.runDeferredUpdate)
+}
+
+// stopDeferredUpdate stops the timer of the deferred update, the update stays persisted for the next start
+func (c *PepeunitClient) stopDeferredUpdate() {
+	c.updateMutex.Lock()
+	defer c.updateMutex.Unlock()
+	if c.deferredTimer != nil {
+		c.deferredTimer.Stop()
+		c.deferredTimer = nil
+	}
+}
+
+// loadDeferredUpdate restores an update deferred before the restart
+func (c *PepeunitClient) loadDeferredUpdate() {
+	data, err := os.ReadFile(c.deferredUpdateFilePath())
+	if err != nil {
+		return
+	}
+	var deferred deferredUpdate
+	if err := json.Unmarshal(data, &deferred); err != nil || deferred.Payload == "" {
+		c.logger.Warning(fmt.Sprintf("Ignoring invalid deferred update file %s", c.deferredUpdateFilePath()), true)
+		_ = os.Remove(c.deferredUpdateFilePath())
+		return
+	}
+	c.deferredUpdate = &deferred
+	c.updateStatus = deferredUpdateStatus(deferred)
+}
+
+// runDeferredUpdate applies the deferred update when its timer fires and its maintenance window is open.
+// If the window closed meanwhile the update is deferred to the next one.
+func (c *PepeunitClient) runDeferredUpdate() {
+	if !c.beginHandler() {
+		return
+	}
+	defer c.handlersWG.Done()
+
+	c.updateMutex.RLock()
+	deferred := c.deferredUpdate
+	c.updateMutex.RUnlock()
+	if deferred == nil {
+		return
+	}
+	now := time.Now()
+	if now.Before(deferred.NotBefore) {
+		c.scheduleDeferredUpdate(0)
+		return
+	}
+
+	if open := nextWindowOpen(now, c.updateWindows()); open.After(now) {
+		redeferred := *deferred
+		redeferred.NotBefore = c.updateNotBefore(now).UTC()
+		if err := NewFileManager().WriteJSON(c.deferredUpdateFilePath(), redeferred); err != nil {
+			c.logger.Warning(fmt.Sprintf("Failed to persist deferred update: %v", err))
+		}
+		c.setUpdateDeferred(redeferred)
+		c.logger.Info(fmt.Sprintf("Update window closed, deferred until %s", redeferred.NotBefore.Format(time.RFC3339)))
+		return
+	}
+
+	// Report the last finished update again until the deferred one starts
+	status := UpdateStatus{Phase: UpdatePhaseIdle}
+	if history, err := c.GetUpdateHistory(); err == nil && len(history) > 0 {
+		status = history[len(history)-1]
+	}
+	c.updateMutex.Lock()
+	c.deferredUpdate = nil
+	c.deferredTimer = nil
+	c.updateStatus = status
+	c.updateMutex.Unlock()
+	_ = os.Remove(c.deferredUpdateFilePath())
+	c.publishUpdateStatus()
+
+	// The running version may have changed while the update waited
+	if !c.acceptUpdate(deferred.Payload) {
+		return
+	}
+	c.logger.Info("Starting deferred update")
+	c.applyUpdatePayload(context.Background(), deferred.Payload)
+}
@@ -0,0 +1,156 @@
+package pepeunit_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+func TestUpdateWindowContains(t *testing.T) {
+	windows, err := pepeunit.ParseUpdateWindows("02:00-04:30, 23:00-01:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2026, time.October, 16, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		t    time.Time
+		want [2]bool
+	}{
+		{t: at(2, 0), want: [2]bool{true, false}},
+		{t: at(4, 30), want: [2]bool{false, false}},
+		{t: at(23, 30), want: [2]bool{false, true}},
+		{t: at(0, 59), want: [2]bool{false, true}},
+		{t: at(12, 0), want: [2]bool{false, false}},
+	}
+	for _, tt := range tests {
+		for i, window := range windows {
+			if got := window.Contains(tt.t); got != tt.want[i] {
+				t.Errorf("window %d Contains(%s) = %v", i, tt.t.Format("15:04"), got)
+			}
+		}
+	}
+
+	for _, value := range []string{"02:00", "25:00-03:00", "02:00-3pm"} {
+		if _, err := pepeunit.ParseUpdateWindows(value); err == nil {
+			t.Errorf("ParseUpdateWindows(%q) accepted", value)
+		}
+	}
+}
+
+func TestUpdateJitterStaysInsideWindow(t *testing.T) {
+	// A window opened a minute ago that closes in two minutes, with a jitter far longer than that
+	start := time.Now().Truncate(time.Minute).Add(-time.Minute)
+	end := start.Add(3 * time.Minute)
+	fixture := pepeunittest.NewTestFixture(t)
+	fixture.Env["PU_UPDATE_WINDOW"] = start.Format("15:04") + "-" + end.Format("15:04")
+	if err := pepeunittest.WriteJSONFile(fixture.EnvPath, fixture.Env); err != nil {
+		t.Fatal(err)
+	}
+	config := fixture.Config()
+	config.UpdateJitter = 24 * time.Hour
+	client, err := fixture.NewClientWith(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started int32
+	client.SetCustomUpdateHandler(func(client *pepeunit.PepeunitClient, payload string) error {
+		atomic.AddInt32(&started, 1)
+		return nil
+	})
+
+	fixture.MQTT.Inject(pepeunittest.BaseTopic(fixture.UnitUUID, string(pepeunit.BaseInputTopicTypeUpdatePepeunit)), `{"PU_COMMIT_VERSION": "v2.0.0"}`)
+	status := client.GetUpdateStatus()
+	if status.Phase != pepeunit.UpdatePhaseDeferred {
+		t.Fatalf("status = %+v, want deferred", status)
+	}
+	until, err := time.Parse(time.RFC3339, status.DeferredUntil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until.After(end) {
+		t.Errorf("deferred until %v, after the window closes at %v", until, end)
+	}
+	if atomic.LoadInt32(&started) != 0 {
+		t.Error("deferred update started at once")
+	}
+}
+
+// writeDueDeferredUpdate persists an update that became due a minute ago, as left by a previous process
+func writeDueDeferredUpdate(t *testing.T, fixture *pepeunittest.Fixture) string {
+	t.Helper()
+	path := filepath.Join(fixture.Dir, "update_deferred.json")
+	deferred := map[string]interface{}{
+		"payload":     `{"PU_COMMIT_VERSION": "v2.0.0"}`,
+		"version":     "v2.0.0",
+		"received_at": time.Now().Add(-time.Hour).UTC(),
+		"not_before":  time.Now().Add(-time.Minute).UTC(),
+	}
+	data, err := json.Marshal(deferred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDeferredUpdateRunsWithoutMainCycle(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	path := writeDueDeferredUpdate(t, fixture)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan string, 1)
+	client.SetCustomUpdateHandler(func(client *pepeunit.PepeunitClient, payload string) error {
+		started <- payload
+		return nil
+	})
+
+	select {
+	case payload := <-started:
+		if payload != `{"PU_COMMIT_VERSION": "v2.0.0"}` {
+			t.Errorf("started payload %s", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("restored deferred update never started")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("deferred update file kept after the start: %v", err)
+	}
+}
+
+func TestCloseStopsDeferredUpdate(t *testing.T) {
+	fixture := pepeunittest.NewTestFixture(t)
+	path := writeDueDeferredUpdate(t, fixture)
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started int32
+	client.SetCustomUpdateHandler(func(client *pepeunit.PepeunitClient, payload string) error {
+		atomic.AddInt32(&started, 1)
+		return nil
+	})
+	if err := client.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if atomic.LoadInt32(&started) != 0 {
+		t.Error("deferred update started after Close")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("deferred update not kept for the next start: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package pepeunit
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// UpdatePublicKey is a base64 Ed25519 public key pinned at build time, e.g.
+// -ldflags "-X github.com/w7a8n1y4a/pepeunit_go_client.UpdatePublicKey=<key>".
+// It takes precedence over PU_UPDATE_PUBLIC_KEY from settings.
+var UpdatePublicKey string
+
+// UpdateVerification holds the expected integrity data of an update artifact
+type UpdateVerification struct {
+	// SHA256 is the hex encoded SHA-256 digest of the artifact
+	SHA256 string
+	// Signature is the base64 Ed25519 signature of the raw SHA-256 digest of the artifact
+	Signature string
+}
+
+// updateVerificationFromPayload reads the verification fields of an update payload
+func updateVerificationFromPayload(meta map[string]interface{}) UpdateVerification {
+	sha, _ := meta["COMPILED_FIRMWARE_SHA256"].(string)
+	signature, _ := meta["COMPILED_FIRMWARE_SIGNATURE"].(string)
+	return UpdateVerification{SHA256: sha, Signature: signature}
+}
+
+// updatePublicKey returns the pinned update public key, nil when none is configured
+func (c *PepeunitClient) updatePublicKey() (ed25519.PublicKey, error) {
+	keyB64 := UpdatePublicKey
+	if keyB64 == "" {
+		keyB64, _ = c.settings.GetString("PU_UPDATE_PUBLIC_KEY")
+	}
+	if keyB64 == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(keyB64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode update public key: %v", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid update public key length")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// checkUnverifiedUpdate refuses an update entry point without verification data while a public key is pinned,
+// such an update would only fail after its download
+func (c *PepeunitClient) checkUnverifiedUpdate(verifiedName string) error {
+	key, err := c.updatePublicKey()
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		return fmt.Errorf("update public key is pinned, updates require a signature: use %s", verifiedName)
+	}
+	return nil
+}
+
+// fileSHA256 returns the SHA-256 digest of a file
+func fileSHA256(filePath string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// VerifyUpdateFile checks a downloaded update artifact against the expected digest and signature.
+// When an update public key is pinned, a valid signature is required.
+func (c *PepeunitClient) VerifyUpdateFile(filePath string, verification UpdateVerification) error {
+	key, err := c.updatePublicKey()
+	if err != nil {
+		return err
+	}
+	if key == nil && verification.Signature != "" {
+		c.logger.Warning("Update signature present but no public key is pinned, skipping signature check")
+	}
+	if key == nil && verification.SHA256 == "" {
+		return nil
+	}
+
+	digest, err := fileSHA256(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to hash update file: %v", err)
+	}
+
+	if verification.SHA256 != "" {
+		actual := hex.EncodeToString(digest)
+		if !strings.EqualFold(actual, verification.SHA256) {
+			return fmt.Errorf("sha256 mismatch: expected %s, got %s", verification.SHA256, actual)
+		}
+	}
+
+	if key == nil {
+		return nil
+	}
+	if verification.Signature == "" {
+		return fmt.Errorf("update signature is required")
+	}
+	signature, err := base64.StdEncoding.DecodeString(verification.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode update signature: %v", err)
+	}
+	if !ed25519.Verify(key, digest, signature) {
+		return fmt.Errorf("invalid update signature")
+	}
+	return nil
+}
+
+// verifyUpdate verifies an update artifact and reports a failure to the log topic
+func (c *PepeunitClient) verifyUpdate(filePath string, verification UpdateVerification) error {
+	if err := c.VerifyUpdateFile(filePath, verification); err != nil {
+		c.logger.Error(fmt.Sprintf("Update verification failed: %v", err))
+		return fmt.Errorf("update verification failed: %v", err)
+	}
+	return nil
+}
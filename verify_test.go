@@ -0,0 +1,123 @@
+package pepeunit_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pepeunit "github.com/w7a8n1y4a/pepeunit_go_client"
+	"github.com/w7a8n1y4a/pepeunit_go_client/pepeunittest"
+)
+
+// newVerifyingClient returns a fixture client, publicKey is written to PU_UPDATE_PUBLIC_KEY when set
+func newVerifyingClient(t *testing.T, publicKey ed25519.PublicKey) (*pepeunittest.Fixture, *pepeunit.PepeunitClient) {
+	t.Helper()
+	fixture := pepeunittest.NewTestFixture(t)
+	if publicKey != nil {
+		fixture.Env["PU_UPDATE_PUBLIC_KEY"] = base64.StdEncoding.EncodeToString(publicKey)
+		if err := pepeunittest.WriteJSONFile(fixture.EnvPath, fixture.Env); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client, err := fixture.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fixture, client
+}
+
+func TestVerifyUpdateFile(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	artifact := filepath.Join(t.TempDir(), "firmware")
+	if err := os.WriteFile(artifact, []byte("firmware"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	signed := pepeunittest.SignUpdate([]byte("firmware"), privateKey)
+	other := pepeunittest.SignUpdate([]byte("other firmware"), privateKey)
+
+	tests := []struct {
+		name         string
+		pinned       bool
+		verification pepeunit.UpdateVerification
+		wantErr      string
+	}{
+		{name: "nothing to check"},
+		{name: "digest", verification: pepeunit.UpdateVerification{SHA256: strings.ToUpper(signed.SHA256)}},
+		{name: "digest mismatch", verification: pepeunit.UpdateVerification{SHA256: other.SHA256}, wantErr: "sha256 mismatch"},
+		{name: "signature without key", verification: pepeunit.UpdateVerification{Signature: other.Signature}},
+		{name: "signed", pinned: true, verification: signed},
+		{name: "signature only", pinned: true, verification: pepeunit.UpdateVerification{Signature: signed.Signature}},
+		{name: "unsigned", pinned: true, verification: pepeunit.UpdateVerification{SHA256: signed.SHA256}, wantErr: "signature is required"},
+		{name: "signature of other file", pinned: true, verification: pepeunit.UpdateVerification{Signature: other.Signature}, wantErr: "invalid update signature"},
+		{name: "signature of other key", pinned: true, verification: pepeunittest.SignUpdate([]byte("firmware"), otherKey), wantErr: "invalid update signature"},
+		{name: "signature not base64", pinned: true, verification: pepeunit.UpdateVerification{Signature: "%%%"}, wantErr: "decode update signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var key ed25519.PublicKey
+			if tt.pinned {
+				key = publicKey
+			}
+			_, client := newVerifyingClient(t, key)
+			err := client.VerifyUpdateFile(artifact, tt.verification)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("VerifyUpdateFile() = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("VerifyUpdateFile() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPinnedKeyRefusesUnverifiedUpdates(t *testing.T) {
+	publicKey, _, _ := ed25519.GenerateKey(nil)
+	fixture, client := newVerifyingClient(t, publicKey)
+	ctx := context.Background()
+	archive := filepath.Join(fixture.Dir, "update.tar.gz")
+
+	updates := map[string]func() error{
+		"PerformUpdateVerified":       func() error { return client.PerformUpdate(ctx) },
+		"UpdateDeviceProgramVerified": func() error { return client.UpdateDeviceProgram(ctx, archive) },
+		"UpdateBinaryFromURLVerified": func() error { return client.UpdateBinaryFromURL(ctx, fixture.Server.FileURL("app")) },
+	}
+	for verified, update := range updates {
+		if err := update(); err == nil || !strings.Contains(err.Error(), verified) {
+			t.Errorf("unverified update error = %v, want a hint to %s", err, verified)
+		}
+	}
+	if requests := fixture.Server.Requests(); len(requests) != 0 {
+		t.Errorf("refused updates made requests %+v", requests)
+	}
+}
+
+func TestPerformUpdateVerified(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	archive, err := pepeunittest.BuildTarGz(map[string]string{"data/config.txt": "v2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture, client := newVerifyingClient(t, publicKey)
+	fixture.Server.SetFirmware(archive)
+	ctx := context.Background()
+
+	wrong := pepeunittest.SignUpdate([]byte("other archive"), privateKey)
+	if err := client.PerformUpdateVerified(ctx, wrong); err == nil || !strings.Contains(err.Error(), "verification failed") {
+		t.Fatalf("PerformUpdateVerified() with a wrong digest = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(fixture.Dir, "data", "config.txt")); !os.IsNotExist(err) {
+		t.Fatalf("archive applied despite failed verification: %v", err)
+	}
+
+	if err := client.PerformUpdateVerified(ctx, pepeunittest.SignUpdate(archive, privateKey)); err != nil {
+		t.Fatalf("PerformUpdateVerified() = %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(fixture.Dir, "data", "config.txt")); err != nil || string(data) != "v2" {
+		t.Errorf("config.txt = %q, %v", data, err)
+	}
+}
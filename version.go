@@ -0,0 +1,202 @@
+package pepeunit
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var commitHashPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
+// SemVer is a parsed semantic version, a leading "v" is accepted
+type SemVer struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease []string
+}
+
+// ParseSemVer parses dotted versions like "1.2.3" or "v1.2.3-rc.1+build.5".
+// Major, minor and patch are all required, so plain numbers are not taken for versions.
+func ParseSemVer(version string) (SemVer, error) {
+	value := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	value, _, _ = strings.Cut(value, "+")
+	core, prerelease, hasPrerelease := strings.Cut(value, "-")
+
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return SemVer{}, fmt.Errorf("invalid semantic version: '%s'", version)
+	}
+	numbers := [3]int{}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || strings.HasPrefix(part, "+") {
+			return SemVer{}, fmt.Errorf("invalid semantic version: '%s'", version)
+		}
+		numbers[i] = n
+	}
+
+	semver := SemVer{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}
+	if hasPrerelease {
+		if prerelease == "" {
+			return SemVer{}, fmt.Errorf("invalid semantic version: '%s'", version)
+		}
+		semver.Prerelease = strings.Split(prerelease, ".")
+	}
+	return semver, nil
+}
+
+// Compare returns -1, 0 or 1 when v is lower, equal or higher than other, following semver precedence
+func (v SemVer) Compare(other SemVer) int {
+	for _, pair := range [][2]int{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if pair[0] != pair[1] {
+			return compareInts(pair[0], pair[1])
+		}
+	}
+
+	// A version without prerelease has higher precedence than one with it
+	switch {
+	case len(v.Prerelease) == 0 && len(other.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(other.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(other.Prerelease); i++ {
+		a, b := v.Prerelease[i], other.Prerelease[i]
+		if a == b {
+			continue
+		}
+		na, errA := strconv.Atoi(a)
+		nb, errB := strconv.Atoi(b)
+		switch {
+		case errA == nil && errB == nil:
+			return compareInts(na, nb)
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		case a < b:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return compareInts(len(v.Prerelease), len(other.Prerelease))
+}
+
+// String returns the version without a leading "v"
+func (v SemVer) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	return s
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// IsCommitHash reports whether version looks like a full or abbreviated git commit hash of 7 to 40 hex characters.
+// An abbreviated hash may consist of digits only.
+func IsCommitHash(version string) bool {
+	return commitHashPattern.MatchString(version)
+}
+
+// CompareVersions compares two versions. Semantic versions are ordered, commit hashes and other
+// values can only be equal or different, in which case ok is false.
+func CompareVersions(a, b string) (result int, ok bool) {
+	if SameVersion(a, b) {
+		return 0, true
+	}
+	if IsCommitHash(a) || IsCommitHash(b) {
+		return 0, false
+	}
+	va, errA := ParseSemVer(a)
+	vb, errB := ParseSemVer(b)
+	if errA != nil || errB != nil {
+		return 0, false
+	}
+	return va.Compare(vb), true
+}
+
+// SameVersion reports whether both values name the same version, an abbreviated commit hash matches its full form
+func SameVersion(a, b string) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if a == "" || b == "" {
+		return a == b
+	}
+	if IsCommitHash(a) && IsCommitHash(b) {
+		a, b = strings.ToLower(a), strings.ToLower(b)
+		return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+	}
+	if va, err := ParseSemVer(a); err == nil {
+		if vb, err := ParseSemVer(b); err == nil {
+			return va.Compare(vb) == 0
+		}
+	}
+	return a == b
+}
+
+// versionDecision is the result of the update version policy
+type versionDecision struct {
+	apply   bool
+	refused bool
+	reason  string
+}
+
+// checkUpdateMinVersion refuses update targets below the lowest accepted version of the client configuration
+func (c *PepeunitClient) checkUpdateMinVersion(targetVersion string) error {
+	if targetVersion == "" {
+		return fmt.Errorf("payload has no PU_COMMIT_VERSION to compare with the lowest accepted version %s", c.updateMinVersion)
+	}
+	cmp, ok := CompareVersions(targetVersion, c.updateMinVersion)
+	if !ok {
+		return fmt.Errorf("target version %s can not be compared with the lowest accepted version %s", targetVersion, c.updateMinVersion)
+	}
+	if cmp < 0 {
+		return fmt.Errorf("target version %s is below the lowest accepted version %s", targetVersion, c.updateMinVersion)
+	}
+	return nil
+}
+
+// checkUpdateVersion decides whether an update to targetVersion should be applied.
+// The payload may allow a downgrade with PU_ALLOW_DOWNGRADE and require a minimum running version with PU_MIN_VERSION.
+func (c *PepeunitClient) checkUpdateVersion(targetVersion string, meta map[string]interface{}) versionDecision {
+	current := c.settings.PU_COMMIT_VERSION
+
+	if SameVersion(current, targetVersion) {
+		return versionDecision{reason: fmt.Sprintf("current version %s = target version", current)}
+	}
+
+	if minVersion, _ := meta["PU_MIN_VERSION"].(string); minVersion != "" {
+		cmp, ok := CompareVersions(current, minVersion)
+		if !ok {
+			return versionDecision{refused: true, reason: fmt.Sprintf("current version %s can not be compared with required minimum %s", current, minVersion)}
+		}
+		if cmp < 0 {
+			return versionDecision{refused: true, reason: fmt.Sprintf("current version %s is below required minimum %s", current, minVersion)}
+		}
+	}
+
+	cmp, ok := CompareVersions(targetVersion, current)
+	if !ok {
+		return versionDecision{apply: true, reason: fmt.Sprintf("target version %s differs from current version %s", targetVersion, current)}
+	}
+	if cmp > 0 {
+		return versionDecision{apply: true, reason: fmt.Sprintf("upgrade from %s to %s", current, targetVersion)}
+	}
+	if toBool(meta["PU_ALLOW_DOWNGRADE"]) {
+		return versionDecision{apply: true, reason: fmt.Sprintf("downgrade from %s to %s allowed by payload", current, targetVersion)}
+	}
+	return versionDecision{refused: true, reason: fmt.Sprintf("downgrade from %s to %s refused", current, targetVersion)}
+}
@@ -0,0 +1,217 @@
+package pepeunit
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSemVer(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    SemVer
+		wantErr bool
+	}{
+		{name: "full", version: "1.2.3", want: SemVer{Major: 1, Minor: 2, Patch: 3}},
+		{name: "leading v", version: "v1.2.0", want: SemVer{Major: 1, Minor: 2}},
+		{name: "major only", version: "4", wantErr: true},
+		{name: "major and minor", version: "v1.2", wantErr: true},
+		{name: "digits only", version: "1234567", wantErr: true},
+		{name: "empty part", version: "1..3", wantErr: true},
+		{name: "signed part", version: "1.+2.3", wantErr: true},
+		{name: "prerelease and build", version: "v1.2.3-rc.1+build.5", want: SemVer{Major: 1, Minor: 2, Patch: 3, Prerelease: []string{"rc", "1"}}},
+		{name: "surrounding spaces", version: " 0.1.0 ", want: SemVer{Minor: 1}},
+		{name: "empty", version: "", wantErr: true},
+		{name: "too many parts", version: "1.2.3.4", wantErr: true},
+		{name: "negative", version: "1.-2.3", wantErr: true},
+		{name: "not a number", version: "1.x.3", wantErr: true},
+		{name: "empty prerelease", version: "1.2.3-", wantErr: true},
+		{name: "commit hash", version: "a1b2c3d", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSemVer(tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSemVer(%q) error = %v, wantErr %v", tt.version, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSemVer(%q) = %+v, want %+v", tt.version, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		want   int
+		wantOK bool
+	}{
+		{a: "1.2.3", b: "1.2.3", want: 0, wantOK: true},
+		{a: "v1.2.0", b: "1.2.0", want: 0, wantOK: true},
+		{a: "1.2.4", b: "1.2.3", want: 1, wantOK: true},
+		{a: "1.10.0", b: "1.9.0", want: 1, wantOK: true},
+		{a: "0.9.9", b: "1.0.0", want: -1, wantOK: true},
+		{a: "1.0.0-rc.1", b: "1.0.0", want: -1, wantOK: true},
+		{a: "1.0.0-rc.2", b: "1.0.0-rc.10", want: -1, wantOK: true},
+		{a: "1.0.0-alpha", b: "1.0.0-1", want: 1, wantOK: true},
+		{a: "1.0.0-alpha.1", b: "1.0.0-alpha", want: 1, wantOK: true},
+		{a: "1.0.0-beta", b: "1.0.0-alpha", want: 1, wantOK: true},
+		{a: "a1b2c3d", b: "a1b2c3d4e5f6", want: 0, wantOK: true},
+		{a: "a1b2c3d", b: "1.0.0"},
+		{a: "a1b2c3d", b: "b1b2c3d"},
+		{a: "main", b: "1.0.0"},
+		{a: "20261016", b: "20261015"},
+		{a: "1234567", b: "1.0.0"},
+		{a: "1.2", b: "1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"_"+tt.b, func(t *testing.T) {
+			got, ok := CompareVersions(tt.a, tt.b)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("CompareVersions(%q, %q) = %d, %v, want %d, %v", tt.a, tt.b, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestIsCommitHash(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{version: "a1b2c3d", want: true},
+		{version: "A1B2C3D4E5F6", want: true},
+		{version: "0123456789abcdef0123456789abcdef01234567", want: true},
+		{version: "1234567", want: true},
+		{version: "20261016", want: true},
+		{version: "abc123", want: false},
+		{version: "123456", want: false},
+		{version: "0123456789abcdef0123456789abcdef012345678", want: false},
+		{version: "1.2.3", want: false},
+		{version: "g1b2c3d", want: false},
+		{version: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if got := IsCommitHash(tt.version); got != tt.want {
+				t.Errorf("IsCommitHash(%q) = %v, want %v", tt.version, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "", b: "", want: true},
+		{a: "", b: "1.0.0", want: false},
+		{a: "v1.0.0", b: "1.0.0", want: true},
+		{a: "1.0", b: "1.0.0", want: false},
+		{a: "1234567", b: "1234567890", want: true},
+		{a: "1.0.0+build.1", b: "1.0.0+build.2", want: true},
+		{a: "1.0.0-rc.1", b: "1.0.0", want: false},
+		{a: "A1B2C3D", b: "a1b2c3d4e5f6", want: true},
+		{a: "a1b2c3d", b: "a1b2c3e", want: false},
+		{a: "main", b: "main", want: true},
+		{a: "main", b: "dev", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"_"+tt.b, func(t *testing.T) {
+			if got := SameVersion(tt.a, tt.b); got != tt.want {
+				t.Errorf("SameVersion(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckUpdateVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     string
+		target      string
+		meta        map[string]interface{}
+		wantApply   bool
+		wantRefused bool
+		wantReason  string
+	}{
+		{name: "same version", current: "1.0.0", target: "v1.0.0", wantReason: "= target version"},
+		{name: "upgrade", current: "1.0.0", target: "1.1.0", wantApply: true, wantReason: "upgrade"},
+		{name: "downgrade refused", current: "1.1.0", target: "1.0.0", wantRefused: true, wantReason: "refused"},
+		{name: "downgrade allowed", current: "1.1.0", target: "1.0.0", meta: map[string]interface{}{"PU_ALLOW_DOWNGRADE": "true"}, wantApply: true, wantReason: "allowed by payload"},
+		{name: "commit hashes differ", current: "a1b2c3d", target: "b1b2c3d", wantApply: true, wantReason: "differs"},
+		{name: "below payload minimum", current: "1.0.0", target: "2.0.0", meta: map[string]interface{}{"PU_MIN_VERSION": "1.5.0"}, wantRefused: true, wantReason: "below required minimum"},
+		{name: "payload minimum met", current: "1.5.0", target: "2.0.0", meta: map[string]interface{}{"PU_MIN_VERSION": "1.5.0"}, wantApply: true},
+		{name: "payload minimum incomparable", current: "a1b2c3d", target: "2.0.0", meta: map[string]interface{}{"PU_MIN_VERSION": "1.5.0"}, wantRefused: true, wantReason: "can not be compared"},
+		{name: "short hashes differ", current: "1234567", target: "7654321", wantApply: true, wantReason: "differs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &PepeunitClient{settings: &Settings{PU_COMMIT_VERSION: tt.current}}
+			meta := tt.meta
+			if meta == nil {
+				meta = map[string]interface{}{}
+			}
+			got := c.checkUpdateVersion(tt.target, meta)
+			if got.apply != tt.wantApply || got.refused != tt.wantRefused {
+				t.Fatalf("checkUpdateVersion(%q) = %+v, want apply %v refused %v", tt.target, got, tt.wantApply, tt.wantRefused)
+			}
+			if !strings.Contains(got.reason, tt.wantReason) {
+				t.Errorf("reason %q does not contain %q", got.reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestAcceptUpdateMinVersion(t *testing.T) {
+	tests := []struct {
+		name                 string
+		ffVersionCheckEnable bool
+		payload              string
+		want                 bool
+	}{
+		{name: "above", payload: `{"PU_COMMIT_VERSION": "1.3.0"}`, want: true},
+		{name: "equal", payload: `{"PU_COMMIT_VERSION": "v1.2.0"}`, want: true},
+		{name: "below", payload: `{"PU_COMMIT_VERSION": "1.1.9"}`},
+		{name: "below with version check", ffVersionCheckEnable: true, payload: `{"PU_COMMIT_VERSION": "1.1.9"}`},
+		{name: "commit hash", payload: `{"PU_COMMIT_VERSION": "a1b2c3d"}`},
+		{name: "without version", payload: `{"COMPILED_FIRMWARE_LINK": "https://example.test/fw"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := NewSettingsWith("", map[string]interface{}{"PU_COMMIT_VERSION": "1.0.0"})
+			c := &PepeunitClient{
+				settings:             settings,
+				logger:               NewLogger("", nil, nil, settings, false),
+				updateMinVersion:     "1.2.0",
+				ffVersionCheckEnable: tt.ffVersionCheckEnable,
+			}
+			if got := c.acceptUpdate(tt.payload); got != tt.want {
+				t.Errorf("acceptUpdate(%s) = %v, want %v", tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateMinVersionConfig(t *testing.T) {
+	dir := t.TempDir()
+	_, err := NewPepeunitClient(PepeunitClientConfig{
+		EnvFilePath:      filepath.Join(dir, "env.json"),
+		SchemaFilePath:   filepath.Join(dir, "schema.json"),
+		LogFilePath:      filepath.Join(dir, "log.json"),
+		UpdateMinVersion: "a1b2c3d",
+	})
+	if err == nil || !strings.Contains(err.Error(), "UpdateMinVersion") {
+		t.Errorf("NewPepeunitClient() error = %v, want an UpdateMinVersion error", err)
+	}
+}